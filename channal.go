@@ -2,8 +2,10 @@ package gim
 
 import (
 	"fmt"
+	"github.com/kkakoz/gim/pkg/errno"
 	"github.com/kkakoz/gim/pkg/gox"
 	"github.com/kkakoz/gim/pkg/logger"
+	"github.com/kkakoz/gim/proto/pkt"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"sync"
@@ -20,16 +22,42 @@ type IChannel interface {
 	SetReadWait(time.Duration)
 }
 
+// MetaChannel 带有连接属性的IChannel，内置的Channel都实现了此接口
+type MetaChannel interface {
+	// Meta 握手时确定的连接属性，连接建立后不再变化
+	Meta() *ChannelMeta
+}
+
+var _ MetaChannel = (*Channel)(nil)
+
+// ChannelMetaOf 返回连接属性，channel没有实现MetaChannel时返回nil
+func ChannelMetaOf(channel IChannel) *ChannelMeta {
+	if mc, ok := channel.(MetaChannel); ok {
+		return mc.Meta()
+	}
+	return nil
+}
+
+// ChannelMeta 连接属性，IndexedChannelMap按这些属性建立索引
+type ChannelMeta struct {
+	Account string
+	Device  string
+	Tags    []string
+	Attrs   map[string]string
+}
+
 // Channel is a websocket implement of channel
 type Channel struct {
 	sync.Mutex
 	id string
 	Conn
 	writechan chan []byte
+	writeDone *Event
 	once      sync.Once
 	writeWait time.Duration
 	readWait  time.Duration
 	state     int32 // 0 init 1 start 2 close
+	meta      *ChannelMeta
 }
 
 func (ch *Channel) SetWriteWait(duration time.Duration) {
@@ -44,12 +72,26 @@ func (ch *Channel) ID() string {
 	return ch.id
 }
 
+func (ch *Channel) Meta() *ChannelMeta {
+	return ch.meta
+}
+
+// Close 停止写入，等待缓存的消息发送完后关闭连接
 func (ch *Channel) Close() error {
-	if !atomic.CompareAndSwapInt32(&ch.state, 1, 2) {
-		return fmt.Errorf("channel has started")
+	ch.Lock()
+	if atomic.LoadInt32(&ch.state) == 2 {
+		ch.Unlock()
+		return fmt.Errorf("channel %s has closed", ch.id)
 	}
+	atomic.StoreInt32(&ch.state, 2)
 	close(ch.writechan)
-	return nil
+	ch.Unlock()
+
+	select {
+	case <-ch.writeDone.Done():
+	case <-time.After(ch.writeWait):
+	}
+	return ch.Conn.Close()
 }
 
 func NewChannel(id string, conn Conn, options ...channelOptionFunc) IChannel {
@@ -61,9 +103,12 @@ func NewChannel(id string, conn Conn, options ...channelOptionFunc) IChannel {
 		id:        id,
 		Conn:      conn,
 		writechan: make(chan []byte, 5),
+		writeDone: NewEvent(),
 		writeWait: time.Second * 10, //default value
+		meta:      channelOpt.meta,
 	}
 	gox.Go(func() {
+		defer ch.writeDone.Fire()
 		log := logger.WithFields(zap.String("struct", "Channel"), zap.String("func", "writeLoop"), zap.String("id", ch.id))
 		err := ch.writeLoop()
 		if err != nil {
@@ -97,12 +142,18 @@ func (ch *Channel) writeLoop() error {
 }
 
 func (ch *Channel) Push(payload []byte) error {
+	ch.Lock()
+	defer ch.Unlock()
 	if atomic.LoadInt32(&ch.state) != 1 {
-		return fmt.Errorf("channel %s has closed", ch.id)
+		return errno.Statusf(pkt.Status_ConnectionClosed, "channel %s has closed", ch.id)
+	}
+	// 异步写，写循环退出后不再阻塞
+	select {
+	case ch.writechan <- payload:
+		return nil
+	case <-ch.writeDone.Done():
+		return errno.Statusf(pkt.Status_ConnectionClosed, "channel %s has closed", ch.id)
 	}
-	// 异步写
-	ch.writechan <- payload
-	return nil
 }
 
 // overwrite Conn
@@ -121,10 +172,10 @@ func (ch *Channel) ReadLoop(lst MessageListener) error {
 
 		frame, err := ch.ReadFrame()
 		if err != nil {
-			return err
+			return TransportError(err)
 		}
 		if frame.GetOpCode() == OpClose {
-			return errors.New("remote side close the channel")
+			return errno.ErrConnClosed
 		}
 		if frame.GetOpCode() == OpPing {
 			log.Info("recv a ping; resp with a pong")
@@ -0,0 +1,192 @@
+package gim
+
+import "sync"
+
+// 内置索引，自定义属性的索引名为AttrIndex(key)
+const (
+	IndexAccount = "account"
+	IndexDevice  = "device"
+	IndexTag     = "tag"
+)
+
+func AttrIndex(key string) string {
+	return "attr." + key
+}
+
+// IndexedChannelMap 可以按连接属性查询的连接管理器，Add和Remove时自动维护索引
+type IndexedChannelMap interface {
+	IChannelMap
+	// Lookup 返回index属性等于value的连接
+	Lookup(index, value string) []IChannel
+	ByAccount(account string) []IChannel
+	ByDevice(device string) []IChannel
+	ByTag(tag string) []IChannel
+	ByAttr(key, value string) []IChannel
+}
+
+// IndexOptions 索引的配置
+type IndexOptions struct {
+	// 只索引这些key的自定义属性，属性由客户端在握手时上报，不能全部索引
+	Attrs []string
+}
+
+type IndexOptionsFunc func(options *IndexOptions)
+
+// WithIndexedAttrs 索引自定义属性中的keys，默认不索引自定义属性
+func WithIndexedAttrs(keys ...string) IndexOptionsFunc {
+	return func(options *IndexOptions) {
+		options.Attrs = append(options.Attrs, keys...)
+	}
+}
+
+// indexedChannelMap 连接存放在channelMap中，Get不经过索引的锁。
+// 索引和channelMap一样按hash分片，Add和Remove只锁连接id所在的分片和它的属性所在的分片
+type indexedChannelMap struct {
+	*channelMap
+	locks  []sync.Mutex // 按id分片，保证同一个id的Add和Remove串行
+	attrs  map[string]struct{}
+	shards []*indexShard
+}
+
+type indexShard struct {
+	sync.RWMutex
+	values map[string]map[string]IChannel // indexKey(index, value) -> id -> channel
+}
+
+var _ IndexedChannelMap = (*indexedChannelMap)(nil)
+
+// NewIndexedChannelMap 连接没有实现MetaChannel时只按ID管理，不建立索引
+func NewIndexedChannelMap(opts ...IndexOptionsFunc) *indexedChannelMap {
+	options := &IndexOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	channels := NewChannelMap()
+	c := &indexedChannelMap{
+		channelMap: channels,
+		locks:      make([]sync.Mutex, len(channels.shards)),
+		attrs:      make(map[string]struct{}, len(options.Attrs)),
+		shards:     make([]*indexShard, len(channels.shards)),
+	}
+	for i := range c.shards {
+		c.shards[i] = &indexShard{values: make(map[string]map[string]IChannel)}
+	}
+	for _, key := range options.Attrs {
+		c.attrs[key] = struct{}{}
+	}
+	return c
+}
+
+// indexKey index中不含\x00
+func indexKey(index, value string) string {
+	return index + "\x00" + value
+}
+
+func (c *indexedChannelMap) indexShard(key string) *indexShard {
+	return c.shards[fnv32(key)&c.mask]
+}
+
+// Add 同一个id已经存在时替换旧连接的索引
+func (c *indexedChannelMap) Add(channel IChannel) {
+	lock := &c.locks[fnv32(channel.ID())&c.mask]
+	lock.Lock()
+	defer lock.Unlock()
+	if old, ok := c.channelMap.Get(channel.ID()); ok {
+		c.unindex(old)
+	}
+	c.channelMap.Add(channel)
+	c.index(channel)
+}
+
+func (c *indexedChannelMap) Remove(id string) {
+	lock := &c.locks[fnv32(id)&c.mask]
+	lock.Lock()
+	defer lock.Unlock()
+	if old, ok := c.channelMap.Get(id); ok {
+		c.unindex(old)
+		c.channelMap.Remove(id)
+	}
+}
+
+func (c *indexedChannelMap) Lookup(index, value string) []IChannel {
+	key := indexKey(index, value)
+	s := c.indexShard(key)
+	s.RLock()
+	defer s.RUnlock()
+	channels := s.values[key]
+	res := make([]IChannel, 0, len(channels))
+	for _, ch := range channels {
+		res = append(res, ch)
+	}
+	return res
+}
+
+func (c *indexedChannelMap) ByAccount(account string) []IChannel {
+	return c.Lookup(IndexAccount, account)
+}
+
+func (c *indexedChannelMap) ByDevice(device string) []IChannel {
+	return c.Lookup(IndexDevice, device)
+}
+
+func (c *indexedChannelMap) ByTag(tag string) []IChannel {
+	return c.Lookup(IndexTag, tag)
+}
+
+// ByAttr key不在WithIndexedAttrs中时总是返回空
+func (c *indexedChannelMap) ByAttr(key, value string) []IChannel {
+	return c.Lookup(AttrIndex(key), value)
+}
+
+func (c *indexedChannelMap) index(channel IChannel) {
+	c.eachIndex(ChannelMetaOf(channel), func(index, value string) {
+		key := indexKey(index, value)
+		s := c.indexShard(key)
+		s.Lock()
+		defer s.Unlock()
+		channels, ok := s.values[key]
+		if !ok {
+			channels = make(map[string]IChannel)
+			s.values[key] = channels
+		}
+		channels[channel.ID()] = channel
+	})
+}
+
+// unindex 删除空的value，避免账号断开后索引一直增长
+func (c *indexedChannelMap) unindex(channel IChannel) {
+	c.eachIndex(ChannelMetaOf(channel), func(index, value string) {
+		key := indexKey(index, value)
+		s := c.indexShard(key)
+		s.Lock()
+		defer s.Unlock()
+		channels := s.values[key]
+		delete(channels, channel.ID())
+		if len(channels) == 0 {
+			delete(s.values, key)
+		}
+	})
+}
+
+// eachIndex 空值和不在白名单中的自定义属性不建立索引
+func (c *indexedChannelMap) eachIndex(meta *ChannelMeta, fn func(index, value string)) {
+	if meta == nil {
+		return
+	}
+	if meta.Account != "" {
+		fn(IndexAccount, meta.Account)
+	}
+	if meta.Device != "" {
+		fn(IndexDevice, meta.Device)
+	}
+	for _, tag := range meta.Tags {
+		if tag != "" {
+			fn(IndexTag, tag)
+		}
+	}
+	for key := range c.attrs {
+		if value, ok := meta.Attrs[key]; ok && value != "" {
+			fn(AttrIndex(key), value)
+		}
+	}
+}
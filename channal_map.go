@@ -1,6 +1,13 @@
 package gim
 
-import "github.com/kkakoz/gim/pkg/mapx"
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kkakoz/gim/pkg/gox"
+)
 
 // IChannelMap 连接管理器，Server在内部会自动管理连接的生命周期
 type IChannelMap interface {
@@ -10,30 +17,166 @@ type IChannelMap interface {
 	All() []IChannel
 }
 
+// ChannelRanger 可以不复制地遍历和计数的IChannelMap，内置的实现都支持，
+// 自定义的IChannelMap不实现时由RangeChannels和ChannelCount回退到All
+type ChannelRanger interface {
+	// Range 遍历所有连接而不复制，fn返回false时停止，fn中不能调用Add和Remove
+	Range(fn func(channel IChannel) bool)
+	Len() int
+}
+
+var _ ChannelRanger = (*channelMap)(nil)
+
+// RangeChannels 遍历channels中的连接，fn返回false时停止
+func RangeChannels(channels IChannelMap, fn func(channel IChannel) bool) {
+	if r, ok := channels.(ChannelRanger); ok {
+		r.Range(fn)
+		return
+	}
+	for _, ch := range channels.All() {
+		if !fn(ch) {
+			return
+		}
+	}
+}
+
+// ChannelCount 返回channels中的连接数
+func ChannelCount(channels IChannelMap) int {
+	if r, ok := channels.(ChannelRanger); ok {
+		return r.Len()
+	}
+	return len(channels.All())
+}
+
+// DefaultChannelShards channelMap默认的分片数
+const DefaultChannelShards = 64
+
+// channelMap 按id的hash分片的连接管理器，读只加分片的读锁，写只锁一个分片，计数不加锁
 type channelMap struct {
-	channels *mapx.SyncMap[string, IChannel]
+	shards []*channelShard
+	mask   uint32
+	count  int64
+}
+
+type channelShard struct {
+	sync.RWMutex
+	channels map[string]IChannel
 }
 
 func NewChannelMap() *channelMap {
-	return &channelMap{channels: mapx.NewSyncMap[string, IChannel]()}
+	return NewShardedChannelMap(DefaultChannelShards)
+}
+
+// NewShardedChannelMap shards向上取整为2的幂
+func NewShardedChannelMap(shards int) *channelMap {
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+	c := &channelMap{
+		shards: make([]*channelShard, n),
+		mask:   uint32(n - 1),
+	}
+	for i := range c.shards {
+		c.shards[i] = &channelShard{channels: make(map[string]IChannel)}
+	}
+	return c
+}
+
+func (c *channelMap) shard(id string) *channelShard {
+	return c.shards[fnv32(id)&c.mask]
+}
+
+// fnv32 fnv-1a
+func fnv32(s string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return h
 }
 
 func (c *channelMap) Add(channel IChannel) {
-	c.channels.Add(channel.ID(), channel)
+	s := c.shard(channel.ID())
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.channels[channel.ID()]; !ok {
+		atomic.AddInt64(&c.count, 1)
+	}
+	s.channels[channel.ID()] = channel
 }
 
 func (c *channelMap) Remove(id string) {
-	c.channels.Delete(id)
+	s := c.shard(id)
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.channels[id]; ok {
+		delete(s.channels, id)
+		atomic.AddInt64(&c.count, -1)
+	}
 }
 
 func (c *channelMap) Get(id string) (IChannel, bool) {
-	return c.channels.Get(id)
+	s := c.shard(id)
+	s.RLock()
+	defer s.RUnlock()
+	ch, ok := s.channels[id]
+	return ch, ok
 }
 
 func (c *channelMap) All() []IChannel {
-	return c.channels.Values()
+	res := make([]IChannel, 0, c.Len())
+	for _, s := range c.shards {
+		s.RLock()
+		for _, ch := range s.channels {
+			res = append(res, ch)
+		}
+		s.RUnlock()
+	}
+	return res
+}
+
+// Range 每次只锁一个分片
+func (c *channelMap) Range(fn func(channel IChannel) bool) {
+	for _, s := range c.shards {
+		s.RLock()
+		for _, ch := range s.channels {
+			if !fn(ch) {
+				s.RUnlock()
+				return
+			}
+		}
+		s.RUnlock()
+	}
+}
+
+func (c *channelMap) Len() int {
+	return int(atomic.LoadInt64(&c.count))
 }
 
 func NewChannels() *channelMap {
-	return &channelMap{}
+	return NewChannelMap()
+}
+
+// DrainChannels 关闭所有连接并等待它们从channels中移除，超时返回ctx.Err()
+func DrainChannels(ctx context.Context, channels IChannelMap) error {
+	RangeChannels(channels, func(ch IChannel) bool {
+		gox.Go(func() {
+			_ = ch.Close()
+		})
+		return true
+	})
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if ChannelCount(channels) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
 }
@@ -0,0 +1,286 @@
+package gim
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/kkakoz/gim/pkg/mapx"
+)
+
+const benchChannels = 1000000
+
+// benchChannel 只实现ID，其它方法不会被调用
+type benchChannel struct {
+	IChannel
+	id string
+}
+
+func (c *benchChannel) ID() string {
+	return c.id
+}
+
+func newBenchChannels(n int) []IChannel {
+	res := make([]IChannel, n)
+	for i := range res {
+		res[i] = &benchChannel{id: "channel-" + strconv.Itoa(i)}
+	}
+	return res
+}
+
+func TestChannelMapConcurrent(t *testing.T) {
+	m := NewChannels()
+	channels := newBenchChannels(10000)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(channels); i += 8 {
+				m.Add(channels[i])
+				m.Add(channels[i]) // 重复添加不重复计数
+				if i%2 == 0 {
+					m.Remove(channels[i].ID())
+					m.Remove(channels[i].ID())
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if m.Len() != len(channels)/2 {
+		t.Fatalf("Len() = %d, want %d", m.Len(), len(channels)/2)
+	}
+	if len(m.All()) != m.Len() {
+		t.Fatalf("len(All()) = %d, want %d", len(m.All()), m.Len())
+	}
+	if _, ok := m.Get(channels[1].ID()); !ok {
+		t.Fatal("channel-1 not found")
+	}
+	if _, ok := m.Get(channels[2].ID()); ok {
+		t.Fatal("channel-2 should be removed")
+	}
+	visited := 0
+	m.Range(func(IChannel) bool {
+		visited++
+		return visited < 10
+	})
+	if visited != 10 {
+		t.Fatalf("Range visited %d channels after stop, want 10", visited)
+	}
+}
+
+func fillChannelMap(b *testing.B, m IChannelMap) []IChannel {
+	b.Helper()
+	channels := newBenchChannels(benchChannels)
+	for _, ch := range channels {
+		m.Add(ch)
+	}
+	b.ResetTimer()
+	return channels
+}
+
+// syncMapChannels 基于单个读写锁的实现，用于对比
+type syncMapChannels struct {
+	IChannelMap
+	m *mapx.SyncMap[string, IChannel]
+}
+
+func (c *syncMapChannels) Add(channel IChannel)           { c.m.Add(channel.ID(), channel) }
+func (c *syncMapChannels) Get(id string) (IChannel, bool) { return c.m.Get(id) }
+func (c *syncMapChannels) Remove(id string)               { c.m.Delete(id) }
+
+func benchmarkGetParallel(b *testing.B, m IChannelMap) {
+	channels := fillChannelMap(b, m)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			m.Get(channels[i%benchChannels].ID())
+			i += 7919
+		}
+	})
+}
+
+func BenchmarkChannelMapGetParallel(b *testing.B) {
+	benchmarkGetParallel(b, NewChannels())
+}
+
+func BenchmarkSyncMapGetParallel(b *testing.B) {
+	benchmarkGetParallel(b, &syncMapChannels{m: mapx.NewSyncMap[string, IChannel]()})
+}
+
+func benchmarkChurnParallel(b *testing.B, m IChannelMap) {
+	channels := fillChannelMap(b, m)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			ch := channels[i%benchChannels]
+			if i%2 == 0 {
+				m.Remove(ch.ID())
+			} else {
+				m.Add(ch)
+			}
+			m.Get(ch.ID())
+			i += 7919
+		}
+	})
+}
+
+func BenchmarkChannelMapChurnParallel(b *testing.B) {
+	benchmarkChurnParallel(b, NewChannels())
+}
+
+func BenchmarkIndexedChannelMapChurnParallel(b *testing.B) {
+	benchmarkChurnParallel(b, NewIndexedChannelMap())
+}
+
+func BenchmarkSyncMapChurnParallel(b *testing.B) {
+	benchmarkChurnParallel(b, &syncMapChannels{m: mapx.NewSyncMap[string, IChannel]()})
+}
+
+func BenchmarkChannelMapRange(b *testing.B) {
+	m := NewChannels()
+	fillChannelMap(b, m)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		n := 0
+		m.Range(func(IChannel) bool {
+			n++
+			return true
+		})
+	}
+}
+
+func BenchmarkChannelMapAll(b *testing.B) {
+	m := NewChannels()
+	fillChannelMap(b, m)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = m.All()
+	}
+}
+
+func BenchmarkChannelMapLen(b *testing.B) {
+	m := NewChannels()
+	fillChannelMap(b, m)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = m.Len()
+		}
+	})
+}
+
+// metaChannel 只实现ID和Meta
+type metaChannel struct {
+	IChannel
+	id   string
+	meta *ChannelMeta
+}
+
+func (c *metaChannel) ID() string {
+	return c.id
+}
+
+func (c *metaChannel) Meta() *ChannelMeta {
+	return c.meta
+}
+
+// indexed 索引中是否有这个value，空的value应该被删除
+func indexed(m *indexedChannelMap, index, value string) bool {
+	key := indexKey(index, value)
+	s := m.indexShard(key)
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.values[key]
+	return ok
+}
+
+func TestIndexedChannelMap(t *testing.T) {
+	m := NewIndexedChannelMap(WithIndexedAttrs("region"))
+	m.Add(&metaChannel{id: "u1-ios", meta: &ChannelMeta{Account: "u1", Device: "ios", Tags: []string{"vip"}}})
+	m.Add(&metaChannel{id: "u1-web", meta: &ChannelMeta{Account: "u1", Device: "web", Attrs: map[string]string{"region": "cn", "trace": "t1"}}})
+	// 没有实现MetaChannel的连接不建立索引
+	m.Add(&benchChannel{id: "plain"})
+	m.Add(&metaChannel{id: "u2-web", meta: &ChannelMeta{Account: "u2", Device: "web", Tags: []string{"vip"}}})
+
+	count := func(name string, channels []IChannel, want int) {
+		t.Helper()
+		if len(channels) != want {
+			t.Fatalf("%s got %d channels, want %d", name, len(channels), want)
+		}
+	}
+	count("ByAccount(u1)", m.ByAccount("u1"), 2)
+	count("ByDevice(web)", m.ByDevice("web"), 2)
+	count("ByTag(vip)", m.ByTag("vip"), 2)
+	count("ByAttr(region)", m.ByAttr("region", "cn"), 1)
+	// 不在白名单中的属性不建立索引
+	count("ByAttr(trace)", m.ByAttr("trace", "t1"), 0)
+	if indexed(m, AttrIndex("trace"), "t1") {
+		t.Fatal("attr trace is indexed")
+	}
+
+	// 替换连接时旧属性的索引被删除
+	m.Add(&metaChannel{id: "u2-web", meta: &ChannelMeta{Account: "u2", Device: "web"}})
+	count("ByTag(vip) after replace", m.ByTag("vip"), 1)
+
+	m.Remove("u1-web")
+	count("ByAccount(u1) after remove", m.ByAccount("u1"), 1)
+	count("ByAttr(region) after remove", m.ByAttr("region", "cn"), 0)
+	if indexed(m, AttrIndex("region"), "cn") {
+		t.Fatal("empty index value is not deleted")
+	}
+	if m.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", m.Len())
+	}
+}
+
+func TestIndexedChannelMap_Concurrent(t *testing.T) {
+	m := NewIndexedChannelMap()
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 2000; i += 8 {
+				id := "channel-" + strconv.Itoa(i)
+				m.Add(&metaChannel{id: id, meta: &ChannelMeta{Account: "u" + strconv.Itoa(i%10), Tags: []string{"vip"}}})
+				if i%2 == 0 {
+					m.Remove(id)
+				}
+				m.ByTag("vip")
+			}
+		}(w)
+	}
+	wg.Wait()
+	if n := len(m.ByTag("vip")); n != 1000 || m.Len() != 1000 {
+		t.Fatalf("ByTag(vip) got %d channels, Len() = %d, want 1000", n, m.Len())
+	}
+	if n := len(m.ByAccount("u1")); n != 200 {
+		t.Fatalf("ByAccount(u1) got %d channels, want 200", n)
+	}
+}
+
+// plainChannelMap 只实现IChannelMap的自定义连接管理器
+type plainChannelMap struct {
+	IChannelMap
+	channels []IChannel
+}
+
+func (m *plainChannelMap) All() []IChannel {
+	return m.channels
+}
+
+func TestRangeChannels_Fallback(t *testing.T) {
+	m := &plainChannelMap{channels: newBenchChannels(10)}
+	if n := ChannelCount(m); n != 10 {
+		t.Fatalf("ChannelCount() = %d, want 10", n)
+	}
+	visited := 0
+	RangeChannels(m, func(IChannel) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Fatalf("visited %d channels, want 3", visited)
+	}
+}
@@ -1,8 +1,12 @@
 package gim
 
 import (
+	"bytes"
 	"net"
 	"time"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
 )
 
 type Client interface {
@@ -24,3 +28,24 @@ type DialerContext struct {
 	Address string
 	Timeout time.Duration
 }
+
+// ReadPacket 读取下一个消息包，跳过控制帧。失败的响应同时返回消息包和errno.FromHeader还原的*errno.Status
+func ReadPacket(cli Client) (*pkt.LogicPkt, error) {
+	for {
+		frame, err := cli.Read()
+		if err != nil {
+			return nil, err
+		}
+		if frame.GetOpCode() != OpBinary && frame.GetOpCode() != OpText {
+			continue
+		}
+		packet, err := pkt.Read(bytes.NewReader(frame.GetPayload()))
+		if err != nil {
+			return nil, errno.WrapStatus(pkt.Status_InvalidPacketBody, err)
+		}
+		if packet.Flag == pkt.Flag_Response {
+			return packet, errno.FromHeader(&packet.Header)
+		}
+		return packet, nil
+	}
+}
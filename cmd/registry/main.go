@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kkakoz/gim/naming/registry"
+	"github.com/kkakoz/gim/pkg/logger"
+	"github.com/spf13/pflag"
+)
+
+var (
+	listen   = pflag.StringP("listen", "l", ":8500", "Listen address of the http api.")
+	data     = pflag.StringP("data", "d", "registry.json", "Snapshot file, empty to disable snapshots.")
+	ttl      = pflag.Duration("ttl", registry.DefaultTTL, "Default ttl of instances.")
+	interval = pflag.Duration("snapshot-interval", registry.DefaultSnapshotInterval, "Interval of saving snapshots.")
+)
+
+func main() {
+	pflag.Parse()
+	srv := registry.NewServer(*listen,
+		registry.WithDefaultTTL(*ttl),
+		registry.WithSnapshot(*data, *interval),
+	)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Start()
+	}()
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	select {
+	case err := <-errc:
+		if err != nil {
+			logger.Error("registry stopped: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	case sig := <-ch:
+		logger.Info("shutdown " + sig.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("shutdown registry err:" + err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,442 @@
+package container
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+// BreakerState 熔断器状态
+type BreakerState int32
+
+const (
+	// BreakerClosed 正常接收流量
+	BreakerClosed BreakerState = iota
+	// BreakerOpen 实例被摘除，不参与选择
+	BreakerOpen
+	// BreakerHalfOpen 摘除时间结束，放行少量探测请求
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int32(s))
+	}
+}
+
+const (
+	DefaultBreakerWindow         = 10 * time.Second
+	DefaultBreakerMinRequests    = 20
+	DefaultBreakerErrorRate      = 0.5
+	DefaultBreakerConsecutive    = 5
+	DefaultBreakerMaxLatency     = 2 * time.Second
+	DefaultBreakerOpenTimeout    = 30 * time.Second
+	DefaultBreakerHalfOpenProbes = 1
+	DefaultBreakerRequestTimeout = 5 * time.Second
+)
+
+// BreakerOptions 熔断的配置，阈值为0时不启用对应的条件
+type BreakerOptions struct {
+	// 统计错误率和延迟的时间窗口
+	Window time.Duration
+	// 窗口内请求数达到MinRequests后才按错误率和延迟熔断
+	MinRequests int
+	ErrorRate   float64
+	// 连续失败次数
+	ConsecutiveFailures int
+	// 平均响应延迟
+	MaxLatency time.Duration
+	// 实例被摘除的时间
+	OpenTimeout time.Duration
+	// 半开状态同时放行的探测请求数
+	HalfOpenProbes int
+	// 超过RequestTimeout没有响应的请求记为失败
+	RequestTimeout time.Duration
+}
+
+func NewBreakerOptions() *BreakerOptions {
+	return &BreakerOptions{
+		Window:              DefaultBreakerWindow,
+		MinRequests:         DefaultBreakerMinRequests,
+		ErrorRate:           DefaultBreakerErrorRate,
+		ConsecutiveFailures: DefaultBreakerConsecutive,
+		MaxLatency:          DefaultBreakerMaxLatency,
+		OpenTimeout:         DefaultBreakerOpenTimeout,
+		HalfOpenProbes:      DefaultBreakerHalfOpenProbes,
+		RequestTimeout:      DefaultBreakerRequestTimeout,
+	}
+}
+
+type BreakerOptionsFunc func(options *BreakerOptions)
+
+func WithBreakerWindow(window time.Duration, minRequests int) BreakerOptionsFunc {
+	return func(options *BreakerOptions) {
+		options.Window = window
+		options.MinRequests = minRequests
+	}
+}
+
+func WithBreakerErrorRate(rate float64) BreakerOptionsFunc {
+	return func(options *BreakerOptions) {
+		options.ErrorRate = rate
+	}
+}
+
+func WithBreakerConsecutiveFailures(n int) BreakerOptionsFunc {
+	return func(options *BreakerOptions) {
+		options.ConsecutiveFailures = n
+	}
+}
+
+func WithBreakerMaxLatency(latency time.Duration) BreakerOptionsFunc {
+	return func(options *BreakerOptions) {
+		options.MaxLatency = latency
+	}
+}
+
+func WithBreakerOpenTimeout(timeout time.Duration, probes int) BreakerOptionsFunc {
+	return func(options *BreakerOptions) {
+		options.OpenTimeout = timeout
+		options.HalfOpenProbes = probes
+	}
+}
+
+func WithBreakerRequestTimeout(timeout time.Duration) BreakerOptionsFunc {
+	return func(options *BreakerOptions) {
+		options.RequestTimeout = timeout
+	}
+}
+
+// BreakerSnapshot 熔断器的状态快照，Reason说明最近一次状态变化的原因
+type BreakerSnapshot struct {
+	ServiceID   string        `json:"service_id"`
+	State       string        `json:"state"`
+	Reason      string        `json:"reason,omitempty"`
+	Since       time.Time     `json:"since"`
+	Requests    int           `json:"requests"`
+	Failures    int           `json:"failures"`
+	Consecutive int           `json:"consecutive"`
+	Latency     time.Duration `json:"latency"`
+	Pending     int           `json:"pending"`
+}
+
+// Breaker 单个实例的熔断器，按请求结果统计错误率、延迟和连续失败次数
+type Breaker struct {
+	sync.Mutex
+	id      string
+	opts    *BreakerOptions
+	state   BreakerState
+	reason  string
+	since   time.Time
+	onState func(id string, from, to BreakerState, reason string)
+
+	windowStart time.Time
+	requests    int
+	failures    int
+	latency     time.Duration // 窗口内的平均延迟
+	measured    int           // 窗口内有响应延迟的请求数
+	consecutive int
+	probes      int
+	pending     map[string]time.Time
+
+	now func() time.Time
+}
+
+func NewBreaker(id string, opts ...BreakerOptionsFunc) *Breaker {
+	options := NewBreakerOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+	return newBreaker(id, options, nil)
+}
+
+func newBreaker(id string, options *BreakerOptions, onState func(string, BreakerState, BreakerState, string)) *Breaker {
+	b := &Breaker{
+		id:      id,
+		opts:    options,
+		state:   BreakerClosed,
+		onState: onState,
+		pending: make(map[string]time.Time),
+		now:     time.Now,
+	}
+	b.since = b.now()
+	b.windowStart = b.since
+	return b
+}
+
+func (b *Breaker) State() BreakerState {
+	b.Lock()
+	defer b.Unlock()
+	b.refresh(b.now())
+	return b.state
+}
+
+// Available 实例是否可以参与选择，不占用半开状态的探测名额
+func (b *Breaker) Available() bool {
+	b.Lock()
+	defer b.Unlock()
+	b.refresh(b.now())
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		return b.probes < b.opts.HalfOpenProbes
+	default:
+		return false
+	}
+}
+
+// Allow 实例被选中后调用，半开状态下占用一个探测名额
+func (b *Breaker) Allow() bool {
+	b.Lock()
+	defer b.Unlock()
+	b.refresh(b.now())
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		if b.probes < b.opts.HalfOpenProbes {
+			b.probes++
+			return true
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// Begin 记录一个已发送的请求，key相同的请求只保留最后一个
+func (b *Breaker) Begin(key string) {
+	b.Lock()
+	defer b.Unlock()
+	b.pending[key] = b.now()
+}
+
+// End 收到key的响应，err为errno.FromHeader的结果，服务端错误记为失败
+func (b *Breaker) End(key string, err error) {
+	b.Lock()
+	defer b.Unlock()
+	now := b.now()
+	start, ok := b.pending[key]
+	if !ok {
+		return
+	}
+	delete(b.pending, key)
+	if serverError(errno.Code(err)) {
+		b.failure(now, fmt.Sprintf("response status %s: %s", errno.Code(err), err.Error()))
+		return
+	}
+	b.success(now, now.Sub(start))
+}
+
+// Sent 不需要响应的消息包发送成功，记为成功但不计入延迟
+func (b *Breaker) Sent() {
+	b.Lock()
+	defer b.Unlock()
+	b.success(b.now(), -1)
+}
+
+// Pending 已发送但还没有收到响应也没有超时的请求数
+func (b *Breaker) Pending() int {
+	b.Lock()
+	defer b.Unlock()
+	b.refresh(b.now())
+	return len(b.pending)
+}
+
+// Abort 请求发送失败，记为失败
+func (b *Breaker) Abort(key string, err error) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.pending, key)
+	reason := "unknown error"
+	if err != nil {
+		reason = err.Error()
+	}
+	b.failure(b.now(), reason)
+}
+
+func (b *Breaker) Snapshot() BreakerSnapshot {
+	b.Lock()
+	defer b.Unlock()
+	b.refresh(b.now())
+	return BreakerSnapshot{
+		ServiceID:   b.id,
+		State:       b.state.String(),
+		Reason:      b.reason,
+		Since:       b.since,
+		Requests:    b.requests,
+		Failures:    b.failures,
+		Consecutive: b.consecutive,
+		Latency:     b.latency,
+		Pending:     len(b.pending),
+	}
+}
+
+// refresh 处理超时的请求和到期的摘除
+func (b *Breaker) refresh(now time.Time) {
+	if b.opts.RequestTimeout > 0 {
+		for key, start := range b.pending {
+			if now.Sub(start) >= b.opts.RequestTimeout {
+				delete(b.pending, key)
+				b.failure(now, fmt.Sprintf("no response in %s", b.opts.RequestTimeout))
+			}
+		}
+	}
+	if b.state == BreakerOpen && now.Sub(b.since) >= b.opts.OpenTimeout {
+		b.setState(now, BreakerHalfOpen, "open timeout elapsed, probing")
+	}
+}
+
+func (b *Breaker) roll(now time.Time) {
+	if b.opts.Window > 0 && now.Sub(b.windowStart) >= b.opts.Window {
+		b.windowStart = now
+		b.requests = 0
+		b.failures = 0
+		b.latency = 0
+		b.measured = 0
+	}
+}
+
+// success latency<0表示没有响应延迟
+func (b *Breaker) success(now time.Time, latency time.Duration) {
+	b.roll(now)
+	b.requests++
+	if latency >= 0 {
+		b.measured++
+		b.latency += (latency - b.latency) / time.Duration(b.measured)
+	}
+	b.consecutive = 0
+	switch b.state {
+	case BreakerHalfOpen:
+		b.setState(now, BreakerClosed, "probe succeeded")
+	case BreakerClosed:
+		if b.opts.MaxLatency > 0 && b.measured >= b.opts.MinRequests && b.latency >= b.opts.MaxLatency {
+			b.setState(now, BreakerOpen, fmt.Sprintf("average latency %s >= %s", b.latency, b.opts.MaxLatency))
+		}
+	}
+}
+
+func (b *Breaker) failure(now time.Time, reason string) {
+	b.roll(now)
+	b.requests++
+	b.failures++
+	b.consecutive++
+	switch b.state {
+	case BreakerHalfOpen:
+		b.setState(now, BreakerOpen, "probe failed: "+reason)
+	case BreakerClosed:
+		if b.opts.ConsecutiveFailures > 0 && b.consecutive >= b.opts.ConsecutiveFailures {
+			b.setState(now, BreakerOpen, fmt.Sprintf("%d consecutive failures, last: %s", b.consecutive, reason))
+			return
+		}
+		rate := float64(b.failures) / float64(b.requests)
+		if b.opts.ErrorRate > 0 && b.requests >= b.opts.MinRequests && rate >= b.opts.ErrorRate {
+			b.setState(now, BreakerOpen, fmt.Sprintf("error rate %.2f >= %.2f, last: %s", rate, b.opts.ErrorRate, reason))
+		}
+	}
+}
+
+func (b *Breaker) setState(now time.Time, state BreakerState, reason string) {
+	from := b.state
+	b.state = state
+	b.reason = reason
+	b.since = now
+	b.probes = 0
+	if state == BreakerClosed {
+		b.windowStart = now
+		b.requests = 0
+		b.failures = 0
+		b.latency = 0
+		b.measured = 0
+		b.consecutive = 0
+	}
+	if b.onState != nil {
+		b.onState(b.id, from, state, reason)
+	}
+}
+
+// serverError 服务端错误计入熔断统计，客户端错误不计入
+func serverError(status pkt.Status) bool {
+	switch status {
+	case pkt.Status_SystemException, pkt.Status_ConnectionClosed, pkt.Status_Timeout:
+		return true
+	}
+	return false
+}
+
+// Breakers 按实例ID管理熔断器，实例重连后沿用原来的状态
+type Breakers struct {
+	sync.Mutex
+	opts *BreakerOptions
+	m    map[string]*Breaker
+}
+
+func NewBreakers(opts ...BreakerOptionsFunc) *Breakers {
+	options := NewBreakerOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+	return &Breakers{
+		opts: options,
+		m:    make(map[string]*Breaker),
+	}
+}
+
+// Get 返回实例的熔断器，不存在时创建
+func (bs *Breakers) Get(id string) *Breaker {
+	bs.Lock()
+	defer bs.Unlock()
+	b, ok := bs.m[id]
+	if !ok {
+		b = newBreaker(id, bs.opts, logStateChange)
+		bs.m[id] = b
+	}
+	return b
+}
+
+func (bs *Breakers) Remove(id string) {
+	bs.Lock()
+	defer bs.Unlock()
+	delete(bs.m, id)
+}
+
+// Snapshots 返回所有实例的熔断状态，按ServiceID排序
+func (bs *Breakers) Snapshots() []BreakerSnapshot {
+	bs.Lock()
+	list := make([]*Breaker, 0, len(bs.m))
+	for _, b := range bs.m {
+		list = append(list, b)
+	}
+	bs.Unlock()
+	res := make([]BreakerSnapshot, 0, len(list))
+	for _, b := range list {
+		res = append(res, b.Snapshot())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ServiceID < res[j].ServiceID
+	})
+	return res
+}
+
+func logStateChange(id string, from, to BreakerState, reason string) {
+	log.Warn(fmt.Sprintf("breaker of %s %s -> %s: %s", id, from, to, reason))
+}
+
+// requestKey 匹配请求和响应的key，响应复制了请求的ChannelId、Command和Sequence。
+// 只有Sequence不为0的请求才等待响应，Sequence为0时无法区分同一个channel的多个请求
+func requestKey(header *pkt.Header) (string, bool) {
+	key := fmt.Sprintf("%s/%s/%d", header.GetChannelId(), header.GetCommand(), header.GetSequence())
+	return key, header.GetFlag() == pkt.Flag_Request && header.GetSequence() != 0
+}
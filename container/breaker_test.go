@@ -0,0 +1,151 @@
+package container
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+// testClock 手动推进的时钟
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time      { return c.now }
+func (c *testClock) Add(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestBreaker(opts ...BreakerOptionsFunc) (*Breaker, *testClock) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	b := NewBreaker("chat-1", opts...)
+	b.now = clock.Now
+	b.since = clock.now
+	b.windowStart = clock.now
+	return b, clock
+}
+
+func TestBreaker_EjectAndRecover(t *testing.T) {
+	b, clock := newTestBreaker(WithBreakerConsecutiveFailures(3), WithBreakerOpenTimeout(10*time.Second, 1))
+	// 1. 连续失败后摘除
+	for i := 0; i < 3; i++ {
+		if !b.Available() {
+			t.Fatalf("ejected after %d failures", i)
+		}
+		b.Abort("k", errors.New("broken pipe"))
+	}
+	if b.State() != BreakerOpen || b.Available() || b.Allow() {
+		t.Fatalf("state %s after 3 consecutive failures, want open", b.State())
+	}
+
+	// 2. 摘除时间结束后只放行一个探测请求
+	clock.Add(10 * time.Second)
+	if b.State() != BreakerHalfOpen || !b.Available() {
+		t.Fatalf("state %s after the open timeout, want half-open", b.State())
+	}
+	if !b.Allow() {
+		t.Fatal("probe is not allowed")
+	}
+	if b.Available() || b.Allow() {
+		t.Fatal("second probe is allowed")
+	}
+
+	// 3. 探测成功后恢复
+	b.Begin("probe")
+	b.End("probe", nil)
+	if b.State() != BreakerClosed || !b.Available() {
+		t.Fatalf("state %s after the probe succeeded, want closed", b.State())
+	}
+	if s := b.Snapshot(); s.Consecutive != 0 || s.Failures != 0 {
+		t.Fatalf("counters are kept after recovery: %+v", s)
+	}
+}
+
+func TestBreaker_ProbeFailed(t *testing.T) {
+	b, clock := newTestBreaker(WithBreakerConsecutiveFailures(1), WithBreakerOpenTimeout(10*time.Second, 1))
+	b.Abort("k", errors.New("broken pipe"))
+	clock.Add(10 * time.Second)
+	if !b.Allow() {
+		t.Fatal("probe is not allowed")
+	}
+	b.Begin("probe")
+	b.End("probe", errno.NewStatus(pkt.Status_SystemException, "system exception"))
+	if b.State() != BreakerOpen {
+		t.Fatalf("state %s after the probe failed, want open", b.State())
+	}
+	// 重新计算摘除时间
+	clock.Add(5 * time.Second)
+	if b.Available() {
+		t.Fatal("available before the new open timeout")
+	}
+}
+
+func TestBreaker_RequestTimeout(t *testing.T) {
+	b, clock := newTestBreaker(WithBreakerConsecutiveFailures(2), WithBreakerRequestTimeout(time.Second))
+	b.Begin("u1/talk/1")
+	b.Begin("u1/talk/2")
+	clock.Add(time.Second)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state %s after 2 requests timed out, want open", b.State())
+	}
+	if s := b.Snapshot(); s.Pending != 0 || s.Failures != 2 {
+		t.Fatalf("unexpected snapshot after timeout: %+v", s)
+	}
+	// 超时后才收到的响应不再计入
+	b.End("u1/talk/1", nil)
+	if s := b.Snapshot(); s.Requests != 2 {
+		t.Fatalf("late response is counted: %+v", s)
+	}
+}
+
+func TestBreaker_ErrorRateAndLatency(t *testing.T) {
+	b, clock := newTestBreaker(WithBreakerConsecutiveFailures(0), WithBreakerWindow(time.Minute, 4), WithBreakerErrorRate(0.5))
+	b.Sent()
+	b.Abort("k", errors.New("broken pipe"))
+	b.Sent()
+	if b.State() != BreakerClosed {
+		t.Fatal("opened before MinRequests")
+	}
+	b.Abort("k", errors.New("broken pipe"))
+	if b.State() != BreakerOpen {
+		t.Fatalf("state %s at error rate 0.5, want open", b.State())
+	}
+
+	b, clock = newTestBreaker(WithBreakerWindow(time.Minute, 2), WithBreakerMaxLatency(time.Second))
+	// 不需要响应的消息包不计入延迟
+	for i := 0; i < 10; i++ {
+		b.Sent()
+	}
+	for _, key := range []string{"a", "b"} {
+		b.Begin(key)
+		clock.Add(2 * time.Second)
+		b.End(key, nil)
+	}
+	if s := b.Snapshot(); s.State != "open" || s.Latency != 2*time.Second {
+		t.Fatalf("unexpected snapshot with slow responses: %+v", s)
+	}
+}
+
+func TestRequestKey(t *testing.T) {
+	req := pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1"), pkt.WithSeq(1))
+	key, ok := requestKey(&req.Header)
+	if !ok {
+		t.Fatal("request with a sequence should wait for the response")
+	}
+	if respKey, _ := requestKey(&pkt.NewFrom(&req.Header).Header); respKey != key {
+		t.Fatalf("response key %s does not match request key %s", respKey, key)
+	}
+	other := pkt.New(pkt.CommandChatGroupTalk, pkt.WithChannel("u1"), pkt.WithSeq(1))
+	if otherKey, _ := requestKey(&other.Header); otherKey == key {
+		t.Fatal("requests with different commands share a key")
+	}
+	if _, ok = requestKey(&pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1")).Header); ok {
+		t.Fatal("request without a sequence should not wait for the response")
+	}
+	push := pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1"), pkt.WithSeq(2))
+	push.Flag = pkt.Flag_Push
+	if _, ok = requestKey(&push.Header); ok {
+		t.Fatal("push should not wait for a response")
+	}
+}
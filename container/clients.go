@@ -9,6 +9,7 @@ type IClientMap interface {
 	Add(client gim.Client)
 	Remove(id string)
 	Get(id string) (gim.Client, bool)
+	All() []gim.Client
 	Services(kvs []string) []gim.Service
 }
 
@@ -28,16 +29,60 @@ func (c *clientMap) Get(id string) (gim.Client, bool) {
 	return c.m.Get(id)
 }
 
+func (c *clientMap) All() []gim.Client {
+	return c.m.Values()
+}
+
+// Services 返回meta满足kvs中所有键值对的服务，kvs为空时返回全部，kvs的长度不是偶数时没有服务满足条件
 func (c *clientMap) Services(kvs []string) []gim.Service {
-	res := []gim.Service{}
-	for _, v := range c.m.Values() {
-		if v.GetMeta()[kvs[0]] == kvs[1] {
-			res = append(res, v)
-		}
+	if len(kvs)%2 != 0 {
+		return []gim.Service{}
+	}
+	filters := make([]Filter, 0, len(kvs)/2)
+	for i := 0; i < len(kvs); i += 2 {
+		filters = append(filters, MetaEquals(kvs[i], kvs[i+1]))
 	}
-	return res
+	values := c.m.Values()
+	res := make([]gim.Service, 0, len(values))
+	for _, v := range values {
+		res = append(res, v)
+	}
+	return FilterServices(res, filters...)
 }
 
 func NewClients() *clientMap {
 	return &clientMap{m: mapx.NewSyncMap[string, gim.Client]()}
 }
+
+// depClient 依赖服务的客户端，记录服务注册信息，未完成的请求数取自实例的熔断器
+type depClient struct {
+	gim.Client
+	service gim.ServiceRegistration
+	breaker *Breaker
+}
+
+func newDepClient(cli gim.Client, service gim.ServiceRegistration, breaker *Breaker) *depClient {
+	return &depClient{Client: cli, service: service, breaker: breaker}
+}
+
+func (d *depClient) GetTags() []string {
+	if d.service == nil {
+		return nil
+	}
+	return d.service.GetTags()
+}
+
+func (d *depClient) GetNamespace() string {
+	if d.service == nil {
+		return ""
+	}
+	return d.service.GetNamespace()
+}
+
+// InFlight 已转发但还没有收到响应也没有超时的请求数
+func (d *depClient) InFlight() int64 {
+	if d.breaker == nil {
+		return 0
+	}
+	return int64(d.breaker.Pending())
+}
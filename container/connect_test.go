@@ -0,0 +1,175 @@
+package container
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+	"github.com/kkakoz/gim/proto/pkt"
+	"github.com/kkakoz/gim/tcp"
+)
+
+// pipeDialer fail返回true的那次拨号失败，其它返回net.Pipe的一端，block不为nil时拨号阻塞到它关闭
+type pipeDialer struct {
+	sync.Mutex
+	fail    func(n int32) bool
+	dials   int32
+	times   []time.Time
+	entered chan struct{}
+	block   chan struct{}
+	remotes []net.Conn
+}
+
+func (d *pipeDialer) DialAndHandshake(gim.DialerContext) (net.Conn, error) {
+	if d.block != nil {
+		d.entered <- struct{}{}
+		<-d.block
+	}
+	n := atomic.AddInt32(&d.dials, 1)
+	d.Lock()
+	defer d.Unlock()
+	d.times = append(d.times, time.Now())
+	if d.fail != nil && d.fail(n) {
+		return nil, errors.New("connection refused")
+	}
+	local, remote := net.Pipe()
+	d.remotes = append(d.remotes, remote)
+	return local, nil
+}
+
+func (d *pipeDialer) remote(i int) net.Conn {
+	d.Lock()
+	defer d.Unlock()
+	return d.remotes[i]
+}
+
+func (d *pipeDialer) close() {
+	d.Lock()
+	defer d.Unlock()
+	for _, conn := range d.remotes {
+		_ = conn.Close()
+	}
+}
+
+func newConnectContainer(t *testing.T, dialer gim.Dialer, opts ...OptionFunc) (*Container, gim.ServiceRegistration) {
+	t.Helper()
+	service := &naming.DefaultService{Id: "chat-1", Name: "chat", Protocol: "tcp", Address: "127.0.0.1", Port: 8001}
+	ns := naming.NewMemoryNaming()
+	_ = ns.Register(service)
+	c := New(append([]OptionFunc{WithNaming(ns), WithDialer(dialer)}, opts...)...)
+	c.reconnectDelay = 10 * time.Millisecond
+	atomic.StoreUint32(&c.state, stateStarted)
+	t.Cleanup(func() { atomic.StoreUint32(&c.state, stateClosed) })
+	return c, service
+}
+
+func TestConnect_RetryFirstDial(t *testing.T) {
+	dialer := &pipeDialer{fail: func(n int32) bool { return n <= 2 }}
+	t.Cleanup(dialer.close)
+	c, service := newConnectContainer(t, dialer)
+	clients := NewClients()
+
+	if err := c.connect(clients, service); err == nil {
+		t.Fatal("first dial should fail")
+	}
+	waitUntil(t, "failed instance was not reconnected", func() bool {
+		_, ok := clients.Get(service.ServiceID())
+		return ok
+	})
+	if n := atomic.LoadInt32(&dialer.dials); n != 3 {
+		t.Fatalf("dialed %d times, want 3", n)
+	}
+}
+
+func TestConnect_DialWithoutLock(t *testing.T) {
+	dialer := &pipeDialer{entered: make(chan struct{}, 1), block: make(chan struct{})}
+	t.Cleanup(dialer.close)
+	c, service := newConnectContainer(t, dialer)
+	clients := NewClients()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.connect(clients, service)
+	}()
+	<-dialer.entered
+	// 拨号过程中可以读取srvclients
+	locked := make(chan struct{})
+	go func() {
+		c.RLock()
+		c.RUnlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("container lock is held while dialing")
+	}
+	// 同一个实例正在连接时不会重复拨号
+	if err := c.connect(clients, service); err != nil {
+		t.Fatal(err)
+	}
+	close(dialer.block)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&dialer.dials); n != 1 {
+		t.Fatalf("dialed %d times, want 1", n)
+	}
+}
+
+func TestReadLoop_ReconnectWithBackoff(t *testing.T) {
+	// 第一次连接成功，断开后重连两次失败
+	dialer := &pipeDialer{fail: func(n int32) bool { return n == 2 || n == 3 }}
+	t.Cleanup(dialer.close)
+	srv := newFakeServer("gateway-1", "u1")
+	c, service := newConnectContainer(t, dialer, WithServer(srv))
+	c.reconnectDelay = 20 * time.Millisecond
+	clients := NewClients()
+	if err := c.connect(clients, service); err != nil {
+		t.Fatal(err)
+	}
+
+	// 逻辑服务的响应被推送给Header.ChannelId
+	resp := pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1"))
+	resp.Flag = pkt.Flag_Response
+	if err := tcp.WriteFrame(dialer.remote(0), gim.OpBinary, pkt.Marshal(resp)); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "response was not dispatched to u1", func() bool {
+		return len(srv.received("u1")) == 1
+	})
+
+	// 对方关闭后客户端被移除，按退避间隔重连
+	first, _ := clients.Get(service.ServiceID())
+	_ = dialer.remote(0).Close()
+	waitUntil(t, "client was not reconnected", func() bool {
+		cli, ok := clients.Get(service.ServiceID())
+		return ok && cli != first
+	})
+	dialer.Lock()
+	times := dialer.times
+	dialer.Unlock()
+	if len(times) != 4 {
+		t.Fatalf("dialed %d times, want 4", len(times))
+	}
+	if gap1, gap2 := times[2].Sub(times[1]), times[3].Sub(times[2]); gap2 < gap1*3/2 {
+		t.Fatalf("reconnect delay does not back off: %s then %s", gap1, gap2)
+	}
+}
+
+func waitUntil(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
@@ -0,0 +1,217 @@
+package container
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+// DefaultReplicas 每个实例默认的虚拟节点数
+const DefaultReplicas = 160
+
+// ConsistentHashSelector 一致性哈希选择器，实例增减时只有少量channel被重新映射。
+// 每个服务一个环，环由Naming的通知维护，Lookup时services只表示当前可用的实例
+type ConsistentHashSelector struct {
+	sync.Mutex
+	replicas int
+	hash     func([]byte) uint32
+	rings    map[string]*hashRing
+}
+
+var _ NamingSelector = (*ConsistentHashSelector)(nil)
+
+// hashRing 一个服务的哈希环，虚拟节点冲突时按加入顺序记录所有的实例，
+// 排在前面的实例移除后由后面的实例接管该虚拟节点
+type hashRing struct {
+	ring    []uint32
+	owners  map[uint32][]string
+	members map[string]struct{}
+}
+
+func newHashRing() *hashRing {
+	return &hashRing{
+		owners:  make(map[uint32][]string),
+		members: make(map[string]struct{}),
+	}
+}
+
+// NewConsistentHashSelector replicas<=0时使用DefaultReplicas，hash为nil时使用crc32
+func NewConsistentHashSelector(replicas int, hash func([]byte) uint32) *ConsistentHashSelector {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	if hash == nil {
+		hash = crc32.ChecksumIEEE
+	}
+	return &ConsistentHashSelector{
+		replicas: replicas,
+		hash:     hash,
+		rings:    make(map[string]*hashRing),
+	}
+}
+
+// Lookup 按ChannelId在环上顺时针查找第一个在services中的实例。
+// services中不在环上的实例会被加入环中，不在services中的实例只是被跳过，不会从环中移除
+func (s *ConsistentHashSelector) Lookup(header *pkt.Header, services []gim.Service) string {
+	if len(services) == 0 {
+		return ""
+	}
+	s.Lock()
+	defer s.Unlock()
+	r := s.ring(services[0].ServiceName())
+	available := make(map[string]struct{}, len(services))
+	for _, service := range services {
+		id := service.ServiceID()
+		available[id] = struct{}{}
+		if _, ok := r.members[id]; !ok {
+			s.add(r, id)
+		}
+	}
+	return s.get(r, header.ChannelId, available)
+}
+
+// Add 实例上线，添加到所属服务的环中
+func (s *ConsistentHashSelector) Add(services ...gim.Service) {
+	s.Lock()
+	defer s.Unlock()
+	for _, service := range services {
+		s.add(s.ring(service.ServiceName()), service.ServiceID())
+	}
+}
+
+// Remove 实例下线，从所属服务的环中移除
+func (s *ConsistentHashSelector) Remove(services ...gim.Service) {
+	s.Lock()
+	defer s.Unlock()
+	for _, service := range services {
+		if r, ok := s.rings[service.ServiceName()]; ok {
+			s.remove(r, service.ServiceID())
+		}
+	}
+}
+
+// Reset 把服务的环同步为services中的实例，只增删有变化的实例
+func (s *ConsistentHashSelector) Reset(serviceName string, services []gim.Service) {
+	s.Lock()
+	defer s.Unlock()
+	r := s.ring(serviceName)
+	current := make(map[string]struct{}, len(services))
+	for _, service := range services {
+		current[service.ServiceID()] = struct{}{}
+	}
+	for id := range r.members {
+		if _, ok := current[id]; !ok {
+			s.remove(r, id)
+		}
+	}
+	for id := range current {
+		s.add(r, id)
+	}
+}
+
+// Get 返回key在服务的环上所在的实例，环为空时返回空字符串
+func (s *ConsistentHashSelector) Get(serviceName, key string) string {
+	s.Lock()
+	defer s.Unlock()
+	r, ok := s.rings[serviceName]
+	if !ok {
+		return ""
+	}
+	return s.get(r, key, nil)
+}
+
+func (s *ConsistentHashSelector) ring(serviceName string) *hashRing {
+	r, ok := s.rings[serviceName]
+	if !ok {
+		r = newHashRing()
+		s.rings[serviceName] = r
+	}
+	return r
+}
+
+// get 从key的位置顺时针查找，available不为nil时跳过不可用的实例
+func (s *ConsistentHashSelector) get(r *hashRing, key string, available map[string]struct{}) string {
+	if len(r.ring) == 0 {
+		return ""
+	}
+	h := s.hash([]byte(key))
+	start := sort.Search(len(r.ring), func(i int) bool { return r.ring[i] >= h })
+	for n := 0; n < len(r.ring); n++ {
+		for _, id := range r.owners[r.ring[(start+n)%len(r.ring)]] {
+			if available == nil {
+				return id
+			}
+			if _, ok := available[id]; ok {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+func (s *ConsistentHashSelector) vnode(id string, i int) uint32 {
+	return s.hash([]byte(id + "#" + strconv.Itoa(i)))
+}
+
+func (s *ConsistentHashSelector) add(r *hashRing, id string) {
+	if _, ok := r.members[id]; ok {
+		return
+	}
+	r.members[id] = struct{}{}
+	changed := false
+	for i := 0; i < s.replicas; i++ {
+		h := s.vnode(id, i)
+		owners, ok := r.owners[h]
+		if !ok {
+			r.ring = append(r.ring, h)
+			changed = true
+		}
+		// 同一个实例的多个虚拟节点也可能冲突
+		if len(owners) == 0 || owners[len(owners)-1] != id {
+			r.owners[h] = append(owners, id)
+		}
+	}
+	if changed {
+		sort.Slice(r.ring, func(i, j int) bool { return r.ring[i] < r.ring[j] })
+	}
+}
+
+func (s *ConsistentHashSelector) remove(r *hashRing, id string) {
+	if _, ok := r.members[id]; !ok {
+		return
+	}
+	delete(r.members, id)
+	changed := false
+	for i := 0; i < s.replicas; i++ {
+		h := s.vnode(id, i)
+		owners := r.owners[h]
+		rest := owners[:0:0]
+		for _, owner := range owners {
+			if owner != id {
+				rest = append(rest, owner)
+			}
+		}
+		if len(rest) > 0 {
+			r.owners[h] = rest
+			continue
+		}
+		if _, ok := r.owners[h]; ok {
+			delete(r.owners, h)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	ring := r.ring[:0]
+	for _, h := range r.ring {
+		if _, ok := r.owners[h]; ok {
+			ring = append(ring, h)
+		}
+	}
+	r.ring = ring
+}
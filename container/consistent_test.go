@@ -0,0 +1,183 @@
+package container
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+func testServices(n int) []gim.Service {
+	services := make([]gim.Service, 0, n)
+	for i := 0; i < n; i++ {
+		services = append(services, &naming.DefaultService{Id: fmt.Sprintf("logic-%d", i), Name: "logic"})
+	}
+	return services
+}
+
+func lookupAll(s Selector, services []gim.Service, keys int) map[string]string {
+	res := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("channel-%d", i)
+		res[key] = s.Lookup(&pkt.Header{ChannelId: key}, services)
+	}
+	return res
+}
+
+func moved(a, b map[string]string) int {
+	n := 0
+	for k, v := range a {
+		if b[k] != v {
+			n++
+		}
+	}
+	return n
+}
+
+func TestConsistentHashSelector_Stable(t *testing.T) {
+	services := testServices(5)
+	a := lookupAll(NewConsistentHashSelector(0, nil), services, 1000)
+	b := lookupAll(NewConsistentHashSelector(0, nil), services, 1000)
+	if n := moved(a, b); n != 0 {
+		t.Fatalf("%d keys mapped differently with the same services", n)
+	}
+}
+
+func TestConsistentHashSelector_AddNode(t *testing.T) {
+	const keys = 10000
+	s := NewConsistentHashSelector(0, nil)
+	before := lookupAll(s, testServices(10), keys)
+	after := lookupAll(s, testServices(11), keys)
+	// 理想情况下移动 1/11 的key，允许一倍的偏差
+	if n := moved(before, after); n > keys*2/11 {
+		t.Fatalf("%d of %d keys moved after adding a node", n, keys)
+	}
+	for k, v := range after {
+		if before[k] != v && v != "logic-10" {
+			t.Fatalf("key %s moved from %s to %s, not to the new node", k, before[k], v)
+		}
+	}
+}
+
+func TestConsistentHashSelector_RemoveNode(t *testing.T) {
+	const keys = 10000
+	s := NewConsistentHashSelector(0, nil)
+	services := testServices(10)
+	before := lookupAll(s, services, keys)
+	removed := services[3].ServiceID()
+	after := lookupAll(s, append(services[:3:3], services[4:]...), keys)
+	for k, v := range before {
+		if v != removed && after[k] != v {
+			t.Fatalf("key %s moved from %s to %s, but its node is still alive", k, v, after[k])
+		}
+		if after[k] == removed {
+			t.Fatalf("key %s still maps to the removed node", k)
+		}
+	}
+}
+
+func TestConsistentHashSelector_Balance(t *testing.T) {
+	const keys = 10000
+	services := testServices(10)
+	counts := make(map[string]int)
+	for _, id := range lookupAll(NewConsistentHashSelector(0, nil), services, keys) {
+		counts[id]++
+	}
+	for id, n := range counts {
+		if n < keys/10/2 || n > keys/10*2 {
+			t.Fatalf("node %s got %d of %d keys", id, n, keys)
+		}
+	}
+}
+
+// TestConsistentHashSelector_Unavailable services中缺少的实例只被跳过，恢复后映射不变
+func TestConsistentHashSelector_Unavailable(t *testing.T) {
+	const keys = 10000
+	s := NewConsistentHashSelector(0, nil)
+	services := testServices(10)
+	s.Add(services...)
+	before := lookupAll(s, services, keys)
+	unavailable := services[3].ServiceID()
+	during := lookupAll(s, append(services[:3:3], services[4:]...), keys)
+	for k, v := range before {
+		if v != unavailable && during[k] != v {
+			t.Fatalf("key %s moved from %s to %s, but its node is available", k, v, during[k])
+		}
+		// 顺时针的下一个实例接管
+		if v == unavailable && (during[k] == unavailable || during[k] == "") {
+			t.Fatalf("key %s is mapped to %q", k, during[k])
+		}
+	}
+	if n := moved(before, lookupAll(s, services, keys)); n != 0 {
+		t.Fatalf("%d keys moved after the node became available again", n)
+	}
+}
+
+func TestConsistentHashSelector_NamingEvents(t *testing.T) {
+	s := NewConsistentHashSelector(0, nil)
+	services := testServices(3)
+	s.Reset("logic", services)
+	removed := ""
+	for i := 0; i < 100 && removed == ""; i++ {
+		if id := s.Get("logic", fmt.Sprintf("channel-%d", i)); id == "logic-1" {
+			removed = fmt.Sprintf("channel-%d", i)
+		}
+	}
+	if removed == "" {
+		t.Fatal("no key is mapped to logic-1")
+	}
+	s.Remove(services[1])
+	if id := s.Get("logic", removed); id == "logic-1" || id == "" {
+		t.Fatalf("key %s is mapped to %q after logic-1 was removed", removed, id)
+	}
+	s.Reset("logic", services[:1])
+	if id := s.Get("logic", removed); id != "logic-0" {
+		t.Fatalf("key %s is mapped to %q, want logic-0", removed, id)
+	}
+	if id := s.Get("chat", removed); id != "" {
+		t.Fatalf("key %s is mapped to %q in an unknown service", removed, id)
+	}
+}
+
+// TestConsistentHashSelector_Collision 虚拟节点冲突时，移除先加入的实例后由后加入的实例接管
+func TestConsistentHashSelector_Collision(t *testing.T) {
+	// 只对#之后的部分求哈希，不同实例的同一个虚拟节点总是冲突
+	hash := func(key []byte) uint32 {
+		if i := bytes.IndexByte(key, '#'); i >= 0 {
+			key = key[i:]
+		}
+		return crc32.ChecksumIEEE(key)
+	}
+	s := NewConsistentHashSelector(4, hash)
+	services := testServices(2)
+	s.Add(services...)
+	if id := s.Get("logic", "channel-1"); id != "logic-0" {
+		t.Fatalf("got %q, want logic-0 which was added first", id)
+	}
+	s.Remove(services[0])
+	if id := s.Get("logic", "channel-1"); id != "logic-1" {
+		t.Fatalf("got %q after logic-0 was removed, want logic-1", id)
+	}
+	s.Remove(services[1])
+	if id := s.Get("logic", "channel-1"); id != "" {
+		t.Fatalf("got %q from an empty ring", id)
+	}
+}
+
+func TestContainer_NamingSelector(t *testing.T) {
+	s := NewConsistentHashSelector(0, nil)
+	c := New(WithSelector(s))
+	services := testServices(2)
+	s.Add(services...)
+	registrations := []gim.ServiceRegistration{services[0].(gim.ServiceRegistration)}
+	c.applyDiff(NewClients(), naming.Diff{Removed: registrations})
+	for i := 0; i < 100; i++ {
+		if id := s.Get("logic", fmt.Sprintf("channel-%d", i)); id != "logic-1" {
+			t.Fatalf("key is mapped to %q after logic-0 went offline", id)
+		}
+	}
+}
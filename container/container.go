@@ -1,11 +1,13 @@
 package container
 
 import (
+	"context"
 	"fmt"
 	"github.com/kkakoz/gim"
 	"github.com/kkakoz/gim/naming"
 	"github.com/kkakoz/gim/pkg/gox"
 	"github.com/kkakoz/gim/pkg/logger"
+	"github.com/kkakoz/gim/tcp"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"os"
@@ -13,6 +15,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 const (
@@ -30,91 +33,440 @@ type Container struct {
 	srvclients map[string]IClientMap
 	selector   Selector
 	dialer     gim.Dialer
+	secret     string
+	inner      bool // 使用InnerAcceptor校验网关的握手
 	deps       map[string]struct{}
+	breakers   *Breakers
+	quit       *gim.Event
+	connecting map[string]struct{} // 正在连接或重连的实例
+	// 第一次重连的间隔，之后每次翻倍
+	reconnectDelay time.Duration
+
+	registerTTL    time.Duration
+	healthInterval time.Duration
+	healthChecks   []naming.Check
+	checker        *naming.Checker
+
+	shutdownOpts *ShutdownOptions
 }
 
 var log = logger.WithFields(zap.String("module", "container"))
-var c = &Container{
-	state:      stateUninitialized,
-	srvclients: make(map[string]IClientMap),
-	selector:   &HashSelector{},
-	deps:       make(map[string]struct{}, 0),
+
+// DefaultStartupWait Server没有实现gim.ReadyNotifier时，Start等待服务启动失败的时间
+const DefaultStartupWait = 200 * time.Millisecond
+
+type OptionFunc func(c *Container)
+
+func WithServer(srv gim.Server) OptionFunc {
+	return func(c *Container) {
+		c.Srv = srv
+	}
+}
+
+func WithNaming(naming naming.Naming) OptionFunc {
+	return func(c *Container) {
+		c.Naming = naming
+	}
+}
+
+// WithDeps set the services this container connects to
+func WithDeps(deps ...string) OptionFunc {
+	return func(c *Container) {
+		for _, dep := range deps {
+			c.deps[dep] = struct{}{}
+		}
+	}
+}
+
+func WithDialer(dialer gim.Dialer) OptionFunc {
+	return func(c *Container) {
+		c.dialer = dialer
+	}
+}
+
+func WithSelector(selector Selector) OptionFunc {
+	return func(c *Container) {
+		c.selector = selector
+	}
+}
+
+// WithSecret 内部握手的密钥，有依赖的服务或者使用WithInnerAcceptor时必须设置
+func WithSecret(secret string) OptionFunc {
+	return func(c *Container) {
+		c.secret = secret
+	}
+}
+
+// WithInnerAcceptor 逻辑服务使用InnerAcceptor接受网关的连接，面向客户端的网关不能设置
+func WithInnerAcceptor() OptionFunc {
+	return func(c *Container) {
+		c.inner = true
+	}
+}
+
+// WithBreaker set options of the circuit breakers of dependency clients
+func WithBreaker(opts ...BreakerOptionsFunc) OptionFunc {
+	return func(c *Container) {
+		c.breakers = NewBreakers(opts...)
+	}
+}
+
+func WithShutdown(opts ...ShutdownOptionsFunc) OptionFunc {
+	return func(c *Container) {
+		for _, opt := range opts {
+			opt(c.shutdownOpts)
+		}
+	}
 }
 
-func Default() *Container {
+// New 创建一个容器实例，设置了Srv时直接进入初始化状态
+func New(opts ...OptionFunc) *Container {
+	c := &Container{
+		state:          stateUninitialized,
+		srvclients:     make(map[string]IClientMap),
+		selector:       &HashSelector{},
+		deps:           make(map[string]struct{}),
+		breakers:       NewBreakers(),
+		quit:           gim.NewEvent(),
+		connecting:     make(map[string]struct{}),
+		reconnectDelay: reconnectDelay,
+		registerTTL:    DefaultRegisterTTL,
+		shutdownOpts:   NewShutdownOptions(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.Srv != nil {
+		c.state = stateInitialized
+	}
 	return c
 }
 
-func Init(srv gim.Server, deps ...string) error {
+// Init 设置服务和依赖，使用New(WithServer(srv))时不需要调用
+func (c *Container) Init(srv gim.Server, deps ...string) error {
 	if !atomic.CompareAndSwapUint32(&c.state, stateUninitialized, stateInitialized) {
 		return errors.New("has Initialized")
 	}
 	c.Srv = srv
-	for _, dep := range deps {
-		if _, ok := c.deps[dep]; ok {
-			continue
-		}
-		c.deps[dep] = struct{}{}
-	}
+	WithDeps(deps...)(c)
 	logger.WithFields(zap.String("func", "Init")).Info(fmt.Sprintf("srv %s:%s - deps %v", srv.ServiceID(), srv.ServiceName(), c.deps))
-	c.srvclients = make(map[string]IClientMap, len(deps))
 	return nil
 }
 
 // SetDialer set tcp dialer
-func SetDialer(dialer gim.Dialer) {
+func (c *Container) SetDialer(dialer gim.Dialer) {
 	c.dialer = dialer
 }
 
+// SetSecret set the secret shared by services in internal handshakes
+func (c *Container) SetSecret(secret string) {
+	c.secret = secret
+}
+
 // SetSelector set a default selector
-func SetSelector(selector Selector) {
+func (c *Container) SetSelector(selector Selector) {
 	c.selector = selector
 }
 
-func Start() error {
+// Health 依赖服务实例的熔断状态
+func (c *Container) Health() []BreakerSnapshot {
+	return c.breakers.Snapshots()
+}
+
+// Start 启动服务、注册并连接依赖的服务，运行后立即返回
+func (c *Container) Start(ctx context.Context) error {
+	if !atomic.CompareAndSwapUint32(&c.state, stateInitialized, stateStarted) {
+		return fmt.Errorf("container is not initialized or has started")
+	}
+	// 没有设置Naming时使用进程内的实现，适用于测试和单机部署
 	if c.Naming == nil {
-		return fmt.Errorf("no naming")
+		c.Naming = naming.NewMemoryNaming()
 	}
-	if !atomic.CompareAndSwapUint32(&c.state, stateInitialized, stateStarted) {
-		return fmt.Errorf("")
+	// 连接依赖的服务默认使用内部握手，设置了WithInnerAcceptor时校验网关的握手
+	if c.inner {
+		if c.secret == "" {
+			atomic.StoreUint32(&c.state, stateInitialized)
+			return errors.New("secret of the inner handshake is empty")
+		}
+		c.Srv.SetAcceptor(NewInnerAcceptor(c.Naming, c.secret))
+	}
+	if c.dialer == nil && len(c.deps) > 0 {
+		if c.secret == "" {
+			atomic.StoreUint32(&c.state, stateInitialized)
+			return errors.New("secret of the inner handshake is empty, set it with WithSecret or set a dialer")
+		}
+		c.dialer = NewInnerDialer(c.Srv.ServiceID(), c.Srv.ServiceName(), c.secret)
 	}
 
+	errc := make(chan error, 1)
 	gox.Go(func() {
-		func(srv gim.Server) {
-			err := srv.Start()
-			if err != nil {
-				logger.Error("start container err:" + err.Error())
-			}
-		}(c.Srv)
+		err := c.Srv.Start()
+		if err != nil {
+			logger.Error("start container err:" + err.Error())
+		}
+		errc <- err
 	})
 
-	// 连接需要的服务
-	for _, service := range c.deps {
-		gox.Go(func() {
-			connectToService(service)
-		})
+	// 等待服务开始监听，不支持ReadyNotifier的服务等待DefaultStartupWait
+	var ready <-chan struct{}
+	if rn, ok := c.Srv.(gim.ReadyNotifier); ok {
+		ready = rn.Ready()
+	} else {
+		wait := make(chan struct{})
+		time.AfterFunc(DefaultStartupWait, func() { close(wait) })
+		ready = wait
+	}
+	select {
+	case err := <-errc:
+		atomic.StoreUint32(&c.state, stateClosed)
+		if err == nil {
+			err = errors.New("server stopped")
+		}
+		return err
+	case <-ctx.Done():
+	case <-ready:
+	}
+	if ctx.Err() != nil {
+		c.abortStart(errc)
+		return ctx.Err()
 	}
 
 	// 服务注册
-	if c.Srv.PublicAddress() != "" && c.Srv.PublicPort() != 0 {
-		err := c.Naming.Register(c.Srv)
-		if err != nil {
+	if c.registrable() {
+		if err := c.register(); err != nil {
 			logger.Error(err.Error())
 		}
 	}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	// 连接需要的服务
+	var wg sync.WaitGroup
+	for service := range c.deps {
+		service := service
+		wg.Add(1)
+		gox.Go(func() {
+			defer wg.Done()
+			if err := c.connectToService(service); err != nil {
+				log.Error(fmt.Sprintf("connect to service %s err:%s", service, err.Error()))
+			}
+		})
+	}
+	wg.Wait()
+	return nil
+}
+
+// abortStart 启动被取消时停止已经运行的服务
+func (c *Container) abortStart(errc <-chan error) {
+	atomic.StoreUint32(&c.state, stateClosed)
+	ctx, cancel := context.WithTimeout(context.Background(), c.shutdownOpts.timeout())
+	defer cancel()
+	if err := c.Srv.Shutdown(ctx); err != nil {
+		log.Warn("shutdown server err:" + err.Error())
+	}
+	select {
+	case <-errc:
+	case <-ctx.Done():
+		log.Warn("server did not stop after start was canceled")
+	}
+}
+
+// Stop 按顺序关闭容器，ctx和ShutdownOptions.Timeout共同限制排空连接的时间
+func (c *Container) Stop(ctx context.Context) error {
+	return c.shutdown(ctx)
+}
 
-	logger.Info(fmt.Sprintf("shutdown %s", <-c))
+// Run 启动容器并阻塞到收到退出信号，然后关闭
+func (c *Container) Run() error {
+	if err := c.Start(context.Background()); err != nil {
+		return err
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	logger.Info(fmt.Sprintf("shutdown %s", <-ch))
 
-	return shutdown()
+	return c.Stop(context.Background())
 }
 
-func shutdown() error {
+func (c *Container) connectToService(serviceName string) error {
+	clients := NewClients()
+	c.Lock()
+	c.srvclients[serviceName] = clients
+	c.Unlock()
+	// 1. 先订阅服务的变化，Naming支持时只处理变化的实例，否则回调中是服务当前的全部实例
+	var err error
+	if dn, ok := c.Naming.(naming.DiffNaming); ok {
+		err = dn.SubscribeDiff(serviceName, func(diff naming.Diff) {
+			c.applyDiff(clients, diff)
+		})
+	} else {
+		err = c.Naming.Subscribe(serviceName, func(services []gim.ServiceRegistration) {
+			if ns, ok := c.selector.(NamingSelector); ok {
+				ns.Reset(serviceName, toServices(services))
+			}
+			c.syncClients(clients, services)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	// 2. 再连接已经存在的服务
+	services, err := c.Naming.Find(serviceName)
+	if err != nil {
+		return err
+	}
+	if ns, ok := c.selector.(NamingSelector); ok {
+		ns.Reset(serviceName, toServices(services))
+	}
+	for _, service := range services {
+		if err := c.connect(clients, service); err != nil {
+			log.Warn(err.Error())
+		}
+	}
 	return nil
 }
 
-func connectToService(service struct{}) {
+// syncClients 连接新增的实例，关闭已经下线的实例
+func (c *Container) syncClients(clients IClientMap, services []gim.ServiceRegistration) {
+	alive := make(map[string]struct{}, len(services))
+	for _, service := range services {
+		alive[service.ServiceID()] = struct{}{}
+		if _, ok := clients.Get(service.ServiceID()); ok {
+			continue
+		}
+		log.Info(fmt.Sprintf("watch a new service: %s", service))
+		c.connectAsync(clients, service)
+	}
+	for _, cli := range clients.All() {
+		if _, ok := alive[cli.ServiceID()]; ok {
+			continue
+		}
+		log.Info(fmt.Sprintf("service %s:%s is offline", cli.ServiceName(), cli.ServiceID()))
+		clients.Remove(cli.ServiceID())
+		c.breakers.Remove(cli.ServiceID())
+		cli.Close()
+	}
+}
+
+// applyDiff 关闭移除的实例，连接新增的实例
+func (c *Container) applyDiff(clients IClientMap, diff naming.Diff) {
+	if ns, ok := c.selector.(NamingSelector); ok {
+		ns.Remove(toServices(diff.Removed)...)
+		ns.Add(toServices(diff.Added)...)
+	}
+	for _, service := range diff.Removed {
+		cli, ok := clients.Get(service.ServiceID())
+		if !ok {
+			continue
+		}
+		log.Info(fmt.Sprintf("service %s:%s is offline", service.ServiceName(), service.ServiceID()))
+		clients.Remove(service.ServiceID())
+		c.breakers.Remove(service.ServiceID())
+		cli.Close()
+	}
+	for _, service := range diff.Added {
+		log.Info(fmt.Sprintf("watch a new service: %s", service))
+		c.connectAsync(clients, service)
+	}
+}
+
+func toServices(services []gim.ServiceRegistration) []gim.Service {
+	res := make([]gim.Service, len(services))
+	for i, service := range services {
+		res[i] = service
+	}
+	return res
+}
+
+// connectAsync Naming的回调中不拨号，避免阻塞后续的通知
+func (c *Container) connectAsync(clients IClientMap, service gim.ServiceRegistration) {
+	gox.Go(func() {
+		if err := c.connect(clients, service); err != nil {
+			log.Warn(err.Error())
+		}
+	})
+}
 
+// connect 连接实例，失败时在后台按退避间隔重连，同一个实例同时只有一个连接过程
+func (c *Container) connect(clients IClientMap, service gim.ServiceRegistration) error {
+	// 服务之间只允许使用tcp协议
+	if service.GetProtocol() != "tcp" {
+		return fmt.Errorf("unexpected service protocol: %s", service.GetProtocol())
+	}
+	id := service.ServiceID()
+	if !c.startConnecting(id) {
+		return nil
+	}
+	_, err := c.buildClient(clients, service)
+	if err == nil {
+		c.stopConnecting(id)
+		return nil
+	}
+	gox.Go(func() {
+		defer c.stopConnecting(id)
+		c.reconnect(clients, service)
+	})
+	return err
+}
+
+func (c *Container) startConnecting(id string) bool {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.connecting[id]; ok {
+		return false
+	}
+	c.connecting[id] = struct{}{}
+	return true
+}
+
+func (c *Container) stopConnecting(id string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.connecting, id)
+}
+
+// buildClient 拨号和握手不持有锁，只在添加到clients时加锁
+func (c *Container) buildClient(clients IClientMap, service gim.ServiceRegistration) (gim.Client, error) {
+	var (
+		id   = service.ServiceID()
+		name = service.ServiceName()
+		meta = service.GetMeta()
+	)
+	// 1. 检测连接是否已经存在
+	if cli, ok := clients.Get(id); ok {
+		return cli, nil
+	}
+	if c.dialer == nil {
+		return nil, errors.New("dialer is nil")
+	}
+	// 2. 构建客户端并建立连接
+	tcpcli := tcp.NewClientWithProps(id, name, meta, gim.WithClientHeartbeat(gim.DefaultHeartbeat))
+	tcpcli.SetDialer(c.dialer)
+	if err := tcpcli.Connect(service.DialURL()); err != nil {
+		return nil, errors.Wrapf(err, "connect to %s", service)
+	}
+	cli := newDepClient(tcpcli, service, c.breakers.Get(id))
+	// 3. 添加到客户端集合中，并发建立的连接只保留一个
+	c.Lock()
+	if cur, ok := clients.Get(id); ok {
+		c.Unlock()
+		tcpcli.Close()
+		return cur, nil
+	}
+	clients.Add(cli)
+	c.Unlock()
+	// 4. 读取逻辑服务返回的消息，连接断开后移除并重连
+	gox.Go(func() {
+		if err := c.readLoop(cli); err != nil {
+			log.Info(fmt.Sprintf("read loop of %s:%s stopped: %s", name, id, err.Error()))
+		}
+		if cur, ok := clients.Get(id); ok && cur == gim.Client(cli) {
+			clients.Remove(id)
+		}
+		cli.Close()
+		if c.startConnecting(id) {
+			c.reconnect(clients, service)
+			c.stopConnecting(id)
+		}
+	})
+	return cli, nil
 }
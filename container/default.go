@@ -0,0 +1,59 @@
+package container
+
+import (
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+// 包级别的函数都作用在默认实例上
+var c = New()
+
+func Default() *Container {
+	return c
+}
+
+func Init(srv gim.Server, deps ...string) error {
+	return c.Init(srv, deps...)
+}
+
+// SetDialer set tcp dialer
+func SetDialer(dialer gim.Dialer) {
+	c.SetDialer(dialer)
+}
+
+// SetSecret set the secret shared by services in internal handshakes
+func SetSecret(secret string) {
+	c.SetSecret(secret)
+}
+
+// SetSelector set a default selector
+func SetSelector(selector Selector) {
+	c.SetSelector(selector)
+}
+
+// SetShutdownOptions set options of the shutdown sequence
+func SetShutdownOptions(opts ...ShutdownOptionsFunc) {
+	c.SetShutdownOptions(opts...)
+}
+
+// Start 启动默认实例并阻塞到收到退出信号
+func Start() error {
+	return c.Run()
+}
+
+func Forward(serviceName string, packet *pkt.LogicPkt) error {
+	return c.Forward(serviceName, packet)
+}
+
+func ForwardWithSelector(serviceName string, packet *pkt.LogicPkt, selector Selector) error {
+	return c.ForwardWithSelector(serviceName, packet, selector)
+}
+
+func Push(gatewayID string, channelIDs []string, packet *pkt.LogicPkt) error {
+	return c.Push(gatewayID, channelIDs, packet)
+}
+
+// Health 默认实例依赖服务的熔断状态
+func Health() []BreakerSnapshot {
+	return c.Health()
+}
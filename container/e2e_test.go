@@ -0,0 +1,196 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+	"github.com/kkakoz/gim/proto/pkt"
+	"github.com/kkakoz/gim/tcp"
+)
+
+type nopStateListener struct{}
+
+func (nopStateListener) Disconnect(string) error { return nil }
+
+// forwardListener 网关把客户端的消息包转发给chat服务
+type forwardListener struct {
+	t *testing.T
+	c *Container
+}
+
+func (l *forwardListener) Receive(agent gim.Agent, payload []byte) {
+	packet, err := pkt.Read(bytes.NewReader(payload))
+	if err != nil {
+		l.t.Error(err)
+		return
+	}
+	packet.ChannelId = agent.ID()
+	if err = l.c.Forward("chat", packet); err != nil {
+		l.t.Error(err)
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func newTestService(t *testing.T, id, name string) (gim.ServiceRegistration, string) {
+	port := freePort(t)
+	service := &naming.DefaultService{Id: id, Name: name, Protocol: "tcp", Address: "127.0.0.1", Port: port}
+	return service, "127.0.0.1:" + strconv.Itoa(port)
+}
+
+func stopContainer(t *testing.T, c *Container) {
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_ = c.Stop(ctx)
+	})
+}
+
+// readPacket 读取客户端收到的下一个消息包，跳过心跳
+func readPacket(t *testing.T, conn gim.Conn) *pkt.LogicPkt {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		frame, err := conn.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.GetOpCode() != gim.OpText && frame.GetOpCode() != gim.OpBinary {
+			continue
+		}
+		packet, err := pkt.Read(bytes.NewReader(frame.GetPayload()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return packet
+	}
+}
+
+// TestGatewayAndLogic 同一个进程中运行网关和逻辑服务，客户端的请求经网关转发给逻辑服务，
+// 响应和推送经内部连接回到客户端
+func TestGatewayAndLogic(t *testing.T) {
+	ns := naming.NewMemoryNaming()
+	shutdown := WithShutdown(WithDeregisterWait(0), WithShutdownTimeout(time.Second))
+
+	// 1. 逻辑服务，使用InnerAcceptor
+	logicService, logicAddr := newTestService(t, "chat-1", "chat")
+	logicSrv := tcp.NewServer(logicAddr, logicService)
+	router := gim.NewRouter()
+	router.Handle(pkt.CommandChatUserTalk, func(ctx *gim.Context) error {
+		return ctx.Resp(&pkt.MessageResp{MessageId: 42})
+	})
+	logicSrv.SetMessageListener(router)
+	logicSrv.SetStateListener(nopStateListener{})
+	logic := New(WithServer(logicSrv), WithNaming(ns), WithSecret(testSecret), WithInnerAcceptor(), shutdown)
+	if err := logic.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stopContainer(t, logic)
+
+	// 2. 网关，客户端使用Server默认的握手
+	gatewayService, gatewayAddr := newTestService(t, "gateway-1", "gateway")
+	gatewaySrv := tcp.NewServer(gatewayAddr, gatewayService)
+	gatewaySrv.SetStateListener(nopStateListener{})
+	gateway := New(WithServer(gatewaySrv), WithNaming(ns), WithSecret(testSecret), WithDeps("chat"), shutdown)
+	gatewaySrv.SetMessageListener(&forwardListener{t: t, c: gateway})
+	if err := gateway.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stopContainer(t, gateway)
+	waitUntil(t, "gateway did not connect to chat-1", func() bool {
+		clients := gateway.srvclients["chat"]
+		_, ok := clients.Get("chat-1")
+		return ok
+	})
+
+	// 3. 客户端登录网关并发送请求
+	raw, err := net.Dial("tcp", gatewayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	conn := tcp.NewConn(raw)
+	if err = conn.WriteFrame(gim.OpBinary, []byte("u1")); err != nil {
+		t.Fatal(err)
+	}
+	req := pkt.New(pkt.CommandChatUserTalk, pkt.WithSeq(1))
+	req.WriteBody(&pkt.MessageReq{Type: 1, Body: "hello"})
+	waitUntil(t, "u1 did not login", func() bool {
+		_, ok := gatewaySrv.(*tcp.Server).Get("u1")
+		return ok
+	})
+	if err = conn.WriteFrame(gim.OpBinary, pkt.Marshal(req)); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := readPacket(t, conn)
+	var body pkt.MessageResp
+	if err = resp.ReadBody(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Flag != pkt.Flag_Response || resp.Sequence != 1 || resp.Status != pkt.Status_Success || body.MessageId != 42 {
+		t.Fatalf("unexpected response %s", resp)
+	}
+
+	// 4. 逻辑服务按网关的ServiceID推送
+	push := pkt.New(pkt.CommandChatUserTalk)
+	push.WriteBody(&pkt.MessagePush{MessageId: 43, Body: "world"})
+	if err = logic.Push("gateway-1", []string{"u1"}, push); err != nil {
+		t.Fatal(err)
+	}
+	got := readPacket(t, conn)
+	if got.Flag != pkt.Flag_Push || got.Command != pkt.CommandChatUserTalk {
+		t.Fatalf("unexpected push %s", got)
+	}
+}
+
+func TestStart_CanceledStopsServer(t *testing.T) {
+	service, addr := newTestService(t, "chat-1", "chat")
+	srv := tcp.NewServer(addr, service)
+	srv.SetStateListener(nopStateListener{})
+	c := New(WithServer(srv), WithSecret(testSecret))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Start(ctx); err != context.Canceled {
+		t.Fatalf("Start got %v, want context.Canceled", err)
+	}
+	// 服务没有继续监听
+	time.Sleep(50 * time.Millisecond)
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		_ = conn.Close()
+		t.Fatal("server is still listening after Start was canceled")
+	}
+}
+
+func TestStart_EmptySecret(t *testing.T) {
+	for name, opt := range map[string]OptionFunc{"deps": WithDeps("chat"), "inner acceptor": WithInnerAcceptor()} {
+		service, addr := newTestService(t, "gateway-1", "gateway")
+		c := New(WithServer(tcp.NewServer(addr, service)), opt)
+		if err := c.Start(context.Background()); err == nil {
+			t.Fatalf("Start with %s and an empty secret should fail", name)
+		}
+	}
+	// 没有依赖的服务时不需要内部握手
+	service, addr := newTestService(t, "chat-1", "chat")
+	srv := tcp.NewServer(addr, service)
+	srv.SetStateListener(nopStateListener{})
+	c := New(WithServer(srv), WithShutdown(WithDeregisterWait(0)))
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stopContainer(t, c)
+}
@@ -0,0 +1,138 @@
+package container
+
+import (
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+// 服务meta和消息包Header.Meta中表示位置的key
+const (
+	MetaZone   = "zone"
+	MetaRegion = "region"
+)
+
+// Filter 服务过滤条件，Selector可以先过滤再选择
+type Filter func(gim.Service) bool
+
+// FilterServices 返回满足所有filters的服务
+func FilterServices(services []gim.Service, filters ...Filter) []gim.Service {
+	if len(filters) == 0 {
+		return services
+	}
+	res := make([]gim.Service, 0, len(services))
+	for _, service := range services {
+		if And(filters...)(service) {
+			res = append(res, service)
+		}
+	}
+	return res
+}
+
+func And(filters ...Filter) Filter {
+	return func(service gim.Service) bool {
+		for _, f := range filters {
+			if !f(service) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func Or(filters ...Filter) Filter {
+	return func(service gim.Service) bool {
+		for _, f := range filters {
+			if f(service) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func Not(filter Filter) Filter {
+	return func(service gim.Service) bool {
+		return !filter(service)
+	}
+}
+
+// HasTags 包含所有tags的服务，服务没有tags信息时不满足
+func HasTags(tags ...string) Filter {
+	return func(service gim.Service) bool {
+		s, ok := service.(interface{ GetTags() []string })
+		if !ok {
+			return len(tags) == 0
+		}
+		have := make(map[string]struct{}, len(s.GetTags()))
+		for _, tag := range s.GetTags() {
+			have[tag] = struct{}{}
+		}
+		for _, tag := range tags {
+			if _, ok := have[tag]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// InNamespace 属于namespace的服务
+func InNamespace(namespace string) Filter {
+	return func(service gim.Service) bool {
+		s, ok := service.(interface{ GetNamespace() string })
+		if !ok {
+			return namespace == ""
+		}
+		return s.GetNamespace() == namespace
+	}
+}
+
+// MetaEquals meta中key的值为value的服务
+func MetaEquals(key, value string) Filter {
+	return func(service gim.Service) bool {
+		v, ok := service.GetMeta()[key]
+		return ok && v == value
+	}
+}
+
+// ZoneSelector 优先选择与请求相同zone的实例，本zone没有健康实例时回退到相同region，再回退到全部实例
+type ZoneSelector struct {
+	// 在候选实例中做最终选择，为nil时使用HashSelector
+	Next Selector
+	// 健康检查条件，为nil时认为所有实例都健康
+	Healthy Filter
+}
+
+func (s *ZoneSelector) Lookup(header *pkt.Header, services []gim.Service) string {
+	next := s.Next
+	if next == nil {
+		next = &HashSelector{}
+	}
+	candidates := services
+	if s.Healthy != nil {
+		// 没有健康的实例时，仍然在全部实例中选择
+		if healthy := FilterServices(services, s.Healthy); len(healthy) > 0 {
+			candidates = healthy
+		}
+	}
+	for _, key := range []string{MetaZone, MetaRegion} {
+		value := HeaderMeta(header, key)
+		if value == "" {
+			continue
+		}
+		if local := FilterServices(candidates, MetaEquals(key, value)); len(local) > 0 {
+			return next.Lookup(header, local)
+		}
+	}
+	return next.Lookup(header, candidates)
+}
+
+// HeaderMeta 读取Header.Meta中key的值
+func HeaderMeta(header *pkt.Header, key string) string {
+	for _, m := range header.GetMeta() {
+		if m.GetKey() == key {
+			return m.GetValue()
+		}
+	}
+	return ""
+}
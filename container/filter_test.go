@@ -0,0 +1,112 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+	"github.com/kkakoz/gim/proto/pkt"
+	"github.com/kkakoz/gim/tcp"
+)
+
+func located(id, zone, region string) gim.Service {
+	return &naming.DefaultService{Id: id, Meta: map[string]string{MetaZone: zone, MetaRegion: region}}
+}
+
+func serviceIDs(services []gim.Service) []string {
+	ids := make([]string, 0, len(services))
+	for _, service := range services {
+		ids = append(ids, service.ServiceID())
+	}
+	return ids
+}
+
+func TestFilters(t *testing.T) {
+	a := &naming.DefaultService{Id: "a", Namespace: "im", Tags: []string{"v1", "canary"}, Meta: map[string]string{MetaZone: "z1"}}
+	b := &naming.DefaultService{Id: "b", Namespace: "im", Tags: []string{"v1"}, Meta: map[string]string{MetaZone: "z2"}}
+	c := &naming.DefaultService{Id: "c", Tags: []string{"v2"}}
+	services := []gim.Service{a, b, c}
+
+	cases := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{"tags", HasTags("v1", "canary"), "a"},
+		{"namespace", InNamespace("im"), "ab"},
+		{"empty namespace", InNamespace(""), "c"},
+		{"meta", MetaEquals(MetaZone, "z2"), "b"},
+		{"missing meta", MetaEquals(MetaZone, ""), ""},
+		{"and", And(InNamespace("im"), HasTags("v1")), "ab"},
+		{"or", Or(HasTags("canary"), HasTags("v2")), "ac"},
+		{"not", Not(InNamespace("im")), "c"},
+	}
+	for _, cs := range cases {
+		got := ""
+		for _, id := range serviceIDs(FilterServices(services, cs.filter)) {
+			got += id
+		}
+		if got != cs.want {
+			t.Errorf("%s: got %q, want %q", cs.name, got, cs.want)
+		}
+	}
+	if got := FilterServices(services); len(got) != 3 {
+		t.Fatalf("no filters got %d services, want 3", len(got))
+	}
+}
+
+func TestClientMapServices(t *testing.T) {
+	clients := NewClients()
+	clients.Add(newDepClient(tcp.NewClientWithProps("a", "chat", map[string]string{MetaZone: "z1", "v": "1"}), nil, nil))
+	clients.Add(newDepClient(tcp.NewClientWithProps("b", "chat", map[string]string{MetaZone: "z1", "v": "2"}), nil, nil))
+	if got := clients.Services(nil); len(got) != 2 {
+		t.Fatalf("got %d services, want 2", len(got))
+	}
+	if got := serviceIDs(clients.Services([]string{MetaZone, "z1", "v", "2"})); len(got) != 1 || got[0] != "b" {
+		t.Fatalf("got %v, want [b]", got)
+	}
+	// 缺少value的条件不匹配任何服务
+	if got := clients.Services([]string{MetaZone}); got == nil || len(got) != 0 {
+		t.Fatalf("odd kvs got %v, want an empty result", got)
+	}
+}
+
+func TestZoneSelector(t *testing.T) {
+	services := []gim.Service{
+		located("a", "z1", "r1"),
+		located("b", "z2", "r1"),
+		located("c", "z3", "r2"),
+	}
+	header := func(zone, region string) *pkt.Header {
+		packet := pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1"))
+		packet.AddStringMeta(MetaZone, zone)
+		packet.AddStringMeta(MetaRegion, region)
+		return &packet.Header
+	}
+	s := &ZoneSelector{Next: orderSelector{}}
+	cases := []struct {
+		name         string
+		zone, region string
+		want         string
+	}{
+		{"same zone", "z2", "r1", "b"},
+		{"same region", "z9", "r2", "c"},
+		{"all", "z9", "r9", "a"},
+	}
+	for _, cs := range cases {
+		if got := s.Lookup(header(cs.zone, cs.region), services); got != cs.want {
+			t.Errorf("%s: got %s, want %s", cs.name, got, cs.want)
+		}
+	}
+
+	// 本zone的实例不健康时回退到相同region
+	s.Healthy = Not(MetaEquals(MetaZone, "z2"))
+	if got := s.Lookup(header("z2", "r1"), services); got != "a" {
+		t.Fatalf("unhealthy zone: got %s, want a", got)
+	}
+	// 没有健康的实例时仍然在全部实例中选择
+	s.Healthy = func(gim.Service) bool { return false }
+	if got := s.Lookup(header("z3", "r2"), services); got != "c" {
+		t.Fatalf("no healthy service: got %s, want c", got)
+	}
+}
@@ -0,0 +1,107 @@
+package container
+
+import (
+	"fmt"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+	"github.com/pkg/errors"
+)
+
+// MaxForwardRetry 转发失败时最多发送的实例数，没有拿到探测名额而跳过的实例不计入
+const MaxForwardRetry = 3
+
+// Forward 网关把消息包转发给serviceName服务，由默认的Selector选择实例
+func (c *Container) Forward(serviceName string, packet *pkt.LogicPkt) error {
+	return c.ForwardWithSelector(serviceName, packet, c.selector)
+}
+
+// ForwardWithSelector 使用指定的Selector转发，发送失败时换一个实例重试
+func (c *Container) ForwardWithSelector(serviceName string, packet *pkt.LogicPkt, selector Selector) error {
+	if packet == nil {
+		return errors.New("packet is nil")
+	}
+	if packet.Command == "" {
+		return errno.NewStatus(pkt.Status_InvalidCommand, "command is empty in packet")
+	}
+	if packet.ChannelId == "" {
+		return errno.NewStatus(pkt.Status_InvalidPacketBody, "channelId is empty in packet")
+	}
+	// 标记来源网关，逻辑服务据此把消息推回。在副本上修改，不影响调用方的消息包
+	forward := pkt.NewFrom(&packet.Header)
+	forward.Flag = packet.Flag
+	forward.Body = packet.Body
+	forward.AddStringMeta(pkt.MetaDestServer, c.Srv.ServiceID())
+	payload := pkt.Marshal(forward)
+
+	key, expectResp := requestKey(&packet.Header)
+	excluded := make(map[string]struct{})
+	var lastErr error
+	// 只有实际发送过的实例计入重试次数
+	for sent := 0; sent < MaxForwardRetry; {
+		cli, err := c.lookup(serviceName, &packet.Header, selector, excluded)
+		if err != nil {
+			if lastErr != nil {
+				return lastErr
+			}
+			return err
+		}
+		// 半开状态的探测名额已被占用
+		breaker := c.breakers.Get(cli.ServiceID())
+		if !breaker.Allow() {
+			excluded[cli.ServiceID()] = struct{}{}
+			continue
+		}
+		sent++
+		// 需要响应的请求在收到响应或超时后结束，其它的消息包发送成功即结束
+		if expectResp {
+			breaker.Begin(key)
+		}
+		if lastErr = cli.Send(payload); lastErr == nil {
+			if !expectResp {
+				breaker.Sent()
+			}
+			return nil
+		}
+		breaker.Abort(key, lastErr)
+		log.Warn(fmt.Sprintf("forward %s to %s err:%s", packet.Command, cli.ServiceID(), lastErr.Error()))
+		excluded[cli.ServiceID()] = struct{}{}
+	}
+	return lastErr
+}
+
+// lookup 从服务的客户端中选择一个，跳过excluded中和被熔断摘除的实例
+func (c *Container) lookup(serviceName string, header *pkt.Header, selector Selector, excluded map[string]struct{}) (gim.Client, error) {
+	c.RLock()
+	clients, ok := c.srvclients[serviceName]
+	c.RUnlock()
+	if !ok {
+		return nil, errno.Statusf(pkt.Status_NoDestination, "service %s not found", serviceName)
+	}
+	all := clients.All()
+	services := make([]gim.Service, 0, len(all))
+	ejected := 0
+	for _, cli := range all {
+		if _, ok := excluded[cli.ServiceID()]; ok {
+			continue
+		}
+		if !c.breakers.Get(cli.ServiceID()).Available() {
+			ejected++
+			continue
+		}
+		services = append(services, cli)
+	}
+	if len(services) == 0 {
+		if ejected > 0 {
+			return nil, errno.Statusf(pkt.Status_NoDestination, "all %d instances of %s are ejected", ejected, serviceName)
+		}
+		return nil, errno.Statusf(pkt.Status_NoDestination, "no services found for %s", serviceName)
+	}
+	id := selector.Lookup(header, services)
+	cli, ok := clients.Get(id)
+	if !ok {
+		return nil, errno.Statusf(pkt.Status_NoDestination, "no client found for %s:%s", serviceName, id)
+	}
+	return cli, nil
+}
@@ -0,0 +1,213 @@
+package container
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+// fakeServer 记录Push的数据，channels中没有的id返回SessionNotFound
+type fakeServer struct {
+	gim.Server
+	sync.Mutex
+	id       string
+	channels map[string]bool
+	pushed   map[string][][]byte
+}
+
+func newFakeServer(id string, channels ...string) *fakeServer {
+	s := &fakeServer{id: id, channels: make(map[string]bool), pushed: make(map[string][][]byte)}
+	for _, ch := range channels {
+		s.channels[ch] = true
+	}
+	return s
+}
+
+func (s *fakeServer) ServiceID() string { return s.id }
+
+func (s *fakeServer) Push(id string, data []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.channels) > 0 && !s.channels[id] {
+		return errno.NewStatus(pkt.Status_SessionNotFound, "channel not found")
+	}
+	s.pushed[id] = append(s.pushed[id], data)
+	return nil
+}
+
+func (s *fakeServer) received(id string) [][]byte {
+	s.Lock()
+	defer s.Unlock()
+	return s.pushed[id]
+}
+
+// fakeClient 依赖服务的客户端，err不为nil时Send失败
+type fakeClient struct {
+	gim.Client
+	sync.Mutex
+	id   string
+	err  error
+	sent [][]byte
+}
+
+func (c *fakeClient) ServiceID() string          { return c.id }
+func (c *fakeClient) ServiceName() string        { return "chat" }
+func (c *fakeClient) GetMeta() map[string]string { return nil }
+func (c *fakeClient) Close()                     {}
+
+func (c *fakeClient) Send(payload []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.sent = append(c.sent, payload)
+	return nil
+}
+
+// orderSelector 总是选择第一个可用的实例
+type orderSelector struct{}
+
+func (orderSelector) Lookup(_ *pkt.Header, services []gim.Service) string {
+	return services[0].ServiceID()
+}
+
+// probeSelector 优先选择半开的实例，并像另一个并发的请求一样先占用它的探测名额
+type probeSelector struct {
+	c *Container
+}
+
+func (s probeSelector) Lookup(_ *pkt.Header, services []gim.Service) string {
+	for _, service := range services {
+		breaker := s.c.breakers.Get(service.ServiceID())
+		if breaker.State() == BreakerHalfOpen {
+			breaker.Allow()
+			return service.ServiceID()
+		}
+	}
+	return services[0].ServiceID()
+}
+
+func newForwardContainer(clients ...gim.Client) *Container {
+	return newForwardContainerWith(nil, clients...)
+}
+
+func newForwardContainerWith(opts []OptionFunc, clients ...gim.Client) *Container {
+	opts = append([]OptionFunc{WithServer(newFakeServer("gateway-1")), WithSelector(orderSelector{})}, opts...)
+	c := New(opts...)
+	m := NewClients()
+	for _, cli := range clients {
+		m.Add(cli)
+	}
+	c.srvclients["chat"] = m
+	return c
+}
+
+func TestForward_NoDestination(t *testing.T) {
+	c := newForwardContainer()
+	packet := pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1"))
+	if err := c.Forward("unknown", packet); errno.Code(err) != pkt.Status_NoDestination {
+		t.Fatalf("unknown service got %v", err)
+	}
+	if err := c.Forward("chat", packet); errno.Code(err) != pkt.Status_NoDestination {
+		t.Fatalf("service without instances got %v", err)
+	}
+	if err := c.Forward("chat", pkt.New(pkt.CommandChatUserTalk)); errno.Code(err) != pkt.Status_InvalidPacketBody {
+		t.Fatalf("packet without channelId got %v", err)
+	}
+}
+
+func TestForward_Retry(t *testing.T) {
+	a := &fakeClient{id: "a", err: errors.New("broken pipe")}
+	b := &fakeClient{id: "b"}
+	c := newForwardContainer(a, b)
+	if err := c.Forward("chat", pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1"))); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.sent) != 1 {
+		t.Fatalf("b got %d packets, want 1", len(b.sent))
+	}
+	packet, err := pkt.Read(bytes.NewReader(b.sent[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server, _ := packet.GetMeta(pkt.MetaDestServer); server != "gateway-1" {
+		t.Fatalf("dest server = %q, want gateway-1", server)
+	}
+
+	// 所有实例都失败时返回发送的错误
+	b.err = errors.New("connection reset")
+	err = c.Forward("chat", pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1")))
+	if err == nil || errno.Code(err) == pkt.Status_NoDestination {
+		t.Fatalf("all instances failed got %v", err)
+	}
+}
+
+func TestForward_PacketNotModified(t *testing.T) {
+	a := &fakeClient{id: "a"}
+	c := newForwardContainer(a)
+	packet := pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1"), pkt.WithSeq(1))
+	packet.Flag = pkt.Flag_Request
+	packet.Body = []byte("hello")
+	if err := c.Forward("chat", packet); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := packet.GetMeta(pkt.MetaDestServer); ok || len(packet.Meta) != 0 {
+		t.Fatalf("caller's packet is modified: %v", packet.Meta)
+	}
+	sent, err := pkt.Read(bytes.NewReader(a.sent[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server, _ := sent.GetMeta(pkt.MetaDestServer); server != "gateway-1" || sent.Flag != pkt.Flag_Request || sent.StringBody() != "hello" {
+		t.Fatalf("unexpected forwarded packet %s", sent)
+	}
+}
+
+// TestForward_SkippedNotCounted 没有拿到探测名额的实例不占用重试次数
+func TestForward_SkippedNotCounted(t *testing.T) {
+	clients := []*fakeClient{{id: "a"}, {id: "b"}, {id: "c"}, {id: "d"}}
+	c := newForwardContainerWith([]OptionFunc{WithBreaker(WithBreakerConsecutiveFailures(1), WithBreakerOpenTimeout(time.Millisecond, 1))},
+		clients[0], clients[1], clients[2], clients[3])
+	// a、b、c处于半开状态，探测名额被其它请求占用
+	for _, cli := range clients[:MaxForwardRetry] {
+		c.breakers.Get(cli.id).Abort("k", errors.New("broken pipe"))
+	}
+	time.Sleep(2 * time.Millisecond)
+	if err := c.ForwardWithSelector("chat", pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1")), probeSelector{c}); err != nil {
+		t.Fatal(err)
+	}
+	if len(clients[3].sent) != 1 {
+		t.Fatalf("d got %d packets, want 1", len(clients[3].sent))
+	}
+}
+
+// TestForward_Pending 只有需要响应的请求留在熔断器中等待响应
+func TestForward_Pending(t *testing.T) {
+	c := newForwardContainer(&fakeClient{id: "a"})
+	breaker := c.breakers.Get("a")
+	if err := c.Forward("chat", pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1"))); err != nil {
+		t.Fatal(err)
+	}
+	if s := breaker.Snapshot(); s.Pending != 0 || s.Requests != 1 {
+		t.Fatalf("packet without a sequence is pending: %+v", s)
+	}
+	if err := c.Forward("chat", pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1"), pkt.WithSeq(1))); err != nil {
+		t.Fatal(err)
+	}
+	if n := breaker.Pending(); n != 1 {
+		t.Fatalf("%d requests are pending, want 1", n)
+	}
+	resp := pkt.NewFrom(&pkt.New(pkt.CommandChatUserTalk, pkt.WithChannel("u1"), pkt.WithSeq(1)).Header)
+	key, _ := requestKey(&resp.Header)
+	breaker.End(key, nil)
+	if n := breaker.Pending(); n != 0 {
+		t.Fatalf("%d requests are pending after the response", n)
+	}
+}
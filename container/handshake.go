@@ -0,0 +1,214 @@
+package container
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+	"github.com/kkakoz/gim/proto/pkt"
+	"github.com/kkakoz/gim/tcp"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	DefaultHandshakeTimeout = 5 * time.Second
+	DefaultHandshakeSkew    = 5 * time.Minute
+)
+
+// InnerDialer 网关连接逻辑服务时使用的内部拨号器，握手时携带自己的ServiceId和签名
+type InnerDialer struct {
+	ServiceID   string
+	ServiceName string
+	Secret      string
+}
+
+func NewInnerDialer(serviceID, serviceName, secret string) *InnerDialer {
+	return &InnerDialer{ServiceID: serviceID, ServiceName: serviceName, Secret: secret}
+}
+
+func (d *InnerDialer) DialAndHandshake(ctx gim.DialerContext) (net.Conn, error) {
+	timeout := ctx.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	// 1. 拨号建立连接
+	conn, err := net.DialTimeout("tcp", ctx.Address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	// 2. 发送握手包
+	ts := time.Now().Unix()
+	nonce := newNonce()
+	req := &pkt.InnerHandshakeReq{
+		ServiceId:   d.ServiceID,
+		ServiceName: d.ServiceName,
+		Timestamp:   ts,
+		Nonce:       nonce,
+		Token:       innerToken(d.Secret, d.ServiceID, d.ServiceName, ts, nonce),
+	}
+	bts, _ := proto.Marshal(req)
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if err = tcp.WriteFrame(conn, gim.OpBinary, bts); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// 3. 读取握手结果
+	frame, err := tcp.NewConn(conn).ReadFrame()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if frame.GetOpCode() == gim.OpClose {
+		_ = conn.Close()
+		return nil, fmt.Errorf("handshake rejected: %s", frame.GetPayload())
+	}
+	var resp pkt.InnerHandshakeResponse
+	if err = proto.Unmarshal(frame.GetPayload(), &resp); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "decode handshake response")
+	}
+	if resp.Code != uint32(pkt.Status_Success) {
+		_ = conn.Close()
+		return nil, fmt.Errorf("handshake rejected: code %d %s", resp.Code, resp.Error)
+	}
+	return conn, nil
+}
+
+// InnerAcceptor 逻辑服务接受网关连接时使用，校验签名并确认对方已注册到Naming，
+// MaxSkew内同一个nonce只接受一次
+type InnerAcceptor struct {
+	Naming naming.Naming
+	Secret string
+	// 允许的时间偏差，为0时使用DefaultHandshakeSkew。nonce保存到时间戳过期之后，
+	// 所以时间戳总是有限制，否则nonce过期后握手包可以被重放
+	MaxSkew time.Duration
+
+	lock   sync.Mutex
+	nonces map[string]time.Time // nonce -> 过期时间
+}
+
+func NewInnerAcceptor(naming naming.Naming, secret string) *InnerAcceptor {
+	return &InnerAcceptor{
+		Naming:  naming,
+		Secret:  secret,
+		MaxSkew: DefaultHandshakeSkew,
+		nonces:  make(map[string]time.Time),
+	}
+}
+
+func (a *InnerAcceptor) Accept(conn gim.Conn, timeout time.Duration) (string, error) {
+	if timeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	// 1. 读取握手包
+	frame, err := conn.ReadFrame()
+	if err != nil {
+		return "", err
+	}
+	var req pkt.InnerHandshakeReq
+	if err = proto.Unmarshal(frame.GetPayload(), &req); err != nil {
+		return "", a.reject(conn, pkt.Status_InvalidPacketBody, errors.Wrap(err, "decode handshake"))
+	}
+	// 2. 校验签名与注册信息
+	if err = a.verify(&req); err != nil {
+		return "", a.reject(conn, pkt.Status_Unauthorized, err)
+	}
+	// 3. 返回握手结果
+	bts, _ := proto.Marshal(&pkt.InnerHandshakeResponse{Code: uint32(pkt.Status_Success)})
+	if err = conn.WriteFrame(gim.OpBinary, bts); err != nil {
+		return "", err
+	}
+	return req.ServiceId, nil
+}
+
+func (a *InnerAcceptor) verify(req *pkt.InnerHandshakeReq) error {
+	if req.ServiceId == "" || req.ServiceName == "" {
+		return errors.New("service id or name is empty")
+	}
+	skew := time.Since(time.Unix(req.Timestamp, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > a.maxSkew() {
+		return fmt.Errorf("handshake timestamp expired: %s", skew)
+	}
+	expect := innerToken(a.Secret, req.ServiceId, req.ServiceName, req.Timestamp, req.Nonce)
+	if !hmac.Equal([]byte(expect), []byte(req.Token)) {
+		return errors.New("invalid handshake token")
+	}
+	if err := a.useNonce(req.Nonce); err != nil {
+		return err
+	}
+	if a.Naming == nil {
+		return errors.New("no naming")
+	}
+	services, err := a.Naming.Find(req.ServiceName)
+	if err != nil {
+		return errors.Wrap(err, "find service")
+	}
+	for _, service := range services {
+		if service.ServiceID() == req.ServiceId {
+			return nil
+		}
+	}
+	return fmt.Errorf("service %s:%s is not registered", req.ServiceName, req.ServiceId)
+}
+
+func (a *InnerAcceptor) reject(conn gim.Conn, status pkt.Status, err error) error {
+	bts, _ := proto.Marshal(&pkt.InnerHandshakeResponse{Code: uint32(status), Error: err.Error()})
+	_ = conn.WriteFrame(gim.OpBinary, bts)
+	return err
+}
+
+// useNonce 签名通过后才记录nonce，过期的nonce在这里清理
+func (a *InnerAcceptor) useNonce(nonce string) error {
+	if nonce == "" {
+		return errors.New("handshake nonce is empty")
+	}
+	now := time.Now()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.nonces == nil {
+		a.nonces = make(map[string]time.Time)
+	}
+	for n, expire := range a.nonces {
+		if now.After(expire) {
+			delete(a.nonces, n)
+		}
+	}
+	if _, ok := a.nonces[nonce]; ok {
+		return errors.New("handshake is replayed")
+	}
+	// 时间戳可以向两个方向偏差MaxSkew
+	a.nonces[nonce] = now.Add(2 * a.maxSkew())
+	return nil
+}
+
+func (a *InnerAcceptor) maxSkew() time.Duration {
+	if a.MaxSkew > 0 {
+		return a.MaxSkew
+	}
+	return DefaultHandshakeSkew
+}
+
+func innerToken(secret, serviceID, serviceName string, ts int64, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(serviceID + "|" + serviceName + "|" + strconv.FormatInt(ts, 10) + "|" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
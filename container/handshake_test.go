@@ -0,0 +1,213 @@
+package container
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+	"github.com/kkakoz/gim/proto/pkt"
+	"github.com/kkakoz/gim/tcp"
+	"google.golang.org/protobuf/proto"
+)
+
+const testSecret = "test-secret"
+
+type acceptResult struct {
+	id  string
+	err error
+}
+
+func newTestAcceptor(secret string) *InnerAcceptor {
+	ns := naming.NewMemoryNaming()
+	_ = ns.Register(&naming.DefaultService{Id: "gateway-1", Name: "gateway", Protocol: "tcp", Address: "127.0.0.1", Port: 8000})
+	return NewInnerAcceptor(ns, secret)
+}
+
+// startAcceptor 在本地端口上用InnerAcceptor接受连接，返回地址和每次握手的结果
+func startAcceptor(t *testing.T, secret string) (string, <-chan acceptResult) {
+	return serveAcceptor(t, newTestAcceptor(secret), time.Second)
+}
+
+func serveAcceptor(t *testing.T, acceptor *InnerAcceptor, timeout time.Duration) (string, <-chan acceptResult) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	results := make(chan acceptResult, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			id, err := acceptor.Accept(tcp.NewConn(conn), timeout)
+			results <- acceptResult{id: id, err: err}
+			_ = conn.Close()
+		}
+	}()
+	return listener.Addr().String(), results
+}
+
+// sendHandshake 发送指定的握手包，返回响应码
+func sendHandshake(t *testing.T, addr string, req *pkt.InnerHandshakeReq) uint32 {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	bts, _ := proto.Marshal(req)
+	if err = tcp.WriteFrame(conn, gim.OpBinary, bts); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := tcp.NewConn(conn).ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp pkt.InnerHandshakeResponse
+	if err = proto.Unmarshal(frame.GetPayload(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Code
+}
+
+func TestInnerHandshake(t *testing.T) {
+	addr, results := startAcceptor(t, testSecret)
+	dialer := NewInnerDialer("gateway-1", "gateway", testSecret)
+	conn, err := dialer.DialAndHandshake(gim.DialerContext{Address: addr, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if res := <-results; res.err != nil || res.id != "gateway-1" {
+		t.Fatalf("accept got %q %v, want gateway-1", res.id, res.err)
+	}
+}
+
+func TestInnerHandshake_BadSecret(t *testing.T) {
+	addr, results := startAcceptor(t, testSecret)
+	dialer := NewInnerDialer("gateway-1", "gateway", "wrong-secret")
+	if _, err := dialer.DialAndHandshake(gim.DialerContext{Address: addr, Timeout: time.Second}); err == nil {
+		t.Fatal("handshake with a wrong secret is accepted")
+	}
+	if res := <-results; res.err == nil {
+		t.Fatalf("acceptor accepted %q", res.id)
+	}
+}
+
+func TestInnerHandshake_Replay(t *testing.T) {
+	addr, results := startAcceptor(t, testSecret)
+	ts := time.Now().Unix()
+	req := &pkt.InnerHandshakeReq{
+		ServiceId:   "gateway-1",
+		ServiceName: "gateway",
+		Timestamp:   ts,
+		Nonce:       newNonce(),
+	}
+	req.Token = innerToken(testSecret, req.ServiceId, req.ServiceName, ts, req.Nonce)
+
+	if code := sendHandshake(t, addr, req); code != uint32(pkt.Status_Success) {
+		t.Fatalf("first handshake got code %d", code)
+	}
+	<-results
+	// 截获的握手包原样重放
+	if code := sendHandshake(t, addr, req); code != uint32(pkt.Status_Unauthorized) {
+		t.Fatalf("replayed handshake got code %d, want %d", code, pkt.Status_Unauthorized)
+	}
+	if res := <-results; res.err == nil {
+		t.Fatal("replayed handshake is accepted")
+	}
+}
+
+func TestInnerHandshake_ZeroTimeout(t *testing.T) {
+	addr, results := serveAcceptor(t, newTestAcceptor(testSecret), 0)
+	dialer := NewInnerDialer("gateway-1", "gateway", testSecret)
+	conn, err := dialer.DialAndHandshake(gim.DialerContext{Address: addr, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if res := <-results; res.err != nil {
+		t.Fatalf("accept with zero timeout got %v", res.err)
+	}
+}
+
+// MaxSkew为0时时间戳也有限制，nonce过期后不能重放
+func TestInnerHandshake_ExpiredTimestamp(t *testing.T) {
+	acceptor := newTestAcceptor(testSecret)
+	acceptor.MaxSkew = 0
+	addr, results := serveAcceptor(t, acceptor, time.Second)
+	ts := time.Now().Add(-2 * DefaultHandshakeSkew).Unix()
+	req := &pkt.InnerHandshakeReq{
+		ServiceId:   "gateway-1",
+		ServiceName: "gateway",
+		Timestamp:   ts,
+		Nonce:       newNonce(),
+	}
+	req.Token = innerToken(testSecret, req.ServiceId, req.ServiceName, ts, req.Nonce)
+	if code := sendHandshake(t, addr, req); code != uint32(pkt.Status_Unauthorized) {
+		t.Fatalf("expired handshake got code %d, want %d", code, pkt.Status_Unauthorized)
+	}
+	if res := <-results; res.err == nil {
+		t.Fatal("expired handshake is accepted")
+	}
+}
+
+// payloadConn 读到固定的握手包，记录写出的帧
+type payloadConn struct {
+	gim.Conn
+	in  []byte
+	out [][]byte
+}
+
+func (c *payloadConn) SetReadDeadline(time.Time) error { return nil }
+func (c *payloadConn) ReadFrame() (gim.Frame, error) {
+	return &tcp.Frame{OpCode: gim.OpBinary, Payload: c.in}, nil
+}
+func (c *payloadConn) WriteFrame(_ gim.OpCode, payload []byte) error {
+	c.out = append(c.out, payload)
+	return nil
+}
+
+func FuzzInnerHandshake(f *testing.F) {
+	ts := time.Now().Unix()
+	valid := &pkt.InnerHandshakeReq{ServiceId: "gateway-1", ServiceName: "gateway", Timestamp: ts, Nonce: newNonce()}
+	valid.Token = innerToken(testSecret, valid.ServiceId, valid.ServiceName, ts, valid.Nonce)
+	bts, _ := proto.Marshal(valid)
+	f.Add(bts)
+	bts, _ = proto.Marshal(&pkt.InnerHandshakeReq{ServiceId: "gateway-1", ServiceName: "gateway", Timestamp: ts, Token: valid.Token})
+	f.Add(bts)
+	f.Add([]byte{0x0a, 0xff, 0xff})
+	acceptor := newTestAcceptor(testSecret)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		conn := &payloadConn{in: data}
+		id, err := acceptor.Accept(conn, time.Second)
+		// 接受和拒绝都要回复握手结果
+		if len(conn.out) != 1 {
+			t.Fatalf("got %d responses, want 1", len(conn.out))
+		}
+		var resp pkt.InnerHandshakeResponse
+		if uerr := proto.Unmarshal(conn.out[0], &resp); uerr != nil {
+			t.Fatal(uerr)
+		}
+		if err != nil {
+			if resp.Code == uint32(pkt.Status_Success) {
+				t.Fatalf("rejected with a success response: %v", err)
+			}
+			return
+		}
+		var req pkt.InnerHandshakeReq
+		if uerr := proto.Unmarshal(data, &req); uerr != nil || id != req.ServiceId || resp.Code != uint32(pkt.Status_Success) {
+			t.Fatalf("accepted %q with code %d from %v", id, resp.Code, &req)
+		}
+		// 同一个握手包不能被接受两次
+		if _, err = acceptor.Accept(&payloadConn{in: data}, time.Second); err == nil {
+			t.Fatal("replayed handshake is accepted")
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package container
+
+import (
+	"fmt"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+	"github.com/pkg/errors"
+)
+
+// Push 逻辑服务通过内部连接把一个消息包推送给gatewayID网关上的多个channel
+func (c *Container) Push(gatewayID string, channelIDs []string, packet *pkt.LogicPkt) error {
+	if packet == nil {
+		return errors.New("packet is nil")
+	}
+	if len(channelIDs) == 0 {
+		return errno.NewStatus(pkt.Status_NoDestination, "channelIds is empty")
+	}
+	// 逻辑服务主动发出的包作为推送处理
+	if packet.Flag == pkt.Flag_Request {
+		packet.Flag = pkt.Flag_Push
+	}
+	packet.AddStringMeta(pkt.MetaDestServer, gatewayID)
+	packet.DelMeta(pkt.MetaDestChannels)
+	packet.AddMetaValues(pkt.MetaDestChannels, channelIDs...)
+	return c.Srv.Push(gatewayID, pkt.Marshal(packet))
+}
+
+// pushMessage 网关收到逻辑服务的推送后，分发给本地的channel，返回已经不存在的channel
+func (c *Container) pushMessage(packet *pkt.LogicPkt) ([]string, error) {
+	server, _ := packet.GetMeta(pkt.MetaDestServer)
+	if server != c.Srv.ServiceID() {
+		return nil, fmt.Errorf("dest_server is incorrect, %s != %s", server, c.Srv.ServiceID())
+	}
+	channels := packet.MetaValues(pkt.MetaDestChannels)
+	if len(channels) == 0 {
+		return nil, errors.New("dest_channels is nil")
+	}
+	// 内部使用的meta不需要发给客户端
+	packet.DelMeta(pkt.MetaDestServer)
+	packet.DelMeta(pkt.MetaDestChannels)
+	payload := pkt.Marshal(packet)
+
+	var missing []string
+	for _, channelID := range channels {
+		err := c.Srv.Push(channelID, payload)
+		if err == nil {
+			continue
+		}
+		switch errno.Code(err) {
+		case pkt.Status_SessionNotFound, pkt.Status_ConnectionClosed:
+			missing = append(missing, channelID)
+		default:
+			log.Warn(fmt.Sprintf("push to %s err:%s", channelID, err.Error()))
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,73 @@
+package container
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+func TestPush(t *testing.T) {
+	srv := newFakeServer("chat-1")
+	c := New(WithServer(srv))
+	if err := c.Push("gateway-1", nil, pkt.New(pkt.CommandChatUserTalk)); errno.Code(err) != pkt.Status_NoDestination {
+		t.Fatalf("push without channels got %v", err)
+	}
+	if err := c.Push("gateway-1", []string{"u1", "u2,u3"}, pkt.New(pkt.CommandChatUserTalk)); err != nil {
+		t.Fatal(err)
+	}
+	// 逻辑服务通过网关的内部连接发送
+	sent := srv.received("gateway-1")
+	if len(sent) != 1 {
+		t.Fatalf("gateway-1 got %d packets, want 1", len(sent))
+	}
+	packet, err := pkt.Read(bytes.NewReader(sent[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	channels := packet.MetaValues(pkt.MetaDestChannels)
+	if packet.Flag != pkt.Flag_Push || !reflect.DeepEqual(channels, []string{"u1", "u2,u3"}) {
+		t.Fatalf("unexpected packet %s", packet)
+	}
+}
+
+func TestPushMessage_FanOut(t *testing.T) {
+	srv := newFakeServer("gateway-1", "u1", "u3,x")
+	c := New(WithServer(srv))
+	packet := pkt.New(pkt.CommandChatUserTalk)
+	packet.Flag = pkt.Flag_Push
+	packet.AddStringMeta(pkt.MetaDestServer, "gateway-1")
+	// channelID中的逗号不会被拆分
+	packet.AddMetaValues(pkt.MetaDestChannels, "u1", "u2", "u3,x", "u4")
+
+	missing, err := c.pushMessage(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(missing)
+	if !reflect.DeepEqual(missing, []string{"u2", "u4"}) {
+		t.Fatalf("missing = %v, want [u2 u4]", missing)
+	}
+	for _, id := range []string{"u1", "u3,x"} {
+		sent := srv.received(id)
+		if len(sent) != 1 {
+			t.Fatalf("%s got %d packets, want 1", id, len(sent))
+		}
+		// 内部使用的meta不发给客户端
+		got, err := pkt.Read(bytes.NewReader(sent[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Meta) != 0 {
+			t.Fatalf("%s got meta %v", id, got.Meta)
+		}
+	}
+
+	packet.AddStringMeta(pkt.MetaDestServer, "gateway-2")
+	if _, err = c.pushMessage(packet); err == nil {
+		t.Fatal("push for another gateway is accepted")
+	}
+}
@@ -0,0 +1,111 @@
+package container
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+	"go.uber.org/zap"
+)
+
+const (
+	reconnectDelay    = time.Second
+	reconnectMaxDelay = 30 * time.Second
+	reconnectTimes    = 10
+)
+
+// readLoop 读取依赖服务返回的消息包，直到连接断开
+func (c *Container) readLoop(cli gim.Client) error {
+	log := log.With(zap.String("func", "readLoop"), zap.String("id", cli.ServiceID()))
+	for {
+		frame, err := cli.Read()
+		if err != nil {
+			return err
+		}
+		if frame.GetOpCode() != gim.OpBinary && frame.GetOpCode() != gim.OpText {
+			continue
+		}
+		packet, err := pkt.Read(bytes.NewReader(frame.GetPayload()))
+		if err != nil {
+			log.Warn("decode packet err:" + err.Error())
+			continue
+		}
+		if packet.Flag == pkt.Flag_Response {
+			key, _ := requestKey(&packet.Header)
+			c.breakers.Get(cli.ServiceID()).End(key, errno.FromHeader(&packet.Header))
+		}
+		if err = c.dispatch(packet); err != nil {
+			log.Warn(err.Error())
+		}
+	}
+}
+
+// dispatch 按Flag把消息包路由到本网关的channel
+func (c *Container) dispatch(packet *pkt.LogicPkt) error {
+	switch packet.Flag {
+	case pkt.Flag_Response, pkt.Flag_Push:
+	default:
+		return fmt.Errorf("unexpected flag %s of %s", packet.Flag, packet.Command)
+	}
+	if _, ok := packet.GetMeta(pkt.MetaDestServer); !ok {
+		packet.AddStringMeta(pkt.MetaDestServer, c.Srv.ServiceID())
+	}
+	// 未指定目标channel时发给Header.ChannelId
+	if len(packet.MetaValues(pkt.MetaDestChannels)) == 0 {
+		if packet.ChannelId == "" {
+			return fmt.Errorf("no dest channel of %s", packet.Command)
+		}
+		packet.AddStringMeta(pkt.MetaDestChannels, packet.ChannelId)
+	}
+	missing, err := c.pushMessage(packet)
+	if len(missing) > 0 {
+		log.Info(fmt.Sprintf("push %s: channels %v are not present", packet.Command, missing))
+	}
+	return err
+}
+
+// reconnect 服务仍然注册在Naming中时，按退避间隔重新建立连接
+func (c *Container) reconnect(clients IClientMap, service gim.ServiceRegistration) {
+	delay := c.reconnectDelay
+	for i := 0; i < reconnectTimes; i++ {
+		time.Sleep(delay)
+		if atomic.LoadUint32(&c.state) != stateStarted {
+			return
+		}
+		// 已经被订阅回调重新连接
+		if _, ok := clients.Get(service.ServiceID()); ok {
+			return
+		}
+		if !c.registered(service) {
+			log.Info(fmt.Sprintf("service %s is offline, stop reconnecting", service.ServiceID()))
+			return
+		}
+		_, err := c.buildClient(clients, service)
+		if err == nil {
+			log.Info(fmt.Sprintf("reconnected to %s", service.ServiceID()))
+			return
+		}
+		log.Warn(fmt.Sprintf("reconnect to %s err:%s", service.ServiceID(), err.Error()))
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+func (c *Container) registered(service gim.ServiceRegistration) bool {
+	services, err := c.Naming.Find(service.ServiceName())
+	if err != nil {
+		return false
+	}
+	for _, s := range services {
+		if s.ServiceID() == service.ServiceID() {
+			return true
+		}
+	}
+	return false
+}
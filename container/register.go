@@ -0,0 +1,96 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kkakoz/gim/naming"
+	"github.com/kkakoz/gim/pkg/gox"
+)
+
+// DefaultRegisterTTL Naming支持TTL时注册的有效期，容器每TTL/3续约一次
+const DefaultRegisterTTL = 30 * time.Second
+
+// WithRegisterTTL set the ttl of the registration, 0 to register without ttl
+func WithRegisterTTL(ttl time.Duration) OptionFunc {
+	return func(c *Container) {
+		c.registerTTL = ttl
+	}
+}
+
+// WithHealthCheck 定期检查本服务，Naming支持HealthReporter时不健康的服务不会被发现
+func WithHealthCheck(interval time.Duration, checks ...naming.Check) OptionFunc {
+	return func(c *Container) {
+		c.healthInterval = interval
+		c.healthChecks = append(c.healthChecks, checks...)
+	}
+}
+
+func (c *Container) registrable() bool {
+	return c.Srv.PublicAddress() != "" && c.Srv.PublicPort() != 0
+}
+
+// register 注册服务，Naming支持TTL时启动续约，配置了健康检查时启动检查
+func (c *Container) register() error {
+	tn, ok := c.Naming.(naming.TTLNaming)
+	if !ok || c.registerTTL <= 0 {
+		if err := c.Naming.Register(c.Srv); err != nil {
+			return err
+		}
+	} else {
+		if err := tn.RegisterWithTTL(c.Srv, c.registerTTL); err != nil {
+			return err
+		}
+		gox.Go(func() {
+			c.keepalive(tn)
+		})
+	}
+
+	if len(c.healthChecks) == 0 {
+		return nil
+	}
+	reporter, ok := c.Naming.(naming.HealthReporter)
+	if !ok {
+		log.Warn("naming does not support health reporting, health checks are ignored")
+		return nil
+	}
+	c.checker = naming.NewChecker(reporter)
+	c.checker.Add(naming.HealthCheck{
+		ServiceID: c.Srv.ServiceID(),
+		Check:     naming.AllChecks(c.healthChecks...),
+		Interval:  c.healthInterval,
+	})
+	return nil
+}
+
+// keepalive 定期续约，注册已经过期时重新注册
+func (c *Container) keepalive(tn naming.TTLNaming) {
+	id := c.Srv.ServiceID()
+	ticker := time.NewTicker(c.registerTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.quit.Done():
+			return
+		case <-ticker.C:
+		}
+		err := tn.Keepalive(id)
+		if errors.Is(err, naming.ErrNotFound) {
+			log.Info(fmt.Sprintf("registration of %s expired, register again", id))
+			err = tn.RegisterWithTTL(c.Srv, c.registerTTL)
+		}
+		if err != nil {
+			log.Warn(fmt.Sprintf("keepalive %s err:%s", id, err.Error()))
+		}
+	}
+}
+
+// deregister 停止续约和健康检查，然后注销
+func (c *Container) deregister() error {
+	c.quit.Fire()
+	if c.checker != nil {
+		c.checker.Stop()
+	}
+	return c.Naming.Deregister(c.Srv.ServiceID())
+}
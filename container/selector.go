@@ -4,12 +4,30 @@ import (
 	"github.com/kkakoz/gim"
 	"github.com/kkakoz/gim/proto/pkt"
 	"hash/crc32"
+	"strconv"
+	"sync"
+	"sync/atomic"
 )
 
+// MetaWeight 服务meta中表示权重的key，缺省为1
+const MetaWeight = "weight"
+
 type Selector interface {
 	Lookup(*pkt.Header, []gim.Service) string
 }
 
+// NamingSelector 需要感知实例上下线的Selector，容器的默认Selector实现了此接口时，
+// 容器在收到Naming的通知后调用，Lookup中的services只表示当前可用的实例
+type NamingSelector interface {
+	Selector
+	// Add 实例上线
+	Add(services ...gim.Service)
+	// Remove 实例下线
+	Remove(services ...gim.Service)
+	// Reset 服务当前的全部实例
+	Reset(serviceName string, services []gim.Service)
+}
+
 type HashSelector struct {
 }
 
@@ -24,3 +42,91 @@ func HashCode(key string) int {
 	hash32.Write([]byte(key))
 	return int(hash32.Sum32())
 }
+
+// WeightedRoundRobinSelector 平滑加权轮询，权重取自meta中的weight
+type WeightedRoundRobinSelector struct {
+	sync.Mutex
+	current map[string]int
+}
+
+func NewWeightedRoundRobinSelector() *WeightedRoundRobinSelector {
+	return &WeightedRoundRobinSelector{current: make(map[string]int)}
+}
+
+func (s *WeightedRoundRobinSelector) Lookup(_ *pkt.Header, services []gim.Service) string {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.current) > len(services) {
+		s.prune(services)
+	}
+	total := 0
+	best := ""
+	for _, service := range services {
+		id := service.ServiceID()
+		w := Weight(service)
+		s.current[id] += w
+		total += w
+		if best == "" || s.current[id] > s.current[best] {
+			best = id
+		}
+	}
+	s.current[best] -= total
+	return best
+}
+
+// prune 删除已经下线的实例
+func (s *WeightedRoundRobinSelector) prune(services []gim.Service) {
+	alive := make(map[string]struct{}, len(services))
+	for _, service := range services {
+		alive[service.ServiceID()] = struct{}{}
+	}
+	for id := range s.current {
+		if _, ok := alive[id]; !ok {
+			delete(s.current, id)
+		}
+	}
+}
+
+// Weight 返回服务的权重，未配置或格式错误时为1
+func Weight(service gim.Service) int {
+	v, ok := service.GetMeta()[MetaWeight]
+	if !ok {
+		return 1
+	}
+	w, err := strconv.Atoi(v)
+	if err != nil || w < 0 {
+		return 1
+	}
+	return w
+}
+
+// LoadReporter 能够报告未完成请求数的服务，依赖服务的客户端实现了此接口
+type LoadReporter interface {
+	InFlight() int64
+}
+
+// LeastLoadSelector 选择未完成请求最少的实例，相同时从轮转的起点开始选择，
+// 不需要响应的消息包不计入未完成的请求数，轮转避免它们都落在同一个实例上
+type LeastLoadSelector struct {
+	next uint32
+}
+
+func (s *LeastLoadSelector) Lookup(_ *pkt.Header, services []gim.Service) string {
+	if len(services) == 0 {
+		return ""
+	}
+	best := ""
+	var bestLoad int64
+	start := int(atomic.AddUint32(&s.next, 1) % uint32(len(services)))
+	for i := range services {
+		service := services[(start+i)%len(services)]
+		var load int64
+		if r, ok := service.(LoadReporter); ok {
+			load = r.InFlight()
+		}
+		if best == "" || load < bestLoad {
+			best, bestLoad = service.ServiceID(), load
+		}
+	}
+	return best
+}
@@ -0,0 +1,99 @@
+package container
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+func weighted(id, weight string) gim.Service {
+	return &naming.DefaultService{Id: id, Meta: map[string]string{MetaWeight: weight}}
+}
+
+func TestWeightedRoundRobinSelector(t *testing.T) {
+	s := NewWeightedRoundRobinSelector()
+	services := []gim.Service{weighted("a", "5"), weighted("b", "1"), weighted("c", "1")}
+	var seq []string
+	for i := 0; i < 14; i++ {
+		seq = append(seq, s.Lookup(&pkt.Header{}, services))
+	}
+	// 平滑加权轮询的固定序列，每轮7次
+	if got := strings.Join(seq, ""); got != "aabacaaaabacaa" {
+		t.Fatalf("unexpected sequence %s", got)
+	}
+}
+
+func TestWeightedRoundRobinSelector_Removed(t *testing.T) {
+	s := NewWeightedRoundRobinSelector()
+	services := []gim.Service{weighted("a", "2"), weighted("b", "1"), weighted("c", "1")}
+	for i := 0; i < 3; i++ {
+		s.Lookup(&pkt.Header{}, services)
+	}
+	for i := 0; i < 10; i++ {
+		if id := s.Lookup(&pkt.Header{}, services[1:]); id == "a" {
+			t.Fatal("selected a removed service")
+		}
+	}
+	if len(s.current) != 2 {
+		t.Fatalf("state of removed service is kept: %v", s.current)
+	}
+}
+
+type loadService struct {
+	gim.Service
+	load int64
+}
+
+func (l *loadService) InFlight() int64 {
+	return l.load
+}
+
+func TestLeastLoadSelector(t *testing.T) {
+	a := &loadService{Service: &naming.DefaultService{Id: "a"}, load: 3}
+	b := &loadService{Service: &naming.DefaultService{Id: "b"}, load: 1}
+	c := &loadService{Service: &naming.DefaultService{Id: "c"}, load: 2}
+	s := &LeastLoadSelector{}
+	if id := s.Lookup(&pkt.Header{}, []gim.Service{a, b, c}); id != "b" {
+		t.Fatalf("expected b, got %s", id)
+	}
+	b.load = 5
+	if id := s.Lookup(&pkt.Header{}, []gim.Service{a, b, c}); id != "c" {
+		t.Fatalf("expected c, got %s", id)
+	}
+
+	// 负载相同时轮流选择
+	a.load, b.load, c.load = 0, 0, 0
+	count := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		count[s.Lookup(&pkt.Header{}, []gim.Service{a, b, c})]++
+	}
+	if count["a"] != 10 || count["b"] != 10 || count["c"] != 10 {
+		t.Fatalf("ties are not spread: %v", count)
+	}
+}
+
+// TestDepClientInFlight 收到响应或超时的请求不再计入未完成的请求数
+func TestDepClientInFlight(t *testing.T) {
+	b, clock := newTestBreaker(WithBreakerRequestTimeout(time.Second))
+	d := newDepClient(nil, nil, b)
+	b.Begin("u1/talk/1")
+	b.Begin("u1/talk/2")
+	if d.InFlight() != 2 {
+		t.Fatalf("in-flight counter is %d, want 2", d.InFlight())
+	}
+	b.End("u1/talk/1", nil)
+	if d.InFlight() != 1 {
+		t.Fatalf("in-flight counter is %d after a response, want 1", d.InFlight())
+	}
+	clock.Add(time.Second)
+	if d.InFlight() != 0 {
+		t.Fatalf("in-flight counter is %d after the timeout, want 0", d.InFlight())
+	}
+	if newDepClient(nil, nil, nil).InFlight() != 0 {
+		t.Fatal("client without a breaker has requests in flight")
+	}
+}
@@ -0,0 +1,164 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/kkakoz/gim/pkg/logger"
+)
+
+const (
+	DefaultShutdownTimeout = 10 * time.Second
+	DefaultDeregisterWait  = time.Second
+)
+
+// 进程退出码
+const (
+	ExitOK           = 0
+	ExitError        = 1
+	ExitDrainTimeout = 2
+)
+
+// ShutdownHook 关闭过程中执行的钩子
+type ShutdownHook func(ctx context.Context) error
+
+// ShutdownOptions 优雅关闭的配置
+type ShutdownOptions struct {
+	// Srv.Shutdown 排空连接的超时时间，不大于0时使用DefaultShutdownTimeout
+	Timeout time.Duration
+	// 从Naming注销后等待其它服务感知的时间
+	DeregisterWait time.Duration
+	// 在停止接收连接前执行
+	BeforeHooks []ShutdownHook
+	// 在关闭依赖客户端后、刷新日志前执行
+	AfterHooks []ShutdownHook
+}
+
+func NewShutdownOptions() *ShutdownOptions {
+	return &ShutdownOptions{
+		Timeout:        DefaultShutdownTimeout,
+		DeregisterWait: DefaultDeregisterWait,
+	}
+}
+
+type ShutdownOptionsFunc func(options *ShutdownOptions)
+
+// WithShutdownTimeout duration不大于0时不修改
+func WithShutdownTimeout(duration time.Duration) ShutdownOptionsFunc {
+	return func(options *ShutdownOptions) {
+		if duration > 0 {
+			options.Timeout = duration
+		}
+	}
+}
+
+func WithDeregisterWait(duration time.Duration) ShutdownOptionsFunc {
+	return func(options *ShutdownOptions) {
+		options.DeregisterWait = duration
+	}
+}
+
+func WithBeforeShutdown(hooks ...ShutdownHook) ShutdownOptionsFunc {
+	return func(options *ShutdownOptions) {
+		options.BeforeHooks = append(options.BeforeHooks, hooks...)
+	}
+}
+
+func WithAfterShutdown(hooks ...ShutdownHook) ShutdownOptionsFunc {
+	return func(options *ShutdownOptions) {
+		options.AfterHooks = append(options.AfterHooks, hooks...)
+	}
+}
+
+func (o *ShutdownOptions) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return DefaultShutdownTimeout
+}
+
+// SetShutdownOptions set options of the shutdown sequence
+func (c *Container) SetShutdownOptions(opts ...ShutdownOptionsFunc) {
+	for _, opt := range opts {
+		opt(c.shutdownOpts)
+	}
+}
+
+// ExitCode 根据Start的返回值得到进程退出码，排空连接超时返回ExitDrainTimeout
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, context.DeadlineExceeded):
+		return ExitDrainTimeout
+	default:
+		return ExitError
+	}
+}
+
+// shutdown 依次注销服务、停止接收连接并排空、关闭依赖的客户端、刷新日志
+func (c *Container) shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapUint32(&c.state, stateStarted, stateClosed) {
+		return errors.New("container is not started")
+	}
+	opts := c.shutdownOpts
+	var result error
+	record := func(step string, err error) {
+		if err == nil {
+			return
+		}
+		log.Error(fmt.Sprintf("shutdown %s err:%s", step, err.Error()))
+		if result == nil {
+			result = fmt.Errorf("%s: %w", step, err)
+		}
+	}
+
+	// 1. 从Naming注销，停止订阅依赖的服务
+	if c.registrable() {
+		if err := c.deregister(); err != nil {
+			log.Warn("deregister err:" + err.Error())
+		}
+		// ctx结束时不再等待，把剩余的时间留给排空连接
+		if opts.DeregisterWait > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(opts.DeregisterWait):
+			}
+		}
+	}
+	for dep := range c.deps {
+		if err := c.Naming.Unsubscribe(dep); err != nil {
+			log.Warn("unsubscribe err:" + err.Error())
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout())
+	defer cancel()
+	for _, hook := range opts.BeforeHooks {
+		record("before hook", hook(ctx))
+	}
+
+	// 2. 停止接收连接并排空已有连接
+	record("drain", c.Srv.Shutdown(ctx))
+
+	// 3. 关闭依赖服务的客户端
+	c.RLock()
+	for _, clients := range c.srvclients {
+		for _, cli := range clients.All() {
+			clients.Remove(cli.ServiceID())
+			cli.Close()
+		}
+	}
+	c.RUnlock()
+
+	for _, hook := range opts.AfterHooks {
+		record("after hook", hook(ctx))
+	}
+
+	// 4. 刷新日志
+	_ = logger.L().Sync()
+	return result
+}
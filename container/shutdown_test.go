@@ -0,0 +1,60 @@
+package container
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim/naming"
+)
+
+// shutdownServer 已注册的服务，记录Shutdown收到的ctx
+type shutdownServer struct {
+	*fakeServer
+	deadline time.Time
+	err      error
+}
+
+func (s *shutdownServer) PublicAddress() string { return "127.0.0.1" }
+func (s *shutdownServer) PublicPort() int       { return 8000 }
+
+func (s *shutdownServer) Shutdown(ctx context.Context) error {
+	s.deadline, _ = ctx.Deadline()
+	s.err = ctx.Err()
+	return nil
+}
+
+func newStartedContainer(opts ...ShutdownOptionsFunc) (*Container, *shutdownServer) {
+	srv := &shutdownServer{fakeServer: newFakeServer("chat-1")}
+	c := New(WithServer(srv), WithNaming(naming.NewMemoryNaming()), WithShutdown(opts...))
+	c.state = stateStarted
+	return c, srv
+}
+
+// TestShutdown_DeregisterWaitCanceled ctx结束时不再等待其它服务感知注销
+func TestShutdown_DeregisterWaitCanceled(t *testing.T) {
+	c, srv := newStartedContainer(WithDeregisterWait(10 * time.Second))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_ = c.Stop(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Stop took %s, want it to return when ctx is done", elapsed)
+	}
+	if srv.err == nil {
+		t.Fatal("drain did not see the expired ctx")
+	}
+}
+
+func TestShutdown_ZeroTimeout(t *testing.T) {
+	c, srv := newStartedContainer(WithDeregisterWait(0), WithShutdownTimeout(0))
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if srv.err != nil {
+		t.Fatalf("drain got %v with a zero timeout", srv.err)
+	}
+	if remaining := time.Until(srv.deadline); remaining < DefaultShutdownTimeout/2 {
+		t.Fatalf("drain deadline is %s away, want about %s", remaining, DefaultShutdownTimeout)
+	}
+}
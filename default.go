@@ -1,28 +1,79 @@
 package gim
 
 import (
-	"github.com/pkg/errors"
 	"time"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+	"google.golang.org/protobuf/proto"
 )
 
 const DefaultLoginWait = 60 * time.Second
 
 const DefaultReadWait = 60 * time.Second
 
+const DefaultHeartbeat = 30 * time.Second
+
 type DefaultAcceptor struct {
+	// 为nil时使用NewNegotiator
+	Negotiator *Negotiator
 }
 
 func (d DefaultAcceptor) Accept(conn Conn, duration time.Duration) (string, error) {
-	// 1. 读取：客户端发送的鉴权数据包
+	id, _, err := d.AcceptWithMeta(conn, duration)
+	return id, err
+}
+
+// AcceptWithMeta 连接的ID为token，Account为握手包中的Account，没有时为token
+func (d DefaultAcceptor) AcceptWithMeta(conn Conn, duration time.Duration) (string, *ChannelMeta, error) {
+	if duration > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(duration))
+	}
+	// 1. 读取：客户端发送的握手包
 	frame, err := conn.ReadFrame()
 	if err != nil {
-		return "", err
+		return "", nil, err
+	}
+	// 2. 解析：旧版本客户端的数据包内容就是userId
+	req, err := DecodeHandshake(frame.GetPayload())
+	if err != nil {
+		return "", nil, err
+	}
+	negotiator := d.Negotiator
+	if negotiator == nil {
+		negotiator = NewNegotiator()
+	}
+	// 3. 协商：版本不支持时直接拒绝
+	resp := negotiator.Negotiate(req)
+	if resp.Status == pkt.Status_Success && req.Token == "" {
+		resp = &pkt.HandshakeResp{Status: pkt.Status_Unauthorized, Error: "user id is invalid"}
+	}
+	if resp.Status == pkt.Status_Success {
+		if err = CheckHandshakeMeta(req); err != nil {
+			s := errno.FromError(err)
+			resp = &pkt.HandshakeResp{Status: s.Code, Error: s.Msg}
+		}
+	}
+	// 旧版本客户端不理解握手响应，只在新协议下回复
+	if req.GetProtocolVersion() != LegacyProtocolVersion {
+		bts, _ := proto.Marshal(resp)
+		if err = conn.WriteFrame(OpBinary, bts); err != nil {
+			return "", nil, err
+		}
+	}
+	if resp.Status != pkt.Status_Success {
+		return "", nil, errno.NewStatus(resp.Status, resp.Error)
+	}
+	// 4. 鉴权：这里只是为了示例做一个fake验证，非空
+	account := req.GetAccount()
+	if account == "" {
+		account = req.Token
 	}
-	// 2. 解析：数据包内容就是userId
-	userID := string(frame.GetPayload())
-	// 3. 鉴权：这里只是为了示例做一个fake验证，非空
-	if userID == "" {
-		return "", errors.New("user id is invalid")
+	meta := &ChannelMeta{
+		Account: account,
+		Device:  req.GetDevice(),
+		Tags:    req.GetTags(),
+		Attrs:   req.GetAttrs(),
 	}
-	return userID, nil
+	return req.Token, meta, nil
 }
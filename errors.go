@@ -0,0 +1,44 @@
+package gim
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+// TransportError 对传输层错误分类并包装为errno.Status，nil返回nil
+func TransportError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var s *errno.Status
+	if errors.As(err, &s) {
+		return err
+	}
+	return errno.WrapStatus(ClassifyError(err), err)
+}
+
+// ClassifyError 对传输层错误进行统一分类，协议相关的错误由各传输层包装为errno.Status
+func ClassifyError(err error) pkt.Status {
+	var s *errno.Status
+	if errors.As(err, &s) {
+		return s.Code
+	}
+	var ne net.Error
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return pkt.Status_Timeout
+	case errors.As(err, &ne) && ne.Timeout():
+		return pkt.Status_Timeout
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return pkt.Status_ConnectionClosed
+	}
+	return pkt.Status_SystemException
+}
@@ -0,0 +1,41 @@
+package gim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want pkt.Status
+	}{
+		{io.EOF, pkt.Status_ConnectionClosed},
+		{fmt.Errorf("read: %w", io.ErrUnexpectedEOF), pkt.Status_ConnectionClosed},
+		{net.ErrClosed, pkt.Status_ConnectionClosed},
+		{&net.OpError{Op: "write", Err: syscall.EPIPE}, pkt.Status_ConnectionClosed},
+		{os.ErrDeadlineExceeded, pkt.Status_Timeout},
+		{context.DeadlineExceeded, pkt.Status_Timeout},
+		{errno.ErrFrameTooLarge, pkt.Status_FrameTooLarge},
+		{fmt.Errorf("unknown"), pkt.Status_SystemException},
+	}
+	for _, c := range cases {
+		if got := ClassifyError(c.err); got != c.want {
+			t.Errorf("ClassifyError(%v) = %s, want %s", c.err, got, c.want)
+		}
+	}
+	if TransportError(nil) != nil {
+		t.Fatal("TransportError(nil) is not nil")
+	}
+	if got := errno.Code(TransportError(io.EOF)); got != pkt.Status_ConnectionClosed {
+		t.Fatalf("Code(TransportError(EOF)) = %s", got)
+	}
+}
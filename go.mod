@@ -4,6 +4,7 @@ go 1.18
 
 require (
 	github.com/bwmarrin/snowflake v0.3.0
+	github.com/fsnotify/fsnotify v1.5.4
 	github.com/gobwas/ws v1.1.0
 	github.com/pkg/errors v0.9.1
 	github.com/satori/go.uuid v1.2.0
@@ -11,11 +12,11 @@ require (
 	github.com/spf13/viper v1.13.0
 	go.uber.org/zap v1.17.0
 	golang.org/x/exp v0.0.0-20220916125017-b168a2c6b86b
+	google.golang.org/protobuf v1.28.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 )
 
 require (
-	github.com/fsnotify/fsnotify v1.5.4 // indirect
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
@@ -0,0 +1,130 @@
+package gim
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// ProtocolVersion 当前协议版本
+	ProtocolVersion uint32 = 1
+	// LegacyProtocolVersion 旧版本客户端直接发送userId，没有版本信息
+	LegacyProtocolVersion uint32 = 0
+)
+
+const (
+	FeatureCompression   = "compression"
+	FeatureResume        = "resume"
+	FeatureCodecJson     = "codec.json"
+	FeatureCodecProtobuf = "codec.protobuf"
+)
+
+// 握手包中连接属性的上限，这些属性会被IndexedChannelMap索引
+const (
+	MaxHandshakeTags  = 16
+	MaxHandshakeAttrs = 16
+	// Account、Device、tag以及属性的key和value的最大长度
+	MaxHandshakeValue = 128
+)
+
+// HandshakeMagic 握手包前缀，用于和旧版本客户端的握手包区分
+var HandshakeMagic = []byte{0xc3, 0x11, 0xa3, 0x65}
+
+// Negotiator 握手时协商协议版本和特性
+type Negotiator struct {
+	MinVersion uint32
+	MaxVersion uint32
+	Features   []string
+}
+
+func NewNegotiator() *Negotiator {
+	return &Negotiator{
+		MinVersion: LegacyProtocolVersion,
+		MaxVersion: ProtocolVersion,
+		Features:   []string{FeatureCodecProtobuf},
+	}
+}
+
+// Negotiate 返回双方都支持的版本和特性，版本不支持时返回UnsupportedVersion
+func (n *Negotiator) Negotiate(req *pkt.HandshakeReq) *pkt.HandshakeResp {
+	version := req.GetProtocolVersion()
+	if version < n.MinVersion {
+		return &pkt.HandshakeResp{
+			Status: pkt.Status_UnsupportedVersion,
+			Error:  fmt.Sprintf("protocol version %d is lower than %d", version, n.MinVersion),
+		}
+	}
+	// 客户端版本更高时按服务端最高版本通信
+	if version > n.MaxVersion {
+		version = n.MaxVersion
+	}
+	supported := make(map[string]struct{}, len(n.Features))
+	for _, feature := range n.Features {
+		supported[feature] = struct{}{}
+	}
+	features := make([]string, 0, len(req.GetFeatures()))
+	for _, feature := range req.GetFeatures() {
+		if _, ok := supported[feature]; ok {
+			features = append(features, feature)
+		}
+	}
+	return &pkt.HandshakeResp{
+		Status:          pkt.Status_Success,
+		ProtocolVersion: version,
+		Features:        features,
+	}
+}
+
+// EncodeHandshake 客户端编码握手包
+func EncodeHandshake(req *pkt.HandshakeReq) []byte {
+	bts, _ := proto.Marshal(req)
+	return append(append(make([]byte, 0, len(HandshakeMagic)+len(bts)), HandshakeMagic...), bts...)
+}
+
+// DecodeHandshake 服务端解析握手包，没有magic前缀时按旧版本处理，payload即userId
+func DecodeHandshake(payload []byte) (*pkt.HandshakeReq, error) {
+	if !bytes.HasPrefix(payload, HandshakeMagic) {
+		return &pkt.HandshakeReq{ProtocolVersion: LegacyProtocolVersion, Token: string(payload)}, nil
+	}
+	var req pkt.HandshakeReq
+	if err := proto.Unmarshal(payload[len(HandshakeMagic):], &req); err != nil {
+		return nil, errno.WrapStatus(pkt.Status_InvalidPacketBody, err)
+	}
+	return &req, nil
+}
+
+// CheckHandshakeMeta 检查握手包中的连接属性是否超过上限，超过时返回InvalidPacketBody
+func CheckHandshakeMeta(req *pkt.HandshakeReq) error {
+	if len(req.GetTags()) > MaxHandshakeTags {
+		return errno.Statusf(pkt.Status_InvalidPacketBody, "too many tags: %d > %d", len(req.GetTags()), MaxHandshakeTags)
+	}
+	if len(req.GetAttrs()) > MaxHandshakeAttrs {
+		return errno.Statusf(pkt.Status_InvalidPacketBody, "too many attrs: %d > %d", len(req.GetAttrs()), MaxHandshakeAttrs)
+	}
+	values := append([]string{req.GetAccount(), req.GetDevice()}, req.GetTags()...)
+	for key, value := range req.GetAttrs() {
+		values = append(values, key, value)
+	}
+	for _, value := range values {
+		if len(value) > MaxHandshakeValue {
+			return errno.Statusf(pkt.Status_InvalidPacketBody, "value is longer than %d bytes", MaxHandshakeValue)
+		}
+	}
+	return nil
+}
+
+// DecodeHandshakeResp 客户端解析握手结果，被拒绝时返回errno.Status
+func DecodeHandshakeResp(payload []byte) (*pkt.HandshakeResp, error) {
+	var resp pkt.HandshakeResp
+	if err := proto.Unmarshal(payload, &resp); err != nil {
+		return nil, errno.WrapStatus(pkt.Status_InvalidPacketBody, err)
+	}
+	if resp.Status != pkt.Status_Success {
+		return &resp, errno.NewStatus(resp.Status, resp.Error)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,185 @@
+package gim
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+	"google.golang.org/protobuf/proto"
+)
+
+type testFrame struct {
+	code    OpCode
+	payload []byte
+}
+
+func (f *testFrame) SetOpCode(code OpCode)     { f.code = code }
+func (f *testFrame) GetOpCode() OpCode         { return f.code }
+func (f *testFrame) SetPayload(payload []byte) { f.payload = payload }
+func (f *testFrame) GetPayload() []byte        { return f.payload }
+
+// handshakeConn 读到固定的握手包，记录写出的帧
+type handshakeConn struct {
+	net.Conn
+	in  []byte
+	out [][]byte
+}
+
+func (c *handshakeConn) SetReadDeadline(time.Time) error { return nil }
+func (c *handshakeConn) ReadFrame() (Frame, error) {
+	return &testFrame{code: OpBinary, payload: c.in}, nil
+}
+func (c *handshakeConn) WriteFrame(_ OpCode, payload []byte) error {
+	c.out = append(c.out, payload)
+	return nil
+}
+func (c *handshakeConn) Flush() error { return nil }
+
+func TestNegotiate(t *testing.T) {
+	n := &Negotiator{MinVersion: 1, MaxVersion: 2, Features: []string{FeatureCodecProtobuf, FeatureResume}}
+
+	resp := n.Negotiate(&pkt.HandshakeReq{ProtocolVersion: 5, Features: []string{FeatureResume, FeatureCompression}})
+	if resp.Status != pkt.Status_Success || resp.ProtocolVersion != 2 {
+		t.Fatalf("newer client got %s version %d, want success version 2", resp.Status, resp.ProtocolVersion)
+	}
+	if !reflect.DeepEqual(resp.Features, []string{FeatureResume}) {
+		t.Fatalf("features = %v, want [%s]", resp.Features, FeatureResume)
+	}
+
+	resp = n.Negotiate(&pkt.HandshakeReq{ProtocolVersion: 1})
+	if resp.Status != pkt.Status_Success || resp.ProtocolVersion != 1 || len(resp.Features) != 0 {
+		t.Fatalf("client at MinVersion got %v", resp)
+	}
+
+	resp = n.Negotiate(&pkt.HandshakeReq{ProtocolVersion: LegacyProtocolVersion})
+	if resp.Status != pkt.Status_UnsupportedVersion || resp.Error == "" {
+		t.Fatalf("client below MinVersion got %v", resp)
+	}
+}
+
+func TestDecodeHandshake(t *testing.T) {
+	// 旧版本客户端的payload就是userId
+	req, err := DecodeHandshake([]byte("u1"))
+	if err != nil || req.ProtocolVersion != LegacyProtocolVersion || req.Token != "u1" {
+		t.Fatalf("legacy payload got %v %v", req, err)
+	}
+
+	req, err = DecodeHandshake(EncodeHandshake(&pkt.HandshakeReq{ProtocolVersion: ProtocolVersion, Token: "u2", Features: []string{FeatureResume}}))
+	if err != nil || req.ProtocolVersion != ProtocolVersion || req.Token != "u2" || len(req.Features) != 1 {
+		t.Fatalf("encoded handshake got %v %v", req, err)
+	}
+
+	_, err = DecodeHandshake(append(append([]byte{}, HandshakeMagic...), 0xff, 0xff))
+	if errno.Code(err) != pkt.Status_InvalidPacketBody {
+		t.Fatalf("corrupted handshake got %v", err)
+	}
+}
+
+func FuzzDecodeHandshake(f *testing.F) {
+	f.Add([]byte("u1"))
+	f.Add([]byte{})
+	f.Add(EncodeHandshake(&pkt.HandshakeReq{ProtocolVersion: ProtocolVersion, Token: "u2", Account: "u2", Device: "ios",
+		Tags: []string{"vip"}, Attrs: map[string]string{"region": "cn"}, Features: []string{FeatureResume}}))
+	f.Add(EncodeHandshake(&pkt.HandshakeReq{ProtocolVersion: ProtocolVersion, Tags: make([]string, MaxHandshakeTags+1)}))
+	f.Add(append(append([]byte{}, HandshakeMagic...), 0xff, 0xff))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		req, err := DecodeHandshake(data)
+		if err != nil {
+			if errno.Code(err) != pkt.Status_InvalidPacketBody {
+				t.Fatalf("decode error is not classified: %v", err)
+			}
+			return
+		}
+		if bytes.HasPrefix(data, HandshakeMagic) {
+			again, err := DecodeHandshake(EncodeHandshake(req))
+			if err != nil || !proto.Equal(req, again) {
+				t.Fatalf("round trip mismatch: %v != %v (%v)", req, again, err)
+			}
+		} else if req.Token != string(data) {
+			t.Fatalf("legacy token %q, want %q", req.Token, data)
+		}
+		// 接受的连接属性总是在上限内
+		id, meta, err := DefaultAcceptor{}.AcceptWithMeta(&handshakeConn{in: data}, time.Second)
+		if err != nil {
+			return
+		}
+		if id == "" || meta == nil || CheckHandshakeMeta(req) != nil {
+			t.Fatalf("accepted %q with meta %+v from %v", id, meta, req)
+		}
+	})
+}
+
+func TestDefaultAcceptor(t *testing.T) {
+	// 旧版本客户端不会收到握手响应
+	conn := &handshakeConn{in: []byte("u1")}
+	id, err := DefaultAcceptor{}.Accept(conn, time.Second)
+	if err != nil || id != "u1" || len(conn.out) != 0 {
+		t.Fatalf("legacy accept got %q %v with %d frames", id, err, len(conn.out))
+	}
+
+	conn = &handshakeConn{in: EncodeHandshake(&pkt.HandshakeReq{ProtocolVersion: ProtocolVersion, Token: "u2"})}
+	id, err = DefaultAcceptor{}.Accept(conn, time.Second)
+	if err != nil || id != "u2" || len(conn.out) != 1 {
+		t.Fatalf("accept got %q %v with %d frames", id, err, len(conn.out))
+	}
+	if resp, err := DecodeHandshakeResp(conn.out[0]); err != nil || resp.ProtocolVersion != ProtocolVersion {
+		t.Fatalf("handshake resp got %v %v", resp, err)
+	}
+
+	// 版本过低时回复UnsupportedVersion并拒绝
+	acceptor := DefaultAcceptor{Negotiator: &Negotiator{MinVersion: 2, MaxVersion: 2}}
+	conn = &handshakeConn{in: EncodeHandshake(&pkt.HandshakeReq{ProtocolVersion: 1, Token: "u3"})}
+	if _, err = acceptor.Accept(conn, time.Second); errno.Code(err) != pkt.Status_UnsupportedVersion {
+		t.Fatalf("old version got %v", err)
+	}
+	if _, err := DecodeHandshakeResp(conn.out[0]); errno.Code(err) != pkt.Status_UnsupportedVersion {
+		t.Fatalf("client got %v", err)
+	}
+}
+
+func TestDefaultAcceptor_Meta(t *testing.T) {
+	req := &pkt.HandshakeReq{
+		ProtocolVersion: ProtocolVersion,
+		Token:           "session-1",
+		Account:         "u1",
+		Device:          "ios",
+		Attrs:           map[string]string{"region": "cn"},
+	}
+	id, meta, err := DefaultAcceptor{}.AcceptWithMeta(&handshakeConn{in: EncodeHandshake(req)}, time.Second)
+	if err != nil || id != "session-1" {
+		t.Fatalf("accept got %q %v", id, err)
+	}
+	if meta.Account != "u1" || meta.Device != "ios" || meta.Attrs["region"] != "cn" {
+		t.Fatalf("unexpected meta %+v", meta)
+	}
+	// 没有Account时使用token
+	_, meta, _ = DefaultAcceptor{}.AcceptWithMeta(&handshakeConn{in: []byte("u2")}, time.Second)
+	if meta.Account != "u2" {
+		t.Fatalf("legacy account is %q, want u2", meta.Account)
+	}
+
+	// 属性超过上限时拒绝
+	attrs := make(map[string]string, MaxHandshakeAttrs+1)
+	for i := 0; i <= MaxHandshakeAttrs; i++ {
+		attrs[fmt.Sprintf("k%d", i)] = "v"
+	}
+	for name, bad := range map[string]*pkt.HandshakeReq{
+		"attrs": {ProtocolVersion: ProtocolVersion, Token: "u3", Attrs: attrs},
+		"tags":  {ProtocolVersion: ProtocolVersion, Token: "u3", Tags: make([]string, MaxHandshakeTags+1)},
+		"value": {ProtocolVersion: ProtocolVersion, Token: "u3", Device: strings.Repeat("x", MaxHandshakeValue+1)},
+	} {
+		conn := &handshakeConn{in: EncodeHandshake(bad)}
+		if _, _, err = (DefaultAcceptor{}).AcceptWithMeta(conn, time.Second); errno.Code(err) != pkt.Status_InvalidPacketBody {
+			t.Fatalf("too many %s got %v", name, err)
+		}
+		if _, err = DecodeHandshakeResp(conn.out[0]); errno.Code(err) != pkt.Status_InvalidPacketBody {
+			t.Fatalf("client got %v for too many %s", err, name)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package naming
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// DefaultCacheTTL 没有订阅的服务缓存的有效期
+const DefaultCacheTTL = 10 * time.Second
+
+// Diff 服务实例的变化，内容变化的实例同时出现在Removed和Added中
+type Diff struct {
+	Added    []gim.ServiceRegistration
+	Removed  []gim.ServiceRegistration
+	Services []gim.ServiceRegistration // 变化后的全部实例
+}
+
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// DiffNaming 订阅时回调实例变化的Naming
+type DiffNaming interface {
+	Naming
+	SubscribeDiff(serviceName string, callback func(diff Diff)) error
+}
+
+// cacheEntry 创建后不再修改，更新时替换整个entry，Find可以在锁外读取
+type cacheEntry struct {
+	tags      []string
+	services  []gim.ServiceRegistration
+	updatedAt time.Time
+}
+
+type watcher struct {
+	sync.Mutex // 保证回调按变化的顺序执行
+	services   []gim.ServiceRegistration
+	full       []func(services []gim.ServiceRegistration)
+	diffs      []func(diff Diff)
+}
+
+// CachedNaming 缓存Find结果的Naming装饰器，订阅的服务由变化事件刷新缓存，
+// 后端不可用时返回过期的缓存
+type CachedNaming struct {
+	sync.RWMutex
+	Naming
+	ttl      time.Duration
+	entries  map[string]map[string]*cacheEntry // 服务名 -> tag组合 -> 实例
+	watchers map[string]*watcher
+}
+
+var (
+	_ DiffNaming     = (*CachedNaming)(nil)
+	_ TTLNaming      = (*CachedNaming)(nil)
+	_ HealthReporter = (*CachedNaming)(nil)
+)
+
+type CacheOptionsFunc func(n *CachedNaming)
+
+func WithCacheTTL(ttl time.Duration) CacheOptionsFunc {
+	return func(n *CachedNaming) {
+		n.ttl = ttl
+	}
+}
+
+func NewCachedNaming(backend Naming, opts ...CacheOptionsFunc) *CachedNaming {
+	n := &CachedNaming{
+		Naming:   backend,
+		ttl:      DefaultCacheTTL,
+		entries:  make(map[string]map[string]*cacheEntry),
+		watchers: make(map[string]*watcher),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+// Find 订阅中的服务和未过期的缓存直接返回，后端出错时返回过期的缓存，
+// 返回的切片是缓存的拷贝
+func (n *CachedNaming) Find(serviceName string, tags ...string) ([]gim.ServiceRegistration, error) {
+	key, sorted := tagsKey(tags)
+	n.RLock()
+	entry, ok := n.entries[serviceName][key]
+	_, watched := n.watchers[serviceName]
+	n.RUnlock()
+	if ok && (watched || time.Since(entry.updatedAt) < n.ttl) {
+		return copyServices(entry.services), nil
+	}
+
+	services, err := n.Naming.Find(serviceName, tags...)
+	if err != nil {
+		if ok {
+			logger.Warn("naming backend err, serve stale services: "+err.Error(),
+				zap.String("service", serviceName), zap.Duration("age", time.Since(entry.updatedAt)))
+			return copyServices(entry.services), nil
+		}
+		return nil, err
+	}
+	n.Lock()
+	if n.entries[serviceName] == nil {
+		n.entries[serviceName] = make(map[string]*cacheEntry)
+	}
+	n.entries[serviceName][key] = &cacheEntry{tags: sorted, services: services, updatedAt: time.Now()}
+	n.Unlock()
+	return copyServices(services), nil
+}
+
+// Subscribe 回调变化后的全部实例
+func (n *CachedNaming) Subscribe(serviceName string, callback func(services []gim.ServiceRegistration)) error {
+	return n.watch(serviceName, func(w *watcher) {
+		w.full = append(w.full, callback)
+	})
+}
+
+// SubscribeDiff 回调新增和移除的实例
+func (n *CachedNaming) SubscribeDiff(serviceName string, callback func(diff Diff)) error {
+	return n.watch(serviceName, func(w *watcher) {
+		w.diffs = append(w.diffs, callback)
+	})
+}
+
+// watch 每个服务只向后端订阅一次，所有订阅者共用
+func (n *CachedNaming) watch(serviceName string, add func(w *watcher)) error {
+	n.Lock()
+	if w, ok := n.watchers[serviceName]; ok {
+		n.Unlock()
+		w.Lock()
+		add(w)
+		w.Unlock()
+		return nil
+	}
+	n.Unlock()
+
+	// 订阅前的实例作为计算变化的基准
+	w := &watcher{}
+	if services, err := n.Find(serviceName); err == nil {
+		w.services = services
+	}
+	add(w)
+
+	n.Lock()
+	if cur, ok := n.watchers[serviceName]; ok {
+		// 并发订阅了同一个服务
+		n.Unlock()
+		cur.Lock()
+		cur.full = append(cur.full, w.full...)
+		cur.diffs = append(cur.diffs, w.diffs...)
+		cur.Unlock()
+		return nil
+	}
+	n.watchers[serviceName] = w
+	n.Unlock()
+
+	err := n.Naming.Subscribe(serviceName, func(services []gim.ServiceRegistration) {
+		n.update(serviceName, w, services)
+	})
+	if err != nil {
+		n.Lock()
+		delete(n.watchers, serviceName)
+		n.Unlock()
+	}
+	return err
+}
+
+// Unsubscribe 取消所有订阅者和后端的订阅，缓存按TTL过期
+func (n *CachedNaming) Unsubscribe(serviceName string) error {
+	n.Lock()
+	_, ok := n.watchers[serviceName]
+	delete(n.watchers, serviceName)
+	n.Unlock()
+	if !ok {
+		return nil
+	}
+	return n.Naming.Unsubscribe(serviceName)
+}
+
+// update 刷新服务所有tag组合的缓存，并通知订阅者
+func (n *CachedNaming) update(serviceName string, w *watcher, services []gim.ServiceRegistration) {
+	now := time.Now()
+	n.Lock()
+	if n.watchers[serviceName] != w {
+		n.Unlock()
+		return
+	}
+	entries := n.entries[serviceName]
+	if entries == nil {
+		entries = make(map[string]*cacheEntry)
+		n.entries[serviceName] = entries
+	}
+	entries[""] = &cacheEntry{services: services, updatedAt: now}
+	for key, entry := range entries {
+		if key == "" {
+			continue
+		}
+		filtered := make([]gim.ServiceRegistration, 0, len(services))
+		for _, s := range services {
+			if HasTags(s, entry.tags...) {
+				filtered = append(filtered, s)
+			}
+		}
+		entries[key] = &cacheEntry{tags: entry.tags, services: filtered, updatedAt: now}
+	}
+	n.Unlock()
+
+	w.Lock()
+	defer w.Unlock()
+	diff := Compare(w.services, services)
+	w.services = services
+	if diff.Empty() {
+		return
+	}
+	for _, callback := range w.full {
+		callback(copyServices(services))
+	}
+	for _, callback := range w.diffs {
+		callback(diff)
+	}
+}
+
+// RegisterWithTTL 后端不支持TTL时不带TTL注册，注册不会过期
+func (n *CachedNaming) RegisterWithTTL(service gim.ServiceRegistration, ttl time.Duration) error {
+	if tn, ok := n.Naming.(TTLNaming); ok {
+		return tn.RegisterWithTTL(service, ttl)
+	}
+	return n.Naming.Register(service)
+}
+
+// Keepalive 后端不支持TTL时注册不会过期，不需要续约
+func (n *CachedNaming) Keepalive(serviceID string) error {
+	if tn, ok := n.Naming.(TTLNaming); ok {
+		return tn.Keepalive(serviceID)
+	}
+	return nil
+}
+
+// SetHealth 后端不支持时返回ErrNotSupported
+func (n *CachedNaming) SetHealth(serviceID string, healthy bool) error {
+	if hr, ok := n.Naming.(HealthReporter); ok {
+		return hr.SetHealth(serviceID, healthy)
+	}
+	return ErrNotSupported
+}
+
+// Compare 比较两次的实例，按ServiceID匹配
+func Compare(old, cur []gim.ServiceRegistration) Diff {
+	diff := Diff{Services: cur}
+	before := make(map[string]gim.ServiceRegistration, len(old))
+	for _, s := range old {
+		before[s.ServiceID()] = s
+	}
+	for _, s := range cur {
+		prev, ok := before[s.ServiceID()]
+		delete(before, s.ServiceID())
+		if ok && reflect.DeepEqual(prev, s) {
+			continue
+		}
+		if ok {
+			diff.Removed = append(diff.Removed, prev)
+		}
+		diff.Added = append(diff.Added, s)
+	}
+	for _, s := range old {
+		if _, ok := before[s.ServiceID()]; ok {
+			diff.Removed = append(diff.Removed, s)
+		}
+	}
+	return diff
+}
+
+// tagsKey 排序后的tag组合，每个tag带长度前缀，tag中包含任何字符都不会冲突
+func tagsKey(tags []string) (string, []string) {
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	var b strings.Builder
+	for _, tag := range sorted {
+		b.WriteString(strconv.Itoa(len(tag)))
+		b.WriteByte(':')
+		b.WriteString(tag)
+	}
+	return b.String(), sorted
+}
+
+func copyServices(services []gim.ServiceRegistration) []gim.ServiceRegistration {
+	return append(make([]gim.ServiceRegistration, 0, len(services)), services...)
+}
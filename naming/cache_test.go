@@ -0,0 +1,208 @@
+package naming
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim"
+)
+
+// flakyNaming Find可以失败并统计调用次数的后端，不支持TTL和健康状态
+type flakyNaming struct {
+	Naming
+	fail  int32
+	finds int32
+}
+
+func (f *flakyNaming) Find(serviceName string, tags ...string) ([]gim.ServiceRegistration, error) {
+	atomic.AddInt32(&f.finds, 1)
+	if atomic.LoadInt32(&f.fail) == 1 {
+		return nil, errors.New("connection refused")
+	}
+	return f.Naming.Find(serviceName, tags...)
+}
+
+func TestCachedNaming_Stale(t *testing.T) {
+	backend := &flakyNaming{Naming: NewMemoryNaming()}
+	_ = backend.Register(testService("chat-1", "chat"))
+	n := NewCachedNaming(backend, WithCacheTTL(20*time.Millisecond))
+
+	if got := found(n, "chat"); got != "chat-1" {
+		t.Fatalf("got %s", got)
+	}
+	// 缓存有效期内不访问后端
+	found(n, "chat")
+	if finds := atomic.LoadInt32(&backend.finds); finds != 1 {
+		t.Fatalf("backend was called %d times, want 1", finds)
+	}
+
+	// 缓存过期后后端出错，返回过期的缓存
+	_ = backend.Register(testService("chat-2", "chat"))
+	atomic.StoreInt32(&backend.fail, 1)
+	time.Sleep(30 * time.Millisecond)
+	services, err := n.Find("chat")
+	if err != nil || ids(services) != "chat-1" {
+		t.Fatalf("got %s %v, want the stale chat-1", ids(services), err)
+	}
+	// 没有缓存时返回后端的错误
+	if _, err = n.Find("login"); err == nil {
+		t.Fatal("no error without a cache")
+	}
+
+	// 后端恢复后刷新缓存
+	atomic.StoreInt32(&backend.fail, 0)
+	if got := found(n, "chat"); got != "chat-1,chat-2" {
+		t.Fatalf("got %s after the backend recovered", got)
+	}
+}
+
+func TestCachedNaming_SubscribeDiff(t *testing.T) {
+	backend := NewMemoryNaming()
+	_ = backend.Register(testService("chat-1", "chat", "v1"))
+	n := NewCachedNaming(backend)
+
+	var (
+		lock  sync.Mutex
+		diffs []Diff
+		full  recorder
+	)
+	_ = n.SubscribeDiff("chat", func(diff Diff) {
+		lock.Lock()
+		defer lock.Unlock()
+		diffs = append(diffs, diff)
+	})
+	_ = n.Subscribe("chat", full.callback)
+	if got := found(n, "chat"); got != "chat-1" {
+		t.Fatalf("got %s", got)
+	}
+	services, _ := n.Find("chat", "v1")
+	if ids(services) != "chat-1" {
+		t.Fatalf("got %s with tag v1", ids(services))
+	}
+
+	_ = backend.Register(testService("chat-2", "chat", "v1"))
+	_ = backend.Deregister("chat-1")
+	// 内容相同的重复注册不通知
+	_ = backend.Register(testService("chat-2", "chat", "v1"))
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(diffs) != 2 {
+		t.Fatalf("got %d diffs, want 2", len(diffs))
+	}
+	if ids(diffs[0].Added) != "chat-2" || len(diffs[0].Removed) != 0 {
+		t.Fatalf("first diff added %s removed %s", ids(diffs[0].Added), ids(diffs[0].Removed))
+	}
+	if ids(diffs[1].Removed) != "chat-1" || len(diffs[1].Added) != 0 {
+		t.Fatalf("second diff added %s removed %s", ids(diffs[1].Added), ids(diffs[1].Removed))
+	}
+	if got := full.get(); len(got) != 2 || got[1] != "chat-2" {
+		t.Fatalf("full subscriber got %q", got)
+	}
+	// 订阅的服务由事件刷新缓存，包括带tag的查询
+	services, _ = n.Find("chat", "v1")
+	if ids(services) != "chat-2" {
+		t.Fatalf("got %s with tag v1 after the change", ids(services))
+	}
+}
+
+// TestCachedNaming_Tags tag中包含分隔符时缓存不冲突，修改返回的切片不影响缓存
+func TestCachedNaming_Tags(t *testing.T) {
+	backend := NewMemoryNaming()
+	_ = backend.Register(testService("chat-1", "chat", "a,b"))
+	_ = backend.Register(testService("chat-2", "chat", "a", "b"))
+	n := NewCachedNaming(backend)
+	for _, subscribed := range []bool{false, true} {
+		if subscribed {
+			_ = n.Subscribe("chat", func([]gim.ServiceRegistration) {})
+		}
+		if services, _ := n.Find("chat", "a,b"); ids(services) != "chat-1" {
+			t.Fatalf("got %s with tag a,b", ids(services))
+		}
+		if services, _ := n.Find("chat", "b", "a"); ids(services) != "chat-2" {
+			t.Fatalf("got %s with tags a and b", ids(services))
+		}
+	}
+	_ = backend.Register(testService("chat-3", "chat", "a", "b"))
+	if services, _ := n.Find("chat", "a", "b"); ids(services) != "chat-2,chat-3" {
+		t.Fatalf("got %s with tags a and b after the change", ids(services))
+	}
+
+	services, _ := n.Find("chat")
+	services[0] = nil
+	if got := found(n, "chat"); got != "chat-1,chat-2,chat-3" {
+		t.Fatalf("cache was modified through the result of Find: %s", got)
+	}
+}
+
+// TestCachedNaming_Race Find与订阅事件的更新并发执行，用-race检查
+func TestCachedNaming_Race(t *testing.T) {
+	backend := NewMemoryNaming()
+	n := NewCachedNaming(backend, WithCacheTTL(time.Millisecond))
+	_ = n.Subscribe("chat", func([]gim.ServiceRegistration) {})
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				_, _ = n.Find("chat")
+				_, _ = n.Find("chat", "v1")
+			}
+		}()
+	}
+	for i := 0; i < 2000; i++ {
+		_ = backend.Register(testService("chat-1", "chat", "v1"))
+		_ = backend.Deregister("chat-1")
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestCachedNaming_Forward(t *testing.T) {
+	backend := NewMemoryNaming()
+	n := NewCachedNaming(backend)
+	if err := n.RegisterWithTTL(testService("chat-1", "chat"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Keepalive("chat-1"); err != nil {
+		t.Fatalf("keepalive is not forwarded: %v", err)
+	}
+	if err := n.SetHealth("chat-1", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := found(backend, "chat"); got != "" {
+		t.Fatalf("unhealthy service is found in the backend: %s", got)
+	}
+	waitFor(t, "ttl is not forwarded to the backend", func() bool {
+		backend.RLock()
+		defer backend.RUnlock()
+		_, ok := backend.names["chat-1"]
+		return !ok
+	})
+
+	// 后端不支持时的行为
+	plain := NewCachedNaming(&flakyNaming{Naming: NewMemoryNaming()})
+	if err := plain.RegisterWithTTL(testService("chat-1", "chat"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := plain.Keepalive("chat-1"); err != nil {
+		t.Fatalf("keepalive without ttl support got %v", err)
+	}
+	if got := found(plain, "chat"); got != "chat-1" {
+		t.Fatalf("registration without ttl support expired: %s", got)
+	}
+	if err := plain.SetHealth("chat-1", false); err != ErrNotSupported {
+		t.Fatalf("set health without support got %v", err)
+	}
+}
@@ -0,0 +1,547 @@
+package consul
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+	"github.com/kkakoz/gim/pkg/gox"
+	"github.com/kkakoz/gim/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// MetaProtocol consul没有协议字段，保存在meta中
+	MetaProtocol = "protocol"
+
+	DefaultAddress  = "http://127.0.0.1:8500"
+	DefaultTTL      = 20 * time.Second
+	DefaultWaitTime = 30 * time.Second
+	// DefaultDeregisterAfter 检查失败超过这个时间后consul自动注销服务
+	DefaultDeregisterAfter = time.Minute
+
+	retryDelay    = time.Second
+	retryMaxDelay = 30 * time.Second
+)
+
+var log = logger.WithFields(zap.String("module", "naming.consul"))
+
+type Options struct {
+	// 企业版的namespace，为空时使用默认namespace
+	Namespace string
+	Token     string
+	// TTL检查的周期，服务每TTL/2上报一次健康状态
+	TTL             time.Duration
+	DeregisterAfter time.Duration
+	// 阻塞查询的最长等待时间
+	WaitTime time.Duration
+	Client   *http.Client
+}
+
+type OptionsFunc func(options *Options)
+
+func WithNamespace(namespace string) OptionsFunc {
+	return func(options *Options) {
+		options.Namespace = namespace
+	}
+}
+
+func WithToken(token string) OptionsFunc {
+	return func(options *Options) {
+		options.Token = token
+	}
+}
+
+func WithTTL(ttl, deregisterAfter time.Duration) OptionsFunc {
+	return func(options *Options) {
+		options.TTL = ttl
+		options.DeregisterAfter = deregisterAfter
+	}
+}
+
+func WithWaitTime(wait time.Duration) OptionsFunc {
+	return func(options *Options) {
+		options.WaitTime = wait
+	}
+}
+
+func WithHTTPClient(client *http.Client) OptionsFunc {
+	return func(options *Options) {
+		options.Client = client
+	}
+}
+
+// Naming 基于consul http api的naming.Naming实现
+type Naming struct {
+	sync.Mutex
+	address    string
+	options    *Options
+	watches    map[string]*watcher
+	keepalives map[string]context.CancelFunc
+	unhealthy  map[string]struct{}
+}
+
+// watcher 一个服务的阻塞查询，同一个服务的订阅者共享
+type watcher struct {
+	sync.Mutex
+	// notifyLock 串行回调，后加入的订阅者先收到当前的实例再收到之后的变化
+	notifyLock sync.Mutex
+	cancel     context.CancelFunc
+	callbacks  []func(services []gim.ServiceRegistration)
+	services   []gim.ServiceRegistration
+	notified   bool
+}
+
+// add 添加订阅者，已经查询到实例时异步回调一次当前的实例
+func (w *watcher) add(callback func(services []gim.ServiceRegistration)) {
+	w.Lock()
+	defer w.Unlock()
+	w.callbacks = append(w.callbacks, callback)
+	if !w.notified {
+		return
+	}
+	gox.Go(func() {
+		w.notifyLock.Lock()
+		defer w.notifyLock.Unlock()
+		w.Lock()
+		services := w.services
+		w.Unlock()
+		callback(append([]gim.ServiceRegistration(nil), services...))
+	})
+}
+
+// notify 在锁外回调，回调中可以再次订阅
+func (w *watcher) notify(services []gim.ServiceRegistration) {
+	w.notifyLock.Lock()
+	defer w.notifyLock.Unlock()
+	w.Lock()
+	w.services = services
+	w.notified = true
+	callbacks := w.callbacks
+	w.Unlock()
+	for _, callback := range callbacks {
+		callback(append([]gim.ServiceRegistration(nil), services...))
+	}
+}
+
+var (
+	_ naming.Naming         = (*Naming)(nil)
+	_ naming.TTLNaming      = (*Naming)(nil)
+	_ naming.HealthReporter = (*Naming)(nil)
+)
+
+// NewNaming address为consul agent的http地址，如http://127.0.0.1:8500
+func NewNaming(address string, opts ...OptionsFunc) *Naming {
+	options := &Options{
+		TTL:             DefaultTTL,
+		DeregisterAfter: DefaultDeregisterAfter,
+		WaitTime:        DefaultWaitTime,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if address == "" {
+		address = DefaultAddress
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	if options.Client == nil {
+		// 阻塞查询最多比WaitTime多等待1/16的时间
+		options.Client = &http.Client{Timeout: options.WaitTime + options.WaitTime/16 + 5*time.Second}
+	}
+	return &Naming{
+		address:    strings.TrimRight(address, "/"),
+		options:    options,
+		watches:    make(map[string]*watcher),
+		keepalives: make(map[string]context.CancelFunc),
+		unhealthy:  make(map[string]struct{}),
+	}
+}
+
+type agentCheck struct {
+	CheckID                        string `json:"CheckID"`
+	TTL                            string `json:"TTL"`
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter,omitempty"`
+}
+
+type agentService struct {
+	ID        string            `json:"ID"`
+	Name      string            `json:"Name"`
+	Tags      []string          `json:"Tags,omitempty"`
+	Address   string            `json:"Address"`
+	Port      int               `json:"Port"`
+	Meta      map[string]string `json:"Meta,omitempty"`
+	Namespace string            `json:"Namespace,omitempty"`
+	Check     *agentCheck       `json:"Check,omitempty"`
+}
+
+type healthEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID        string            `json:"ID"`
+		Service   string            `json:"Service"`
+		Tags      []string          `json:"Tags"`
+		Address   string            `json:"Address"`
+		Port      int               `json:"Port"`
+		Meta      map[string]string `json:"Meta"`
+		Namespace string            `json:"Namespace"`
+	} `json:"Service"`
+}
+
+// Register 注册服务并附带TTL检查，之后定期上报健康状态
+func (n *Naming) Register(service gim.ServiceRegistration) error {
+	if err := n.register(service, n.options.TTL); err != nil {
+		return err
+	}
+	n.keepalive(service.ServiceID())
+	return nil
+}
+
+// RegisterWithTTL 注册服务，TTL检查由调用方通过Keepalive续约，ttl不大于0时使用Options.TTL
+func (n *Naming) RegisterWithTTL(service gim.ServiceRegistration, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = n.options.TTL
+	}
+	n.stopKeepalive(service.ServiceID())
+	return n.register(service, ttl)
+}
+
+// Keepalive 上报一次TTL检查，服务已经被consul注销时返回naming.ErrNotFound
+func (n *Naming) Keepalive(serviceID string) error {
+	err := n.pass(context.Background(), serviceID)
+	var ae *apiError
+	if errors.As(err, &ae) && ae.unknownCheck() {
+		return naming.ErrNotFound
+	}
+	return err
+}
+
+func (n *Naming) register(service gim.ServiceRegistration, ttl time.Duration) error {
+	id := service.ServiceID()
+	meta := make(map[string]string, len(service.GetMeta())+1)
+	for k, v := range service.GetMeta() {
+		meta[k] = v
+	}
+	meta[MetaProtocol] = service.GetProtocol()
+	namespace := service.GetNamespace()
+	if namespace == "" {
+		namespace = n.options.Namespace
+	}
+	reg := &agentService{
+		ID:        id,
+		Name:      service.ServiceName(),
+		Tags:      service.GetTags(),
+		Address:   service.PublicAddress(),
+		Port:      service.PublicPort(),
+		Meta:      meta,
+		Namespace: namespace,
+		Check: &agentCheck{
+			CheckID:                        checkID(id),
+			TTL:                            ttl.String(),
+			DeregisterCriticalServiceAfter: n.options.DeregisterAfter.String(),
+		},
+	}
+	if err := n.put(context.Background(), "/v1/agent/service/register", reg); err != nil {
+		return err
+	}
+	// 注册后立即上报一次，服务才会出现在健康的实例中
+	return n.pass(context.Background(), id)
+}
+
+// Deregister 停止上报健康状态并注销服务
+func (n *Naming) Deregister(serviceID string) error {
+	n.stopKeepalive(serviceID)
+	n.Lock()
+	delete(n.unhealthy, serviceID)
+	n.Unlock()
+	return n.put(context.Background(), "/v1/agent/service/deregister/"+url.PathEscape(serviceID), nil)
+}
+
+// SetHealth 把服务的TTL检查置为通过或失败，失败的服务不出现在Find的结果中
+func (n *Naming) SetHealth(serviceID string, healthy bool) error {
+	n.Lock()
+	if healthy {
+		delete(n.unhealthy, serviceID)
+	} else {
+		n.unhealthy[serviceID] = struct{}{}
+	}
+	n.Unlock()
+	return n.pass(context.Background(), serviceID)
+}
+
+// Find 返回健康检查通过的实例
+func (n *Naming) Find(serviceName string, tags ...string) ([]gim.ServiceRegistration, error) {
+	services, _, err := n.health(context.Background(), serviceName, tags, 0)
+	return services, err
+}
+
+// Subscribe 使用阻塞查询监听服务变化，实例列表变化时回调全部健康的实例，
+// 同一个服务的多个订阅者共享一个阻塞查询
+func (n *Naming) Subscribe(serviceName string, callback func(services []gim.ServiceRegistration)) error {
+	if callback == nil {
+		return errors.New("callback is nil")
+	}
+	n.Lock()
+	defer n.Unlock()
+	if w, ok := n.watches[serviceName]; ok {
+		w.add(callback)
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &watcher{cancel: cancel, callbacks: []func(services []gim.ServiceRegistration){callback}}
+	n.watches[serviceName] = w
+	gox.Go(func() {
+		n.watch(ctx, serviceName, w)
+	})
+	return nil
+}
+
+// Unsubscribe 取消服务的全部订阅
+func (n *Naming) Unsubscribe(serviceName string) error {
+	n.Lock()
+	defer n.Unlock()
+	if w, ok := n.watches[serviceName]; ok {
+		w.cancel()
+		delete(n.watches, serviceName)
+	}
+	return nil
+}
+
+// Close 停止所有订阅和健康上报，不会注销服务
+func (n *Naming) Close() error {
+	n.Lock()
+	defer n.Unlock()
+	for name, w := range n.watches {
+		w.cancel()
+		delete(n.watches, name)
+	}
+	for id, cancel := range n.keepalives {
+		cancel()
+		delete(n.keepalives, id)
+	}
+	return nil
+}
+
+func (n *Naming) watch(ctx context.Context, serviceName string, w *watcher) {
+	var (
+		index uint64
+		last  []gim.ServiceRegistration
+		first = true
+		delay = retryDelay
+	)
+	for {
+		services, next, err := n.health(ctx, serviceName, nil, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Warn(fmt.Sprintf("watch %s err:%s", serviceName, err.Error()))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay *= 2
+			if delay > retryMaxDelay {
+				delay = retryMaxDelay
+			}
+			continue
+		}
+		delay = retryDelay
+		// index变小时说明consul重置了状态，需要重新查询
+		if next < index {
+			next = 0
+		}
+		index = next
+		if !first && reflect.DeepEqual(last, services) {
+			continue
+		}
+		first = false
+		last = services
+		w.notify(services)
+	}
+}
+
+// health 查询健康的实例，index大于0时为阻塞查询
+func (n *Naming) health(ctx context.Context, serviceName string, tags []string, index uint64) ([]gim.ServiceRegistration, uint64, error) {
+	query := url.Values{}
+	query.Set("passing", "true")
+	for _, tag := range tags {
+		query.Add("tag", tag)
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", n.options.WaitTime.String())
+	}
+	resp, err := n.do(ctx, http.MethodGet, "/v1/health/service/"+url.PathEscape(serviceName), query, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	var entries []healthEntry
+	if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("decode health of %s err:%w", serviceName, err)
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	services := make([]gim.ServiceRegistration, 0, len(entries))
+	for _, entry := range entries {
+		s := entry.Service
+		address := s.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		meta := make(map[string]string, len(s.Meta))
+		for k, v := range s.Meta {
+			meta[k] = v
+		}
+		protocol := meta[MetaProtocol]
+		delete(meta, MetaProtocol)
+		services = append(services, &naming.DefaultService{
+			Id:        s.ID,
+			Name:      s.Service,
+			Address:   address,
+			Port:      s.Port,
+			Protocol:  protocol,
+			Namespace: s.Namespace,
+			Tags:      s.Tags,
+			Meta:      meta,
+		})
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ServiceID() < services[j].ServiceID()
+	})
+	return services, next, nil
+}
+
+func (n *Naming) stopKeepalive(serviceID string) {
+	n.Lock()
+	defer n.Unlock()
+	if cancel, ok := n.keepalives[serviceID]; ok {
+		cancel()
+		delete(n.keepalives, serviceID)
+	}
+}
+
+// keepalive 每TTL/2上报一次健康状态
+func (n *Naming) keepalive(serviceID string) {
+	n.Lock()
+	defer n.Unlock()
+	if cancel, ok := n.keepalives[serviceID]; ok {
+		cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n.keepalives[serviceID] = cancel
+	gox.Go(func() {
+		ticker := time.NewTicker(n.options.TTL / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := n.pass(ctx, serviceID); err != nil && ctx.Err() == nil {
+					log.Warn(fmt.Sprintf("pass ttl check of %s err:%s", serviceID, err.Error()))
+				}
+			}
+		}
+	})
+}
+
+// pass 上报TTL检查的状态，被标记为不健康时上报失败
+func (n *Naming) pass(ctx context.Context, serviceID string) error {
+	n.Lock()
+	_, unhealthy := n.unhealthy[serviceID]
+	n.Unlock()
+	status := "pass"
+	if unhealthy {
+		status = "fail"
+	}
+	return n.put(ctx, "/v1/agent/check/"+status+"/"+url.PathEscape(checkID(serviceID)), nil)
+}
+
+func (n *Naming) put(ctx context.Context, path string, body interface{}) error {
+	resp, err := n.do(ctx, http.MethodPut, path, url.Values{}, body)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+func (n *Naming) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	if n.options.Namespace != "" {
+		query.Set("ns", n.options.Namespace)
+	}
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(buf)
+	}
+	u := n.address + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if n.options.Token != "" {
+		req.Header.Set("X-Consul-Token", n.options.Token)
+	}
+	resp, err := n.options.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, &apiError{method: method, path: path, code: resp.StatusCode, status: resp.Status, msg: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// apiError consul返回的非200响应
+type apiError struct {
+	method string
+	path   string
+	code   int
+	status string
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s %s: %s %s", e.method, e.path, e.status, e.msg)
+}
+
+// unknownCheck 检查已经不存在，新版本的consul返回404，旧版本返回500
+func (e *apiError) unknownCheck() bool {
+	return e.code == http.StatusNotFound || strings.Contains(e.msg, "Unknown check") || strings.Contains(e.msg, "does not have associated TTL")
+}
+
+func checkID(serviceID string) string {
+	return "service:" + serviceID
+}
@@ -0,0 +1,335 @@
+package consul
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+)
+
+// fakeConsul 实现测试用到的consul agent和health接口
+type fakeConsul struct {
+	sync.Mutex
+	t        *testing.T
+	index    uint64
+	changed  chan struct{}
+	services map[string]agentService
+	passing  map[string]bool
+	passes   map[string]int
+}
+
+func newFakeConsul(t *testing.T) *fakeConsul {
+	return &fakeConsul{
+		t:        t,
+		index:    1,
+		changed:  make(chan struct{}),
+		services: make(map[string]agentService),
+		passing:  make(map[string]bool),
+		passes:   make(map[string]int),
+	}
+}
+
+// bump 在锁内调用，唤醒所有阻塞查询
+func (f *fakeConsul) bump() {
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Consul-Token") != "secret" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.URL.Query().Get("ns") != "im" {
+		f.t.Errorf("missing ns in %s", r.URL)
+	}
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/agent/service/register":
+		var s agentService
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if s.Check == nil || s.Check.TTL == "" {
+			f.t.Errorf("service %s registered without ttl check", s.ID)
+		}
+		f.Lock()
+		f.services[s.ID] = s
+		f.passing[s.Check.CheckID] = false
+		f.bump()
+		f.Unlock()
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")
+		f.Lock()
+		delete(f.services, id)
+		delete(f.passing, checkID(id))
+		f.bump()
+		f.Unlock()
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/agent/check/pass/"):
+		check := strings.TrimPrefix(r.URL.Path, "/v1/agent/check/pass/")
+		f.Lock()
+		f.passes[check]++
+		passing, ok := f.passing[check]
+		if !ok {
+			f.Unlock()
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("Unknown check ID " + strconv.Quote(check)))
+			return
+		}
+		if !passing {
+			f.passing[check] = true
+			f.bump()
+		}
+		f.Unlock()
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/health/service/"):
+		f.health(w, r, strings.TrimPrefix(r.URL.Path, "/v1/health/service/"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeConsul) health(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	index, _ := strconv.ParseUint(query.Get("index"), 10, 64)
+	wait, _ := time.ParseDuration(query.Get("wait"))
+
+	f.Lock()
+	if index > 0 && index == f.index {
+		changed := f.changed
+		f.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+		f.Lock()
+	}
+	defer f.Unlock()
+
+	entries := []healthEntry{}
+	for _, s := range f.services {
+		if s.Name != name || !f.passing[s.Check.CheckID] {
+			continue
+		}
+		if !hasTags(s.Tags, query["tag"]) {
+			continue
+		}
+		var entry healthEntry
+		entry.Node.Address = "10.0.0.1"
+		entry.Service.ID = s.ID
+		entry.Service.Service = s.Name
+		entry.Service.Tags = s.Tags
+		entry.Service.Address = s.Address
+		entry.Service.Port = s.Port
+		entry.Service.Meta = s.Meta
+		entry.Service.Namespace = s.Namespace
+		entries = append(entries, entry)
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+func (f *fakeConsul) passCount(serviceID string) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.passes[checkID(serviceID)]
+}
+
+func hasTags(have, want []string) bool {
+	for _, tag := range want {
+		found := false
+		for _, t := range have {
+			found = found || t == tag
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func newTestNaming(t *testing.T, opts ...OptionsFunc) (*Naming, *fakeConsul) {
+	fake := newFakeConsul(t)
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	opts = append([]OptionsFunc{WithNamespace("im"), WithToken("secret"), WithWaitTime(time.Second)}, opts...)
+	n := NewNaming(srv.URL, opts...)
+	t.Cleanup(func() { _ = n.Close() })
+	return n, fake
+}
+
+func TestRegisterFind(t *testing.T) {
+	n, _ := newTestNaming(t)
+	err := n.Register(&naming.DefaultService{
+		Id: "chat-1", Name: "chat", Address: "127.0.0.1", Port: 8001, Protocol: "tcp",
+		Tags: []string{"zone-a"}, Meta: map[string]string{"weight": "2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Register(&naming.DefaultService{Id: "chat-2", Name: "chat", Port: 8002, Protocol: "tcp"}); err != nil {
+		t.Fatal(err)
+	}
+
+	services, err := n.Find("chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("found %d services, want 2", len(services))
+	}
+	s := services[0].(*naming.DefaultService)
+	if s.Id != "chat-1" || s.Namespace != "im" || s.Protocol != "tcp" || s.Meta["weight"] != "2" || s.Tags[0] != "zone-a" {
+		t.Fatalf("unexpected service %s", s)
+	}
+	if _, ok := s.Meta[MetaProtocol]; ok {
+		t.Fatal("protocol should be removed from meta")
+	}
+	// 服务没有地址时使用节点地址
+	if services[1].PublicAddress() != "10.0.0.1" {
+		t.Fatalf("address of chat-2 is %s", services[1].PublicAddress())
+	}
+
+	services, _ = n.Find("chat", "zone-a")
+	if len(services) != 1 || services[0].ServiceID() != "chat-1" {
+		t.Fatalf("find by tag got %v", services)
+	}
+
+	if err = n.Deregister("chat-1"); err != nil {
+		t.Fatal(err)
+	}
+	services, _ = n.Find("chat")
+	if len(services) != 1 || services[0].ServiceID() != "chat-2" {
+		t.Fatalf("after deregister got %v", services)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	n, _ := newTestNaming(t)
+	updates := make(chan []gim.ServiceRegistration, 10)
+	if err := n.Subscribe("chat", func(services []gim.ServiceRegistration) {
+		updates <- services
+	}); err != nil {
+		t.Fatal(err)
+	}
+	next := func(want int) {
+		t.Helper()
+		select {
+		case services := <-updates:
+			if len(services) != want {
+				t.Fatalf("callback with %d services, want %d", len(services), want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("no callback, want %d services", want)
+		}
+	}
+	next(0)
+
+	_ = n.Register(&naming.DefaultService{Id: "chat-1", Name: "chat", Port: 8001, Protocol: "tcp"})
+	next(1)
+	_ = n.Register(&naming.DefaultService{Id: "chat-2", Name: "chat", Port: 8002, Protocol: "tcp"})
+	next(2)
+	_ = n.Deregister("chat-1")
+	next(1)
+
+	_ = n.Unsubscribe("chat")
+	_ = n.Deregister("chat-2")
+	select {
+	case services := <-updates:
+		t.Fatalf("callback after unsubscribe: %v", services)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	n, fake := newTestNaming(t, WithTTL(100*time.Millisecond, time.Second))
+	_ = n.Register(&naming.DefaultService{Id: "chat-1", Name: "chat", Port: 8001, Protocol: "tcp"})
+	time.Sleep(300 * time.Millisecond)
+	if c := fake.passCount("chat-1"); c < 3 {
+		t.Fatalf("ttl check passed %d times, want at least 3", c)
+	}
+	_ = n.Deregister("chat-1")
+	c := fake.passCount("chat-1")
+	time.Sleep(150 * time.Millisecond)
+	if fake.passCount("chat-1") != c {
+		t.Fatal("keepalive continued after deregister")
+	}
+}
+
+func TestSubscribe_Multiple(t *testing.T) {
+	n, _ := newTestNaming(t)
+	wait := func(updates chan []gim.ServiceRegistration, want int) {
+		t.Helper()
+		select {
+		case services := <-updates:
+			if len(services) != want {
+				t.Fatalf("callback with %d services, want %d", len(services), want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("no callback, want %d services", want)
+		}
+	}
+	first := make(chan []gim.ServiceRegistration, 10)
+	if err := n.Subscribe("chat", func(services []gim.ServiceRegistration) { first <- services }); err != nil {
+		t.Fatal(err)
+	}
+	wait(first, 0)
+	_ = n.Register(&naming.DefaultService{Id: "chat-1", Name: "chat", Port: 8001, Protocol: "tcp"})
+	wait(first, 1)
+
+	// 后加入的订阅者立即收到当前的实例，之后和第一个订阅者一起收到变化
+	second := make(chan []gim.ServiceRegistration, 10)
+	if err := n.Subscribe("chat", func(services []gim.ServiceRegistration) { second <- services }); err != nil {
+		t.Fatalf("second subscriber: %v", err)
+	}
+	wait(second, 1)
+	_ = n.Register(&naming.DefaultService{Id: "chat-2", Name: "chat", Port: 8002, Protocol: "tcp"})
+	wait(first, 2)
+	wait(second, 2)
+}
+
+func TestRegisterWithTTL(t *testing.T) {
+	n, fake := newTestNaming(t, WithTTL(50*time.Millisecond, time.Second))
+	if err := n.RegisterWithTTL(&naming.DefaultService{Id: "chat-1", Name: "chat", Port: 8001, Protocol: "tcp"}, 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fake.Lock()
+	ttl := fake.services["chat-1"].Check.TTL
+	fake.Unlock()
+	if ttl != "3s" {
+		t.Fatalf("check ttl is %s, want 3s", ttl)
+	}
+	// 续约由调用方负责，不会在内部上报
+	time.Sleep(150 * time.Millisecond)
+	if c := fake.passCount("chat-1"); c != 1 {
+		t.Fatalf("ttl check passed %d times, want 1", c)
+	}
+	if err := n.Keepalive("chat-1"); err != nil {
+		t.Fatal(err)
+	}
+	if c := fake.passCount("chat-1"); c != 2 {
+		t.Fatalf("ttl check passed %d times after keepalive, want 2", c)
+	}
+}
+
+func TestKeepalive_NotFound(t *testing.T) {
+	n, fake := newTestNaming(t)
+	_ = n.RegisterWithTTL(&naming.DefaultService{Id: "chat-1", Name: "chat", Port: 8001, Protocol: "tcp"}, time.Second)
+	// 模拟consul在检查过期后注销了服务
+	fake.Lock()
+	delete(fake.services, "chat-1")
+	delete(fake.passing, checkID("chat-1"))
+	fake.Unlock()
+	if err := n.Keepalive("chat-1"); err != naming.ErrNotFound {
+		t.Fatalf("keepalive got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,153 @@
+package naming
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/pkg/gox"
+	"github.com/kkakoz/gim/pkg/logger"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// FileNaming 从yaml或json文件读取服务列表，文件修改后重新加载并通知变化的服务
+//
+//	services:
+//	  - id: chat-1
+//	    name: chat
+//	    address: 127.0.0.1
+//	    port: 8001
+//	    protocol: tcp
+//	    tags: [zone-a]
+//	    meta: {weight: "2"}
+//
+// meta的key按viper的规则转换为小写。Register和Deregister只修改内存中的数据，不会写回文件。
+// 订阅者可以通过SubscribeDiff只接收变化的实例
+type FileNaming struct {
+	*MemoryNaming
+	v       *viper.Viper
+	path    string
+	lock    sync.Mutex
+	loaded  map[string]DefaultService // id -> 文件中的服务
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+type fileServices struct {
+	Services []DefaultService
+}
+
+// NewFileNaming 读取path中的服务并监听文件变化，文件格式由扩展名决定
+func NewFileNaming(path string, opts ...MemoryOptionsFunc) (*FileNaming, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("read naming file %s err:%w", path, err)
+	}
+	n := &FileNaming{
+		MemoryNaming: NewMemoryNaming(opts...),
+		v:            v,
+		path:         filepath.Clean(path),
+		loaded:       make(map[string]DefaultService),
+		done:         make(chan struct{}),
+	}
+	if err := n.reload(); err != nil {
+		return nil, err
+	}
+	// 监听所在目录，编辑器先写临时文件再重命名时也能收到事件
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err = watcher.Add(filepath.Dir(n.path)); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+	n.watcher = watcher
+	gox.Go(n.watch)
+	return n, nil
+}
+
+// Close 停止监听文件，已经加载的服务仍然可以查询
+func (n *FileNaming) Close() error {
+	err := n.watcher.Close()
+	<-n.done
+	return err
+}
+
+func (n *FileNaming) watch() {
+	defer close(n.done)
+	log := logger.WithFields(zap.String("module", "naming.file"), zap.String("file", n.path))
+	for {
+		select {
+		case event, ok := <-n.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != n.path || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if err := n.v.ReadInConfig(); err != nil {
+				log.Warn("read naming file err:" + err.Error())
+				continue
+			}
+			if err := n.reload(); err != nil {
+				log.Warn("reload naming file err:" + err.Error())
+			}
+		case err, ok := <-n.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warn("watch naming file err:" + err.Error())
+		}
+	}
+}
+
+// reload 对比文件中的服务和上一次加载的结果，只注册和注销变化的部分
+func (n *FileNaming) reload() error {
+	var file fileServices
+	if err := n.v.Unmarshal(&file); err != nil {
+		return err
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	services := make(map[string]DefaultService, len(file.Services))
+	for _, service := range file.Services {
+		if service.Id == "" || service.Name == "" {
+			logger.Warn(fmt.Sprintf("skip service without id or name: %s", &service))
+			continue
+		}
+		if service.Protocol == "" {
+			service.Protocol = "tcp"
+		}
+		services[service.Id] = service
+	}
+
+	var (
+		register   []gim.ServiceRegistration
+		deregister []string
+	)
+	for id, service := range services {
+		if old, ok := n.loaded[id]; ok && reflect.DeepEqual(old, service) {
+			continue
+		}
+		service := service
+		register = append(register, &service)
+	}
+	for id := range n.loaded {
+		if _, ok := services[id]; !ok {
+			deregister = append(deregister, id)
+		}
+	}
+	n.loaded = services
+	if len(register) > 0 || len(deregister) > 0 {
+		logger.Info(fmt.Sprintf("naming file changed: %d updated, %d removed", len(register), len(deregister)))
+		n.update(register, deregister)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package naming
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const namingFile = `services:
+  - id: chat-1
+    name: chat
+    address: 127.0.0.1
+    port: 8001
+  - id: chat-2
+    name: chat
+    address: 127.0.0.1
+    port: 8002
+`
+
+const namingFileChanged = `services:
+  - id: chat-2
+    name: chat
+    address: 127.0.0.1
+    port: 9002
+  - id: chat-3
+    name: chat
+    address: 127.0.0.1
+    port: 8003
+`
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileNaming_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "naming.yaml")
+	writeFile(t, path, namingFile)
+	n, err := NewFileNaming(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	services, _ := n.Find("chat")
+	if got := ids(services); got != "chat-1,chat-2" {
+		t.Fatalf("got %s, want chat-1,chat-2", got)
+	}
+
+	diffs := make(chan Diff, 10)
+	if err = n.SubscribeDiff("chat", func(diff Diff) { diffs <- diff }); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, namingFileChanged)
+
+	// 写文件可能触发多次事件，只检查第一次变化
+	select {
+	case diff := <-diffs:
+		// chat-2的端口变化，同时出现在Removed和Added中
+		if got := ids(diff.Removed); got != "chat-1,chat-2" {
+			t.Fatalf("removed %s, want chat-1,chat-2", got)
+		}
+		if got := ids(diff.Added); got != "chat-2,chat-3" {
+			t.Fatalf("added %s, want chat-2,chat-3", got)
+		}
+		if diff.Added[0].PublicPort() != 9002 {
+			t.Fatalf("chat-2 port is %d after reload, want 9002", diff.Added[0].PublicPort())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no diff after the file changed")
+	}
+}
+
+func TestFileNaming_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "naming.yaml")
+	writeFile(t, path, namingFile)
+	n, err := NewFileNaming(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, namingFileChanged)
+	time.Sleep(200 * time.Millisecond)
+	services, _ := n.Find("chat")
+	if got := ids(services); got != "chat-1,chat-2" {
+		t.Fatalf("reloaded after Close: %s", got)
+	}
+}
+
+func TestMemoryNaming_SubscribeDiff(t *testing.T) {
+	n := NewMemoryNaming()
+	_ = n.Register(testService("chat-1", "chat"))
+	var diffs []Diff
+	_ = n.SubscribeDiff("chat", func(diff Diff) { diffs = append(diffs, diff) })
+
+	_ = n.Register(testService("chat-2", "chat"))
+	// 内容相同的重复注册不通知
+	_ = n.Register(testService("chat-2", "chat"))
+	_ = n.SetHealth("chat-1", false)
+	if len(diffs) != 2 {
+		t.Fatalf("got %d diffs, want 2", len(diffs))
+	}
+	if got := ids(diffs[0].Added); got != "chat-2" || len(diffs[0].Removed) != 0 {
+		t.Fatalf("first diff added %s removed %s", got, ids(diffs[0].Removed))
+	}
+	if got := ids(diffs[1].Removed); got != "chat-1" || len(diffs[1].Added) != 0 {
+		t.Fatalf("second diff removed %s added %s", got, ids(diffs[1].Added))
+	}
+	if got := ids(diffs[1].Services); got != "chat-2" {
+		t.Fatalf("services after the diff are %s", got)
+	}
+}
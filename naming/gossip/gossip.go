@@ -0,0 +1,847 @@
+package gossip
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+	"github.com/kkakoz/gim/pkg/gox"
+	"github.com/kkakoz/gim/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultProbeInterval    = time.Second
+	DefaultProbeTimeout     = 300 * time.Millisecond
+	DefaultIndirectChecks   = 3
+	DefaultSuspicionTimeout = 5 * time.Second
+	DefaultGossipInterval   = 200 * time.Millisecond
+	DefaultGossipFanout     = 3
+	DefaultRetransmit       = 4
+	DefaultSyncInterval     = 30 * time.Second
+	DefaultDeadReap         = time.Minute
+
+	// 一个udp包的最大长度，成员和服务较多时需要控制数量
+	maxPacketSize = 64 * 1024
+)
+
+var log = logger.WithFields(zap.String("module", "naming.gossip"))
+
+type Options struct {
+	// 节点名称，默认使用通告地址
+	Name string
+	// 监听的udp地址
+	BindAddr string
+	// 通告给其它节点的地址，默认使用监听地址，监听未指定的地址时使用本机的私有IPv4地址
+	AdvertiseAddr string
+	// 启动时加入的种子节点
+	Seeds []string
+
+	ProbeInterval    time.Duration
+	ProbeTimeout     time.Duration
+	IndirectChecks   int
+	SuspicionTimeout time.Duration
+	GossipInterval   time.Duration
+	GossipFanout     int
+	// 每个变化的传播次数为Retransmit*log(n+1)
+	Retransmit   int
+	SyncInterval time.Duration
+	// 死亡的节点保留的时间，防止旧消息使其复活
+	DeadReap time.Duration
+}
+
+type OptionsFunc func(options *Options)
+
+func WithName(name string) OptionsFunc {
+	return func(options *Options) {
+		options.Name = name
+	}
+}
+
+func WithBindAddr(addr string) OptionsFunc {
+	return func(options *Options) {
+		options.BindAddr = addr
+	}
+}
+
+func WithAdvertiseAddr(addr string) OptionsFunc {
+	return func(options *Options) {
+		options.AdvertiseAddr = addr
+	}
+}
+
+func WithSeeds(seeds ...string) OptionsFunc {
+	return func(options *Options) {
+		options.Seeds = append(options.Seeds, seeds...)
+	}
+}
+
+// WithProbe set the failure detector timings
+func WithProbe(interval, timeout, suspicion time.Duration) OptionsFunc {
+	return func(options *Options) {
+		options.ProbeInterval = interval
+		options.ProbeTimeout = timeout
+		options.SuspicionTimeout = suspicion
+	}
+}
+
+func WithGossip(interval time.Duration, fanout int) OptionsFunc {
+	return func(options *Options) {
+		options.GossipInterval = interval
+		options.GossipFanout = fanout
+	}
+}
+
+func WithSyncInterval(interval time.Duration) OptionsFunc {
+	return func(options *Options) {
+		options.SyncInterval = interval
+	}
+}
+
+type subscription struct {
+	callbacks []func(services []gim.ServiceRegistration)
+	last      []gim.ServiceRegistration
+}
+
+// Naming 基于SWIM协议的去中心化naming.Naming实现，节点通过udp互相探测和传播成员变化，
+// 每个节点的服务随成员信息一起传播
+type Naming struct {
+	sync.Mutex
+	options    *Options
+	conn       *net.UDPConn
+	self       *Member
+	members    map[string]*Member
+	broadcasts []*broadcast
+	acks       map[uint64]func()
+	subs       map[string]*subscription
+	leases     map[string]*lease // 以TTL注册的服务
+	seq        uint64
+	probeIndex int
+	probeList  []string
+
+	changed chan struct{}
+	quit    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+var _ naming.TTLNaming = (*Naming)(nil)
+
+type lease struct {
+	ttl   time.Duration
+	timer *time.Timer
+}
+
+// NewNaming 监听udp地址并加入种子节点
+func NewNaming(opts ...OptionsFunc) (*Naming, error) {
+	options := &Options{
+		BindAddr:         "0.0.0.0:7946",
+		ProbeInterval:    DefaultProbeInterval,
+		ProbeTimeout:     DefaultProbeTimeout,
+		IndirectChecks:   DefaultIndirectChecks,
+		SuspicionTimeout: DefaultSuspicionTimeout,
+		GossipInterval:   DefaultGossipInterval,
+		GossipFanout:     DefaultGossipFanout,
+		Retransmit:       DefaultRetransmit,
+		SyncInterval:     DefaultSyncInterval,
+		DeadReap:         DefaultDeadReap,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.ProbeTimeout >= options.ProbeInterval {
+		return nil, errors.New("probe timeout must be less than probe interval")
+	}
+	addr, err := net.ResolveUDPAddr("udp", options.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	advertise, err := advertiseAddr(options.AdvertiseAddr, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	options.AdvertiseAddr = advertise
+	if options.Name == "" {
+		options.Name = options.AdvertiseAddr
+	}
+	n := &Naming{
+		options: options,
+		conn:    conn,
+		self: &Member{
+			Name:        options.Name,
+			Addr:        options.AdvertiseAddr,
+			Incarnation: uint64(time.Now().UnixNano()), // 重启后的节点覆盖之前的死亡状态
+			State:       StateAlive,
+			since:       time.Now(),
+		},
+		members: make(map[string]*Member),
+		acks:    make(map[uint64]func()),
+		subs:    make(map[string]*subscription),
+		leases:  make(map[string]*lease),
+		changed: make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+	}
+	n.run(n.readLoop)
+	n.run(n.notifyLoop)
+	n.run(func() { n.tick(options.ProbeInterval, n.probe) })
+	n.run(func() { n.tick(options.GossipInterval, n.gossip) })
+	n.run(func() { n.tick(options.SyncInterval, n.sync) })
+	n.join()
+	return n, nil
+}
+
+// advertiseAddr 通告地址必须是其它节点能够访问的地址，监听0.0.0.0这样未指定的地址时，
+// 使用本机第一个私有IPv4地址，找不到时需要通过WithAdvertiseAddr指定
+func advertiseAddr(configured string, bound *net.UDPAddr) (string, error) {
+	if configured != "" {
+		host, port, err := net.SplitHostPort(configured)
+		if err != nil {
+			return "", fmt.Errorf("invalid advertise address %s: %w", configured, err)
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) || port == "0" {
+			return "", fmt.Errorf("advertise address %s is not reachable by other members", configured)
+		}
+		return configured, nil
+	}
+	if !bound.IP.IsUnspecified() {
+		return bound.String(), nil
+	}
+	ip, err := privateIP()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(bound.Port)), nil
+}
+
+func privateIP() (net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip := ipnet.IP.To4(); ip != nil && ip.IsPrivate() {
+			return ip, nil
+		}
+	}
+	return nil, errors.New("no private IPv4 address to advertise, set one with WithAdvertiseAddr")
+}
+
+// Name 本节点的名称
+func (n *Naming) Name() string {
+	return n.self.Name
+}
+
+// Members 当前已知的成员，包含本节点
+func (n *Naming) Members() []Member {
+	n.Lock()
+	defer n.Unlock()
+	res := []Member{*n.self.clone()}
+	for _, m := range n.members {
+		res = append(res, *m.clone())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Find 返回存活和疑似故障的节点上包含所有tags的服务
+func (n *Naming) Find(serviceName string, tags ...string) ([]gim.ServiceRegistration, error) {
+	n.Lock()
+	defer n.Unlock()
+	return n.find(serviceName, tags...), nil
+}
+
+func (n *Naming) find(serviceName string, tags ...string) []gim.ServiceRegistration {
+	res := make([]gim.ServiceRegistration, 0)
+	collect := func(m *Member) {
+		if m.State == StateDead {
+			return
+		}
+		for _, s := range m.Services {
+			if s.Name == serviceName && naming.HasTags(s, tags...) {
+				res = append(res, s)
+			}
+		}
+	}
+	collect(n.self)
+	for _, m := range n.members {
+		collect(m)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ServiceID() < res[j].ServiceID()
+	})
+	return res
+}
+
+// Subscribe 成员变化导致服务实例变化时回调全部实例，同一个服务可以有多个订阅者
+func (n *Naming) Subscribe(serviceName string, callback func(services []gim.ServiceRegistration)) error {
+	if callback == nil {
+		return errors.New("callback is nil")
+	}
+	n.Lock()
+	defer n.Unlock()
+	if sub, ok := n.subs[serviceName]; ok {
+		sub.callbacks = append(sub.callbacks, callback)
+		return nil
+	}
+	n.subs[serviceName] = &subscription{callbacks: []func(services []gim.ServiceRegistration){callback}, last: n.find(serviceName)}
+	return nil
+}
+
+// Unsubscribe 取消服务的全部订阅
+func (n *Naming) Unsubscribe(serviceName string) error {
+	n.Lock()
+	defer n.Unlock()
+	delete(n.subs, serviceName)
+	return nil
+}
+
+// Register 在本节点注册服务，随成员信息传播到其它节点
+func (n *Naming) Register(service gim.ServiceRegistration) error {
+	n.Lock()
+	defer n.Unlock()
+	n.register(service)
+	n.stopLease(service.ServiceID())
+	return nil
+}
+
+// RegisterWithTTL 在本节点注册服务，ttl内没有调用Keepalive时注销，
+// 用于发现进程还在但已经不能提供服务的实例
+func (n *Naming) RegisterWithTTL(service gim.ServiceRegistration, ttl time.Duration) error {
+	n.Lock()
+	defer n.Unlock()
+	n.register(service)
+	id := service.ServiceID()
+	n.stopLease(id)
+	if ttl <= 0 {
+		return nil
+	}
+	l := &lease{ttl: ttl}
+	l.timer = time.AfterFunc(ttl, func() {
+		n.expire(id, l)
+	})
+	n.leases[id] = l
+	return nil
+}
+
+// Keepalive 续约，服务已经过期或者不是以TTL注册时返回naming.ErrNotFound
+func (n *Naming) Keepalive(serviceID string) error {
+	n.Lock()
+	defer n.Unlock()
+	l, ok := n.leases[serviceID]
+	// Stop返回false说明已经过期，正在注销
+	if !ok || !l.timer.Stop() {
+		return naming.ErrNotFound
+	}
+	l.timer.Reset(l.ttl)
+	return nil
+}
+
+func (n *Naming) expire(id string, l *lease) {
+	n.Lock()
+	defer n.Unlock()
+	// 过期前已经重新注册或注销
+	if n.leases[id] != l {
+		return
+	}
+	log.Info(fmt.Sprintf("registration of %s expired in %s", id, l.ttl))
+	delete(n.leases, id)
+	n.deregister(id)
+}
+
+// stopLease 在锁内停止服务的TTL
+func (n *Naming) stopLease(id string) {
+	if l, ok := n.leases[id]; ok {
+		l.timer.Stop()
+		delete(n.leases, id)
+	}
+}
+
+// register 在锁内注册服务
+func (n *Naming) register(service gim.ServiceRegistration) {
+	s := &naming.DefaultService{
+		Id:        service.ServiceID(),
+		Name:      service.ServiceName(),
+		Address:   service.PublicAddress(),
+		Port:      service.PublicPort(),
+		Protocol:  service.GetProtocol(),
+		Namespace: service.GetNamespace(),
+		Tags:      service.GetTags(),
+		Meta:      service.GetMeta(),
+	}
+	services := make([]*naming.DefaultService, 0, len(n.self.Services)+1)
+	for _, old := range n.self.Services {
+		if old.Id != s.Id {
+			services = append(services, old)
+		}
+	}
+	n.self.Services = append(services, s)
+	n.refute()
+}
+
+func (n *Naming) Deregister(serviceID string) error {
+	n.Lock()
+	defer n.Unlock()
+	n.stopLease(serviceID)
+	if !n.deregister(serviceID) {
+		return naming.ErrNotFound
+	}
+	return nil
+}
+
+// deregister 在锁内注销服务，服务不存在时返回false
+func (n *Naming) deregister(serviceID string) bool {
+	services := make([]*naming.DefaultService, 0, len(n.self.Services))
+	for _, s := range n.self.Services {
+		if s.Id != serviceID {
+			services = append(services, s)
+		}
+	}
+	if len(services) == len(n.self.Services) {
+		return false
+	}
+	n.self.Services = services
+	n.refute()
+	return true
+}
+
+// Close 通知其它节点本节点离开，然后停止
+func (n *Naming) Close() error {
+	n.Lock()
+	left := n.self.clone()
+	left.Incarnation++
+	left.State = StateDead
+	targets := n.randomMembers(n.options.GossipFanout, "")
+	n.Unlock()
+	for _, m := range targets {
+		n.send(m.Addr, &message{Type: msgGossip, Members: []*Member{left}})
+	}
+	return n.stop()
+}
+
+// stop 直接停止，其它节点通过故障检测发现
+func (n *Naming) stop() error {
+	var err error
+	n.once.Do(func() {
+		n.Lock()
+		for id := range n.leases {
+			n.stopLease(id)
+		}
+		n.Unlock()
+		close(n.quit)
+		err = n.conn.Close()
+		n.wg.Wait()
+	})
+	return err
+}
+
+func (n *Naming) run(fn func()) {
+	n.wg.Add(1)
+	gox.Go(func() {
+		defer n.wg.Done()
+		fn()
+	})
+}
+
+func (n *Naming) tick(interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.quit:
+			return
+		case <-ticker.C:
+			fn()
+		}
+	}
+}
+
+// join 与种子节点交换全量状态
+func (n *Naming) join() {
+	for _, seed := range n.options.Seeds {
+		if seed == n.self.Addr {
+			continue
+		}
+		n.send(seed, &message{Type: msgSync, Members: n.snapshot()})
+	}
+}
+
+// sync 定期与一个随机节点交换全量状态，没有已知节点时重新加入种子节点
+func (n *Naming) sync() {
+	n.Lock()
+	targets := n.randomMembers(1, "")
+	n.Unlock()
+	if len(targets) == 0 {
+		n.join()
+		return
+	}
+	n.send(targets[0].Addr, &message{Type: msgSync, Members: n.snapshot()})
+}
+
+func (n *Naming) snapshot() []*Member {
+	n.Lock()
+	defer n.Unlock()
+	res := []*Member{n.self.clone()}
+	for _, m := range n.members {
+		res = append(res, m.clone())
+	}
+	return res
+}
+
+// probe 探测一个节点，超时后通过其它节点间接探测，仍然失败时标记为疑似故障
+func (n *Naming) probe() {
+	n.reap()
+	target, ok := n.nextProbe()
+	if !ok {
+		return
+	}
+	seq := atomic.AddUint64(&n.seq, 1)
+	acked := make(chan struct{})
+	var once sync.Once
+	n.expectAck(seq, func() { once.Do(func() { close(acked) }) })
+	defer n.clearAck(seq)
+
+	n.send(target.Addr, &message{Type: msgPing, Seq: seq, Target: target.Name})
+	select {
+	case <-acked:
+		return
+	case <-n.quit:
+		return
+	case <-time.After(n.options.ProbeTimeout):
+	}
+
+	n.Lock()
+	helpers := n.randomMembers(n.options.IndirectChecks, target.Name)
+	n.Unlock()
+	for _, m := range helpers {
+		n.send(m.Addr, &message{Type: msgPingReq, Seq: seq, Target: target.Addr})
+	}
+	select {
+	case <-acked:
+		return
+	case <-n.quit:
+		return
+	case <-time.After(n.options.ProbeInterval - n.options.ProbeTimeout):
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	if cur, ok := n.members[target.Name]; ok && cur.State == StateAlive {
+		suspect := cur.clone()
+		suspect.State = StateSuspect
+		log.Info(fmt.Sprintf("suspect %s: no ack in %s", target.Name, n.options.ProbeInterval))
+		n.apply(suspect)
+	}
+}
+
+// reap 疑似故障超时的节点标记为死亡，清理死亡较久的节点
+func (n *Naming) reap() {
+	n.Lock()
+	defer n.Unlock()
+	now := time.Now()
+	for name, m := range n.members {
+		switch {
+		case m.State == StateSuspect && now.Sub(m.since) >= n.options.SuspicionTimeout:
+			dead := m.clone()
+			dead.State = StateDead
+			log.Info(fmt.Sprintf("%s is dead: suspected for %s", name, n.options.SuspicionTimeout))
+			n.apply(dead)
+		case m.State == StateDead && now.Sub(m.since) >= n.options.DeadReap:
+			delete(n.members, name)
+		}
+	}
+}
+
+// nextProbe 按随机顺序轮流探测非死亡的节点
+func (n *Naming) nextProbe() (*Member, bool) {
+	n.Lock()
+	defer n.Unlock()
+	for i := 0; i < 2; i++ {
+		for n.probeIndex < len(n.probeList) {
+			name := n.probeList[n.probeIndex]
+			n.probeIndex++
+			if m, ok := n.members[name]; ok && m.State != StateDead {
+				return m.clone(), true
+			}
+		}
+		n.probeList = n.probeList[:0]
+		for name := range n.members {
+			n.probeList = append(n.probeList, name)
+		}
+		rand.Shuffle(len(n.probeList), func(i, j int) {
+			n.probeList[i], n.probeList[j] = n.probeList[j], n.probeList[i]
+		})
+		n.probeIndex = 0
+	}
+	return nil, false
+}
+
+// gossip 把待传播的变化发送给随机的几个节点
+func (n *Naming) gossip() {
+	n.Lock()
+	targets := n.randomMembers(n.options.GossipFanout, "")
+	if len(targets) == 0 || len(n.broadcasts) == 0 {
+		n.Unlock()
+		return
+	}
+	updates := n.takeBroadcasts()
+	n.Unlock()
+	for _, m := range targets {
+		n.send(m.Addr, &message{Type: msgGossip, Members: updates})
+	}
+}
+
+func (n *Naming) readLoop() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		size, from, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-n.quit:
+				return
+			default:
+			}
+			log.Warn("read udp err:" + err.Error())
+			continue
+		}
+		var msg message
+		if err = json.Unmarshal(buf[:size], &msg); err != nil {
+			log.Warn(fmt.Sprintf("decode message from %s err:%s", from, err.Error()))
+			continue
+		}
+		n.handle(from.String(), &msg)
+	}
+}
+
+func (n *Naming) handle(from string, msg *message) {
+	n.merge(msg.Members)
+	switch msg.Type {
+	case msgPing:
+		// 节点重启换了名称时不回复旧名称的探测
+		if msg.Target != "" && msg.Target != n.self.Name {
+			return
+		}
+		n.reply(from, &message{Type: msgAck, Seq: msg.Seq})
+	case msgAck:
+		n.Lock()
+		fn, ok := n.acks[msg.Seq]
+		n.Unlock()
+		if ok {
+			fn()
+		}
+	case msgPingReq:
+		// 代替from探测目标，收到ack后转发给from
+		seq := atomic.AddUint64(&n.seq, 1)
+		n.expectAck(seq, func() {
+			n.clearAck(seq)
+			n.reply(from, &message{Type: msgAck, Seq: msg.Seq})
+		})
+		n.send(msg.Target, &message{Type: msgPing, Seq: seq})
+		time.AfterFunc(n.options.ProbeInterval, func() { n.clearAck(seq) })
+	case msgSync:
+		n.send(from, &message{Type: msgSyncAck, Members: n.snapshot()})
+	}
+}
+
+// reply 回复时捎带待传播的变化
+func (n *Naming) reply(addr string, msg *message) {
+	n.Lock()
+	msg.Members = n.takeBroadcasts()
+	n.Unlock()
+	n.send(addr, msg)
+}
+
+func (n *Naming) merge(members []*Member) {
+	if len(members) == 0 {
+		return
+	}
+	n.Lock()
+	defer n.Unlock()
+	for _, m := range members {
+		n.apply(m.clone())
+	}
+}
+
+// apply 在锁内合并一个成员的状态，有变化时继续传播并通知订阅者
+func (n *Naming) apply(update *Member) {
+	if update.Name == n.self.Name {
+		// 其它节点认为本节点故障时，增加incarnation反驳
+		if update.State != StateAlive && update.Incarnation >= n.self.Incarnation {
+			n.self.Incarnation = update.Incarnation
+			n.refute()
+		}
+		return
+	}
+	cur, ok := n.members[update.Name]
+	if !ok {
+		if update.State == StateDead {
+			return
+		}
+		log.Info(fmt.Sprintf("member %s joined at %s", update.Name, update.Addr))
+	} else {
+		if !overrides(update, cur) {
+			return
+		}
+		// 疑似故障和死亡的消息沿用之前的服务
+		if update.State != StateAlive {
+			update.Services = cur.Services
+		}
+		if update.State != cur.State {
+			log.Info(fmt.Sprintf("member %s %s -> %s", update.Name, cur.State, update.State))
+		}
+	}
+	update.since = time.Now()
+	n.members[update.Name] = update
+	n.queue(update)
+	n.notify()
+}
+
+// refute 在锁内增加本节点的incarnation并传播
+func (n *Naming) refute() {
+	n.self.Incarnation++
+	n.queue(n.self.clone())
+	n.notify()
+}
+
+func (n *Naming) queue(m *Member) {
+	limit := n.options.Retransmit * int(math.Ceil(math.Log10(float64(len(n.members)+2))))
+	for _, b := range n.broadcasts {
+		if b.member.Name == m.Name {
+			b.member = m.clone()
+			b.remaining = limit
+			return
+		}
+	}
+	n.broadcasts = append(n.broadcasts, &broadcast{member: m.clone(), remaining: limit})
+}
+
+// takeBroadcasts 在锁内取出待传播的变化，发送次数用完的变化被移除
+func (n *Naming) takeBroadcasts() []*Member {
+	var (
+		res  []*Member
+		keep = n.broadcasts[:0]
+	)
+	for _, b := range n.broadcasts {
+		res = append(res, b.member.clone())
+		b.remaining--
+		if b.remaining > 0 {
+			keep = append(keep, b)
+		}
+	}
+	n.broadcasts = keep
+	return res
+}
+
+// randomMembers 在锁内随机选择最多k个非死亡的节点
+func (n *Naming) randomMembers(k int, exclude string) []*Member {
+	list := make([]*Member, 0, len(n.members))
+	for name, m := range n.members {
+		if name != exclude && m.State != StateDead {
+			list = append(list, m)
+		}
+	}
+	rand.Shuffle(len(list), func(i, j int) {
+		list[i], list[j] = list[j], list[i]
+	})
+	if len(list) > k {
+		list = list[:k]
+	}
+	return list
+}
+
+func (n *Naming) expectAck(seq uint64, fn func()) {
+	n.Lock()
+	defer n.Unlock()
+	n.acks[seq] = fn
+}
+
+func (n *Naming) clearAck(seq uint64) {
+	n.Lock()
+	defer n.Unlock()
+	delete(n.acks, seq)
+}
+
+func (n *Naming) send(addr string, msg *message) {
+	msg.From = n.self.Name
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		log.Warn("encode message err:" + err.Error())
+		return
+	}
+	if len(buf) > maxPacketSize {
+		log.Warn(fmt.Sprintf("message to %s is too large: %d bytes", addr, len(buf)))
+		return
+	}
+	to, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		log.Warn(fmt.Sprintf("resolve %s err:%s", addr, err.Error()))
+		return
+	}
+	if _, err = n.conn.WriteToUDP(buf, to); err != nil {
+		select {
+		case <-n.quit:
+		default:
+			log.Debug(fmt.Sprintf("send to %s err:%s", addr, err.Error()))
+		}
+	}
+}
+
+// notify 在锁内调用，唤醒通知协程
+func (n *Naming) notify() {
+	select {
+	case n.changed <- struct{}{}:
+	default:
+	}
+}
+
+// notifyLoop 在锁外回调服务实例发生变化的订阅者
+func (n *Naming) notifyLoop() {
+	for {
+		select {
+		case <-n.quit:
+			return
+		case <-n.changed:
+		}
+		type pending struct {
+			callbacks []func([]gim.ServiceRegistration)
+			services  []gim.ServiceRegistration
+		}
+		var calls []pending
+		n.Lock()
+		for name, sub := range n.subs {
+			services := n.find(name)
+			if reflect.DeepEqual(services, sub.last) {
+				continue
+			}
+			sub.last = services
+			calls = append(calls, pending{callbacks: sub.callbacks, services: services})
+		}
+		n.Unlock()
+		for _, call := range calls {
+			for _, callback := range call.callbacks {
+				callback(append([]gim.ServiceRegistration(nil), call.services...))
+			}
+		}
+	}
+}
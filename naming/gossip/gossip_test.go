@@ -0,0 +1,188 @@
+package gossip
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+)
+
+func newTestNode(t *testing.T, seeds ...string) *Naming {
+	t.Helper()
+	n, err := NewNaming(
+		WithBindAddr("127.0.0.1:0"),
+		WithSeeds(seeds...),
+		WithProbe(100*time.Millisecond, 40*time.Millisecond, 300*time.Millisecond),
+		WithGossip(20*time.Millisecond, 3),
+		WithSyncInterval(500*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = n.stop() })
+	return n
+}
+
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
+
+func countServices(n *Naming, name string) func() int {
+	return func() int {
+		services, _ := n.Find(name)
+		return len(services)
+	}
+}
+
+func TestJoinAndRegister(t *testing.T) {
+	seed := newTestNode(t)
+	nodes := []*Naming{seed, newTestNode(t, seed.Name()), newTestNode(t, seed.Name())}
+
+	for _, n := range nodes {
+		n := n
+		waitFor(t, n.Name()+" did not see all members", func() bool {
+			return len(n.Members()) == len(nodes)
+		})
+	}
+
+	_ = nodes[1].Register(&naming.DefaultService{Id: "chat-1", Name: "chat", Protocol: "tcp", Port: 8001, Tags: []string{"zone-a"}})
+	_ = nodes[2].Register(&naming.DefaultService{Id: "chat-2", Name: "chat", Protocol: "tcp", Port: 8002})
+	for _, n := range nodes {
+		n := n
+		waitFor(t, n.Name()+" did not see all services", func() bool {
+			return countServices(n, "chat")() == 2
+		})
+	}
+	services, _ := seed.Find("chat", "zone-a")
+	if len(services) != 1 || services[0].ServiceID() != "chat-1" {
+		t.Fatalf("find by tag got %v", services)
+	}
+
+	_ = nodes[2].Deregister("chat-2")
+	waitFor(t, "deregister was not propagated", func() bool {
+		return countServices(seed, "chat")() == 1
+	})
+}
+
+func TestFailureDetection(t *testing.T) {
+	seed := newTestNode(t)
+	a := newTestNode(t, seed.Name())
+	b := newTestNode(t, seed.Name())
+	_ = b.Register(&naming.DefaultService{Id: "chat-b", Name: "chat", Protocol: "tcp", Port: 8002})
+	waitFor(t, "a did not see b's service", func() bool {
+		return countServices(a, "chat")() == 1
+	})
+
+	updates := make(chan []gim.ServiceRegistration, 10)
+	if err := a.Subscribe("chat", func(services []gim.ServiceRegistration) {
+		updates <- services
+	}); err != nil {
+		t.Fatal(err)
+	}
+	second := make(chan []gim.ServiceRegistration, 10)
+	if err := a.Subscribe("chat", func(services []gim.ServiceRegistration) {
+		second <- services
+	}); err != nil {
+		t.Fatalf("second subscriber: %v", err)
+	}
+
+	// b崩溃，没有通知其它节点
+	_ = b.stop()
+	for _, ch := range []chan []gim.ServiceRegistration{updates, second} {
+		select {
+		case services := <-ch:
+			if len(services) != 0 {
+				t.Fatalf("callback with %v after b crashed", services)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("crash of b was not detected")
+		}
+	}
+	for _, m := range a.Members() {
+		if m.Name == b.Name() && m.State != StateDead {
+			t.Fatalf("b is %s, want dead", m.State)
+		}
+	}
+}
+
+func TestLeave(t *testing.T) {
+	seed := newTestNode(t)
+	a := newTestNode(t, seed.Name())
+	_ = a.Register(&naming.DefaultService{Id: "chat-a", Name: "chat", Protocol: "tcp", Port: 8001})
+	waitFor(t, "seed did not see a's service", func() bool {
+		return countServices(seed, "chat")() == 1
+	})
+	_ = a.Close()
+	waitFor(t, "leave of a was not propagated", func() bool {
+		return countServices(seed, "chat")() == 0
+	})
+}
+
+func TestRegisterWithTTL(t *testing.T) {
+	seed := newTestNode(t)
+	node := newTestNode(t, seed.Name())
+	service := &naming.DefaultService{Id: "chat-1", Name: "chat", Protocol: "tcp", Port: 8001}
+	if err := node.RegisterWithTTL(service, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "service was not propagated", func() bool {
+		return countServices(seed, "chat")() == 1
+	})
+	// 续约期间不会过期
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if err := node.Keepalive("chat-1"); err != nil {
+			t.Fatalf("keepalive %d err:%v", i, err)
+		}
+	}
+	if countServices(node, "chat")() != 1 {
+		t.Fatal("service expired while keeping alive")
+	}
+	waitFor(t, "expired service was not removed", func() bool {
+		return countServices(seed, "chat")() == 0
+	})
+	if err := node.Keepalive("chat-1"); err != naming.ErrNotFound {
+		t.Fatalf("keepalive an expired service got %v", err)
+	}
+	// 不带TTL重新注册后不再过期
+	_ = node.RegisterWithTTL(service, 100*time.Millisecond)
+	_ = node.Register(service)
+	time.Sleep(300 * time.Millisecond)
+	if countServices(node, "chat")() != 1 {
+		t.Fatal("service registered without ttl expired")
+	}
+}
+
+func TestAdvertiseAddr(t *testing.T) {
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 7946}
+	if addr, err := advertiseAddr("", loopback); err != nil || addr != "127.0.0.1:7946" {
+		t.Fatalf("got %s %v, want the bound address", addr, err)
+	}
+	if addr, err := advertiseAddr("10.0.0.1:7000", loopback); err != nil || addr != "10.0.0.1:7000" {
+		t.Fatalf("got %s %v, want the configured address", addr, err)
+	}
+	for _, bad := range []string{"0.0.0.0:7946", "[::]:7946", ":7946", "10.0.0.1:0", "10.0.0.1"} {
+		if _, err := advertiseAddr(bad, loopback); err == nil {
+			t.Errorf("advertise address %s is accepted", bad)
+		}
+	}
+	// 监听未指定的地址时不能通告0.0.0.0
+	addr, err := advertiseAddr("", &net.UDPAddr{IP: net.IPv4zero, Port: 7946})
+	if err != nil {
+		return
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() || port != "7946" {
+		t.Fatalf("advertise %s for an unspecified bind address", addr)
+	}
+}
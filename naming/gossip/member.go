@@ -0,0 +1,87 @@
+package gossip
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kkakoz/gim/naming"
+)
+
+// State 成员状态
+type State int
+
+const (
+	StateAlive State = iota
+	StateSuspect
+	StateDead
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Member 集群中的节点，Services为节点上注册的服务
+type Member struct {
+	Name        string                   `json:"n"`
+	Addr        string                   `json:"a"`
+	Incarnation uint64                   `json:"i"`
+	State       State                    `json:"s"`
+	Services    []*naming.DefaultService `json:"v,omitempty"`
+
+	since time.Time
+}
+
+func (m *Member) clone() *Member {
+	c := *m
+	c.Services = append([]*naming.DefaultService(nil), m.Services...)
+	return &c
+}
+
+// overrides 按SWIM的规则判断update是否比当前状态更新
+func overrides(update, cur *Member) bool {
+	switch update.State {
+	case StateAlive:
+		return update.Incarnation > cur.Incarnation
+	case StateSuspect:
+		return update.Incarnation > cur.Incarnation ||
+			(update.Incarnation == cur.Incarnation && cur.State == StateAlive)
+	case StateDead:
+		return update.Incarnation >= cur.Incarnation && cur.State != StateDead
+	}
+	return false
+}
+
+type msgType int
+
+const (
+	msgPing msgType = iota
+	msgAck
+	msgPingReq
+	msgGossip
+	msgSync
+	msgSyncAck
+)
+
+// message 节点之间的udp消息，Members为捎带的成员变化或全量状态
+type message struct {
+	Type    msgType   `json:"t"`
+	Seq     uint64    `json:"q,omitempty"`
+	From    string    `json:"f"`
+	Target  string    `json:"g,omitempty"`
+	Members []*Member `json:"m,omitempty"`
+}
+
+// broadcast 待传播的成员变化，每个变化发送有限的次数
+type broadcast struct {
+	member    *Member
+	remaining int
+}
@@ -0,0 +1,204 @@
+package naming
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/pkg/gox"
+	"github.com/kkakoz/gim/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultCheckInterval = 10 * time.Second
+	DefaultCheckTimeout  = 3 * time.Second
+)
+
+// TTLNaming 支持带TTL注册的Naming，TTL内没有续约的服务被注销
+type TTLNaming interface {
+	Naming
+	RegisterWithTTL(service gim.ServiceRegistration, ttl time.Duration) error
+	// Keepalive 续约，服务已经过期时返回ErrNotFound，需要重新注册
+	Keepalive(serviceID string) error
+}
+
+// HealthReporter 可以标记服务健康状态的Naming，不健康的服务保留注册但不出现在Find的结果中
+type HealthReporter interface {
+	SetHealth(serviceID string, healthy bool) error
+}
+
+// Check 健康检查，返回nil表示健康
+type Check func(ctx context.Context) error
+
+// TCPCheck 能建立tcp连接即为健康
+func TCPCheck(address string) Check {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HTTPCheck GET url返回2xx即为健康
+func HTTPCheck(url string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("GET %s: %s", url, resp.Status)
+		}
+		return nil
+	}
+}
+
+// AllChecks 所有检查都通过才健康
+func AllChecks(checks ...Check) Check {
+	return func(ctx context.Context) error {
+		for _, check := range checks {
+			if err := check(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// HealthCheck 对一个服务定期执行的检查
+type HealthCheck struct {
+	ServiceID string
+	Check     Check
+	Interval  time.Duration
+	Timeout   time.Duration
+	// 连续失败FailureThreshold次变为不健康，连续成功SuccessThreshold次恢复
+	FailureThreshold int
+	SuccessThreshold int
+}
+
+// Checker 定期执行健康检查，状态变化时通过HealthReporter更新
+type Checker struct {
+	sync.Mutex
+	reporter HealthReporter
+	cancels  map[string]context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewChecker(reporter HealthReporter) *Checker {
+	return &Checker{
+		reporter: reporter,
+		cancels:  make(map[string]context.CancelFunc),
+	}
+}
+
+// Add 开始检查服务，同一个服务已经存在的检查会被替换
+func (c *Checker) Add(check HealthCheck) {
+	if check.Interval <= 0 {
+		check.Interval = DefaultCheckInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = DefaultCheckTimeout
+	}
+	if check.FailureThreshold <= 0 {
+		check.FailureThreshold = 1
+	}
+	if check.SuccessThreshold <= 0 {
+		check.SuccessThreshold = 1
+	}
+	c.Lock()
+	defer c.Unlock()
+	if cancel, ok := c.cancels[check.ServiceID]; ok {
+		cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancels[check.ServiceID] = cancel
+	c.wg.Add(1)
+	gox.Go(func() {
+		defer c.wg.Done()
+		c.run(ctx, check)
+	})
+}
+
+func (c *Checker) Remove(serviceID string) {
+	c.Lock()
+	defer c.Unlock()
+	if cancel, ok := c.cancels[serviceID]; ok {
+		cancel()
+		delete(c.cancels, serviceID)
+	}
+}
+
+// Stop 停止所有检查并等待退出
+func (c *Checker) Stop() {
+	c.Lock()
+	for id, cancel := range c.cancels {
+		cancel()
+		delete(c.cancels, id)
+	}
+	c.Unlock()
+	c.wg.Wait()
+}
+
+func (c *Checker) run(ctx context.Context, check HealthCheck) {
+	log := logger.WithFields(zap.String("module", "naming.checker"), zap.String("id", check.ServiceID))
+	ticker := time.NewTicker(check.Interval)
+	defer ticker.Stop()
+	var (
+		healthy   = true
+		failures  int
+		successes int
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cctx, cancel := context.WithTimeout(ctx, check.Timeout)
+		err := check.Check(cctx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			failures++
+			successes = 0
+		} else {
+			successes++
+			failures = 0
+		}
+		switch {
+		case failures >= check.FailureThreshold:
+			// 超过阈值后每次失败都上报，服务过期重新注册后健康状态会被重置
+			if rerr := c.reporter.SetHealth(check.ServiceID, false); rerr != nil {
+				log.Warn("set health err:" + rerr.Error())
+				continue
+			}
+			if healthy {
+				log.Warn("service is unhealthy: " + err.Error())
+			}
+			healthy = false
+		case !healthy && successes >= check.SuccessThreshold:
+			// 更新失败时下一次检查重试
+			if rerr := c.reporter.SetHealth(check.ServiceID, true); rerr != nil {
+				log.Warn("set health err:" + rerr.Error())
+				continue
+			}
+			log.Info("service is healthy again")
+			healthy = true
+		}
+	}
+}
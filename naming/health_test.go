@@ -0,0 +1,165 @@
+package naming
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
+
+func found(n Naming, name string) string {
+	services, _ := n.Find(name)
+	return ids(services)
+}
+
+func TestMemoryNaming_TTL(t *testing.T) {
+	n := NewMemoryNaming()
+	var chat recorder
+	_ = n.Subscribe("chat", chat.callback)
+	if err := n.RegisterWithTTL(testService("chat-1", "chat"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if got := found(n, "chat"); got != "chat-1" {
+		t.Fatalf("got %s after register", got)
+	}
+	waitFor(t, "service did not expire", func() bool {
+		return found(n, "chat") == ""
+	})
+	if got := chat.get(); len(got) != 2 || got[1] != "" {
+		t.Fatalf("subscriber got %q, want the registration and the expiry", got)
+	}
+	if err := n.Keepalive("chat-1"); err != ErrNotFound {
+		t.Fatalf("keepalive an expired service got %v", err)
+	}
+}
+
+func TestMemoryNaming_Keepalive(t *testing.T) {
+	n := NewMemoryNaming()
+	_ = n.RegisterWithTTL(testService("chat-1", "chat"), 100*time.Millisecond)
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if err := n.Keepalive("chat-1"); err != nil {
+			t.Fatalf("keepalive %d err:%v", i, err)
+		}
+	}
+	if got := found(n, "chat"); got != "chat-1" {
+		t.Fatal("service expired while keeping alive")
+	}
+	// 不带TTL注册的服务不能续约
+	_ = n.Register(testService("chat-2", "chat"))
+	if err := n.Keepalive("chat-2"); err != ErrNotFound {
+		t.Fatalf("keepalive a service without ttl got %v", err)
+	}
+	// 注销后原来的TTL不再生效
+	_ = n.Deregister("chat-1")
+	_ = n.Register(testService("chat-1", "chat"))
+	time.Sleep(150 * time.Millisecond)
+	if got := found(n, "chat"); got != "chat-1,chat-2" {
+		t.Fatalf("got %s, the old lease is still running", got)
+	}
+}
+
+// fakeReporter 记录健康状态的变化
+type fakeReporter struct {
+	sync.Mutex
+	changes []bool
+}
+
+func (r *fakeReporter) SetHealth(_ string, healthy bool) error {
+	r.Lock()
+	defer r.Unlock()
+	// Checker超过阈值后重复上报同样的状态
+	if n := len(r.changes); n == 0 || r.changes[n-1] != healthy {
+		r.changes = append(r.changes, healthy)
+	}
+	return nil
+}
+
+func (r *fakeReporter) get() []bool {
+	r.Lock()
+	defer r.Unlock()
+	return append([]bool(nil), r.changes...)
+}
+
+func TestChecker(t *testing.T) {
+	reporter := &fakeReporter{}
+	c := NewChecker(reporter)
+	defer c.Stop()
+	var failing int32 = 1
+	var calls int32
+	c.Add(HealthCheck{
+		ServiceID: "chat-1",
+		Interval:  10 * time.Millisecond,
+		Check: func(ctx context.Context) error {
+			atomic.AddInt32(&calls, 1)
+			if atomic.LoadInt32(&failing) == 1 {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+		FailureThreshold: 2,
+		SuccessThreshold: 3,
+	})
+	waitFor(t, "service did not become unhealthy", func() bool {
+		return len(reporter.get()) == 1
+	})
+	if atomic.LoadInt32(&calls) < 2 {
+		t.Fatalf("unhealthy after %d checks, want at least 2", calls)
+	}
+	atomic.StoreInt32(&failing, 0)
+	waitFor(t, "service did not recover", func() bool {
+		return len(reporter.get()) == 2
+	})
+	if got := reporter.get(); got[0] || !got[1] {
+		t.Fatalf("health changes %v, want [false true]", got)
+	}
+
+	c.Remove("chat-1")
+	time.Sleep(20 * time.Millisecond)
+	n := atomic.LoadInt32(&calls)
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&calls) != n {
+		t.Fatal("check is still running after Remove")
+	}
+}
+
+// TestChecker_MemoryNaming 不健康的服务不出现在Find的结果中
+func TestChecker_MemoryNaming(t *testing.T) {
+	n := NewMemoryNaming()
+	_ = n.Register(testService("chat-1", "chat"))
+	c := NewChecker(n)
+	c.Add(HealthCheck{
+		ServiceID: "chat-1",
+		Interval:  10 * time.Millisecond,
+		Check:     func(ctx context.Context) error { return errors.New("down") },
+	})
+	waitFor(t, "unhealthy service is still found", func() bool {
+		return found(n, "chat") == ""
+	})
+
+	// 过期后重新注册的服务是健康的，检查仍然失败时再次被标记为不健康
+	_ = n.Deregister("chat-1")
+	_ = n.Register(testService("chat-1", "chat"))
+	waitFor(t, "re-registered unhealthy service is still found", func() bool {
+		return found(n, "chat") == ""
+	})
+	c.Stop()
+	_ = n.SetHealth("chat-1", true)
+	if got := found(n, "chat"); got != "chat-1" {
+		t.Fatalf("got %s after the service recovered", got)
+	}
+}
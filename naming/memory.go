@@ -0,0 +1,317 @@
+package naming
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kkakoz/gim"
+)
+
+// MemoryNaming 进程内的Naming实现，用于测试和单机部署
+type MemoryNaming struct {
+	sync.RWMutex
+	namespace string
+	services  map[string]map[string]gim.ServiceRegistration // name -> id -> service
+	names     map[string]string                             // id -> name
+	subs      map[string][]func(services []gim.ServiceRegistration)
+	diffSubs  map[string][]func(diff Diff)
+	notified  map[string][]gim.ServiceRegistration // 上一次通知diff订阅者的实例
+	leases    map[string]*lease                    // id -> TTL
+	unhealthy map[string]struct{}                  // 不健康的服务不出现在Find的结果中
+	// 保证同一时刻只有一次通知，回调按变化的顺序执行
+	notifyLock sync.Mutex
+}
+
+type lease struct {
+	ttl   time.Duration
+	timer *time.Timer
+}
+
+var (
+	_ TTLNaming      = (*MemoryNaming)(nil)
+	_ HealthReporter = (*MemoryNaming)(nil)
+	_ DiffNaming     = (*MemoryNaming)(nil)
+)
+
+type MemoryOptionsFunc func(n *MemoryNaming)
+
+// WithNamespace Find和Subscribe只返回namespace中的服务
+func WithNamespace(namespace string) MemoryOptionsFunc {
+	return func(n *MemoryNaming) {
+		n.namespace = namespace
+	}
+}
+
+func NewMemoryNaming(opts ...MemoryOptionsFunc) *MemoryNaming {
+	n := &MemoryNaming{
+		services:  make(map[string]map[string]gim.ServiceRegistration),
+		names:     make(map[string]string),
+		subs:      make(map[string][]func(services []gim.ServiceRegistration)),
+		diffSubs:  make(map[string][]func(diff Diff)),
+		notified:  make(map[string][]gim.ServiceRegistration),
+		leases:    make(map[string]*lease),
+		unhealthy: make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+// Find 返回包含所有tags的服务实例，按ServiceID排序
+func (n *MemoryNaming) Find(serviceName string, tags ...string) ([]gim.ServiceRegistration, error) {
+	n.RLock()
+	defer n.RUnlock()
+	return n.find(serviceName, tags...), nil
+}
+
+func (n *MemoryNaming) find(serviceName string, tags ...string) []gim.ServiceRegistration {
+	res := make([]gim.ServiceRegistration, 0, len(n.services[serviceName]))
+	for _, service := range n.services[serviceName] {
+		if n.namespace != "" && service.GetNamespace() != n.namespace {
+			continue
+		}
+		if _, ok := n.unhealthy[service.ServiceID()]; ok {
+			continue
+		}
+		if !HasTags(service, tags...) {
+			continue
+		}
+		res = append(res, service)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ServiceID() < res[j].ServiceID()
+	})
+	return res
+}
+
+// Subscribe 服务实例变化时回调当前的全部实例，回调中不能再调用Register和Deregister
+func (n *MemoryNaming) Subscribe(serviceName string, callback func(services []gim.ServiceRegistration)) error {
+	if callback == nil {
+		return errors.New("callback is nil")
+	}
+	n.Lock()
+	defer n.Unlock()
+	n.subs[serviceName] = append(n.subs[serviceName], callback)
+	return nil
+}
+
+// SubscribeDiff 服务实例变化时回调新增和移除的实例，以订阅时的实例为基准
+func (n *MemoryNaming) SubscribeDiff(serviceName string, callback func(diff Diff)) error {
+	if callback == nil {
+		return errors.New("callback is nil")
+	}
+	// 与通知互斥，基准和回调列表同时生效
+	n.notifyLock.Lock()
+	defer n.notifyLock.Unlock()
+	n.Lock()
+	defer n.Unlock()
+	if _, ok := n.diffSubs[serviceName]; !ok {
+		n.notified[serviceName] = n.find(serviceName)
+	}
+	n.diffSubs[serviceName] = append(n.diffSubs[serviceName], callback)
+	return nil
+}
+
+// Unsubscribe 取消serviceName的所有订阅
+func (n *MemoryNaming) Unsubscribe(serviceName string) error {
+	n.Lock()
+	defer n.Unlock()
+	delete(n.subs, serviceName)
+	delete(n.diffSubs, serviceName)
+	delete(n.notified, serviceName)
+	return nil
+}
+
+// Register 注册服务，ServiceID已经存在时覆盖
+func (n *MemoryNaming) Register(service gim.ServiceRegistration) error {
+	if service == nil || service.ServiceID() == "" || service.ServiceName() == "" {
+		return errors.New("service id or name is empty")
+	}
+	n.update([]gim.ServiceRegistration{service}, nil)
+	return nil
+}
+
+// Deregister 注销服务，ServiceID不存在时返回ErrNotFound
+func (n *MemoryNaming) Deregister(serviceID string) error {
+	if removed := n.update(nil, []string{serviceID}); removed == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RegisterWithTTL 注册服务，ttl内没有调用Keepalive时注销
+func (n *MemoryNaming) RegisterWithTTL(service gim.ServiceRegistration, ttl time.Duration) error {
+	if err := n.Register(service); err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	id := service.ServiceID()
+	n.Lock()
+	defer n.Unlock()
+	if old, ok := n.leases[id]; ok {
+		old.timer.Stop()
+	}
+	l := &lease{ttl: ttl}
+	l.timer = time.AfterFunc(ttl, func() {
+		n.expire(id, l)
+	})
+	n.leases[id] = l
+	return nil
+}
+
+// Keepalive 续约，服务已经过期或者不是以TTL注册时返回ErrNotFound
+func (n *MemoryNaming) Keepalive(serviceID string) error {
+	n.Lock()
+	defer n.Unlock()
+	l, ok := n.leases[serviceID]
+	// Stop返回false说明已经过期，正在注销
+	if !ok || !l.timer.Stop() {
+		return ErrNotFound
+	}
+	l.timer.Reset(l.ttl)
+	return nil
+}
+
+func (n *MemoryNaming) expire(id string, l *lease) {
+	n.notifyLock.Lock()
+	defer n.notifyLock.Unlock()
+
+	n.Lock()
+	// 过期前已经续约或重新注册
+	if n.leases[id] != l {
+		n.Unlock()
+		return
+	}
+	name := n.names[id]
+	n.remove(id, name)
+	n.Unlock()
+
+	n.notify(name)
+}
+
+// SetHealth 标记服务是否健康，状态变化时通知订阅者
+func (n *MemoryNaming) SetHealth(serviceID string, healthy bool) error {
+	n.notifyLock.Lock()
+	defer n.notifyLock.Unlock()
+
+	n.Lock()
+	name, ok := n.names[serviceID]
+	if !ok {
+		n.Unlock()
+		return ErrNotFound
+	}
+	_, was := n.unhealthy[serviceID]
+	if healthy {
+		delete(n.unhealthy, serviceID)
+	} else {
+		n.unhealthy[serviceID] = struct{}{}
+	}
+	n.Unlock()
+
+	if was == healthy {
+		n.notify(name)
+	}
+	return nil
+}
+
+// update 批量注册和注销服务，每个变化的服务名只回调一次，返回注销的数量
+func (n *MemoryNaming) update(register []gim.ServiceRegistration, deregister []string) int {
+	n.notifyLock.Lock()
+	defer n.notifyLock.Unlock()
+
+	changed := make(map[string]struct{})
+	removed := 0
+	n.Lock()
+	for _, id := range deregister {
+		name, ok := n.names[id]
+		if !ok {
+			continue
+		}
+		n.remove(id, name)
+		changed[name] = struct{}{}
+		removed++
+	}
+	for _, service := range register {
+		id, name := service.ServiceID(), service.ServiceName()
+		// 同一个ServiceID换了服务名时，从原来的服务中移除
+		if old, ok := n.names[id]; ok && old != name {
+			n.remove(id, old)
+			changed[old] = struct{}{}
+		}
+		if n.services[name] == nil {
+			n.services[name] = make(map[string]gim.ServiceRegistration)
+		}
+		n.services[name][id] = service
+		n.names[id] = name
+		changed[name] = struct{}{}
+	}
+	n.Unlock()
+
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		n.notify(name)
+	}
+	return removed
+}
+
+func (n *MemoryNaming) remove(id, name string) {
+	if l, ok := n.leases[id]; ok {
+		l.timer.Stop()
+		delete(n.leases, id)
+	}
+	delete(n.unhealthy, id)
+	delete(n.names, id)
+	delete(n.services[name], id)
+	if len(n.services[name]) == 0 {
+		delete(n.services, name)
+	}
+}
+
+// notify 在锁外执行回调，回调中可以调用Find，调用者持有notifyLock
+func (n *MemoryNaming) notify(serviceName string) {
+	n.Lock()
+	callbacks := append([]func([]gim.ServiceRegistration){}, n.subs[serviceName]...)
+	diffCallbacks := append([]func(Diff){}, n.diffSubs[serviceName]...)
+	services := n.find(serviceName)
+	var diff Diff
+	if len(diffCallbacks) > 0 {
+		diff = Compare(n.notified[serviceName], services)
+		n.notified[serviceName] = services
+	}
+	n.Unlock()
+	for _, callback := range callbacks {
+		callback(services)
+	}
+	if diff.Empty() {
+		return
+	}
+	for _, callback := range diffCallbacks {
+		callback(diff)
+	}
+}
+
+// HasTags 服务是否包含所有tags
+func HasTags(service gim.ServiceRegistration, tags ...string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range service.GetTags() {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
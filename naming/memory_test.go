@@ -0,0 +1,119 @@
+package naming
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kkakoz/gim"
+)
+
+func testService(id, name string, tags ...string) *DefaultService {
+	return &DefaultService{Id: id, Name: name, Address: "127.0.0.1", Port: 8000, Protocol: "tcp", Tags: tags}
+}
+
+// ids 排序后的ServiceID
+func ids(services []gim.ServiceRegistration) string {
+	res := make([]string, 0, len(services))
+	for _, s := range services {
+		res = append(res, s.ServiceID())
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+// recorder 记录每次回调的实例
+type recorder struct {
+	sync.Mutex
+	calls []string
+}
+
+func (r *recorder) callback(services []gim.ServiceRegistration) {
+	r.Lock()
+	defer r.Unlock()
+	r.calls = append(r.calls, ids(services))
+}
+
+func (r *recorder) get() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.calls...)
+}
+
+func TestMemoryNaming_Find(t *testing.T) {
+	n := NewMemoryNaming()
+	_ = n.Register(testService("chat-2", "chat", "v1"))
+	_ = n.Register(testService("chat-1", "chat", "v1", "canary"))
+	_ = n.Register(testService("login-1", "login"))
+
+	services, _ := n.Find("chat")
+	if got := ids(services); got != "chat-1,chat-2" {
+		t.Fatalf("got %s, want chat-1,chat-2", got)
+	}
+	services, _ = n.Find("chat", "canary")
+	if got := ids(services); got != "chat-1" {
+		t.Fatalf("got %s with tag canary, want chat-1", got)
+	}
+	if err := n.Register(testService("", "chat")); err == nil {
+		t.Fatal("registered a service without id")
+	}
+	if err := n.Deregister("chat-9"); err != ErrNotFound {
+		t.Fatalf("deregister an unknown service got %v", err)
+	}
+
+	ns := NewMemoryNaming(WithNamespace("im"))
+	service := testService("chat-1", "chat")
+	service.Namespace = "im"
+	_ = ns.Register(service)
+	_ = ns.Register(testService("chat-2", "chat"))
+	services, _ = ns.Find("chat")
+	if got := ids(services); got != "chat-1" {
+		t.Fatalf("got %s in namespace im, want chat-1", got)
+	}
+}
+
+func TestMemoryNaming_Subscribe(t *testing.T) {
+	n := NewMemoryNaming()
+	var chat, login recorder
+	if err := n.Subscribe("chat", chat.callback); err != nil {
+		t.Fatal(err)
+	}
+	_ = n.Subscribe("login", login.callback)
+	if err := n.Subscribe("chat", nil); err == nil {
+		t.Fatal("subscribed with a nil callback")
+	}
+
+	_ = n.Register(testService("chat-1", "chat"))
+	_ = n.Register(testService("chat-2", "chat"))
+	_ = n.Deregister("chat-1")
+	// 同一个ServiceID换了服务名，两个服务都收到通知
+	_ = n.Register(testService("chat-2", "login"))
+	want := []string{"chat-1", "chat-1,chat-2", "chat-2", ""}
+	if got := chat.get(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("chat got %q, want %q", got, want)
+	}
+	if got := login.get(); len(got) != 1 || got[0] != "chat-2" {
+		t.Fatalf("login got %q, want [chat-2]", got)
+	}
+
+	_ = n.Unsubscribe("chat")
+	_ = n.Register(testService("chat-3", "chat"))
+	if got := chat.get(); len(got) != len(want) {
+		t.Fatalf("callback after unsubscribe: %q", got)
+	}
+}
+
+// TestMemoryNaming_SubscribeFind 回调中可以调用Find
+func TestMemoryNaming_SubscribeFind(t *testing.T) {
+	n := NewMemoryNaming()
+	found := make(chan string, 1)
+	_ = n.Subscribe("chat", func([]gim.ServiceRegistration) {
+		services, _ := n.Find("chat")
+		found <- ids(services)
+	})
+	_ = n.Register(testService("chat-1", "chat"))
+	if got := <-found; got != "chat-1" {
+		t.Fatalf("Find in callback got %s", got)
+	}
+}
@@ -6,13 +6,16 @@ import (
 )
 
 var (
-	ErrNotFound = errors.New("service no found")
+	ErrNotFound     = errors.New("service no found")
+	ErrNotSupported = errors.New("not supported by the naming backend")
 )
 
 // Naming defined methods of the naming service
 type Naming interface {
 	Find(serviceName string, tags ...string) ([]gim.ServiceRegistration, error)
+	// Subscribe 同一个服务可以有多个订阅者，服务变化时依次回调
 	Subscribe(serviceName string, callback func(services []gim.ServiceRegistration)) error
+	// Unsubscribe 取消服务的全部订阅
 	Unsubscribe(serviceName string) error
 	Register(service gim.ServiceRegistration) error
 	Deregister(serviceID string) error
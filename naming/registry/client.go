@@ -0,0 +1,401 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/pkg/gox"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+const (
+	DefaultWaitTime = 30 * time.Second
+
+	retryDelay    = time.Second
+	retryMaxDelay = 30 * time.Second
+)
+
+type ClientOptions struct {
+	// Find和Subscribe只返回namespace中的服务
+	Namespace string
+	// 注册的TTL，客户端每TTL/3续约一次
+	TTL      time.Duration
+	WaitTime time.Duration
+	Client   *http.Client
+}
+
+type ClientOptionsFunc func(options *ClientOptions)
+
+func WithNamespace(namespace string) ClientOptionsFunc {
+	return func(options *ClientOptions) {
+		options.Namespace = namespace
+	}
+}
+
+func WithTTL(ttl time.Duration) ClientOptionsFunc {
+	return func(options *ClientOptions) {
+		options.TTL = ttl
+	}
+}
+
+func WithWaitTime(wait time.Duration) ClientOptionsFunc {
+	return func(options *ClientOptions) {
+		options.WaitTime = wait
+	}
+}
+
+func WithHTTPClient(client *http.Client) ClientOptionsFunc {
+	return func(options *ClientOptions) {
+		options.Client = client
+	}
+}
+
+// Naming 注册中心的naming.Naming客户端，使用长轮询监听变化
+type Naming struct {
+	sync.Mutex
+	address    string
+	options    *ClientOptions
+	watches    map[string]*watcher
+	keepalives map[string]context.CancelFunc
+}
+
+// watcher 一个服务的长轮询，同一个服务的订阅者共享
+type watcher struct {
+	sync.Mutex
+	// notifyLock 串行回调，后加入的订阅者先收到当前的实例再收到之后的变化
+	notifyLock sync.Mutex
+	cancel     context.CancelFunc
+	callbacks  []func(services []gim.ServiceRegistration)
+	services   []gim.ServiceRegistration
+	notified   bool
+}
+
+// add 添加订阅者，已经查询到实例时异步回调一次当前的实例
+func (w *watcher) add(callback func(services []gim.ServiceRegistration)) {
+	w.Lock()
+	defer w.Unlock()
+	w.callbacks = append(w.callbacks, callback)
+	if !w.notified {
+		return
+	}
+	gox.Go(func() {
+		w.notifyLock.Lock()
+		defer w.notifyLock.Unlock()
+		w.Lock()
+		services := w.services
+		w.Unlock()
+		callback(append([]gim.ServiceRegistration(nil), services...))
+	})
+}
+
+// notify 在锁外回调，回调中可以再次订阅
+func (w *watcher) notify(services []gim.ServiceRegistration) {
+	w.notifyLock.Lock()
+	defer w.notifyLock.Unlock()
+	w.Lock()
+	w.services = services
+	w.notified = true
+	callbacks := w.callbacks
+	w.Unlock()
+	for _, callback := range callbacks {
+		callback(append([]gim.ServiceRegistration(nil), services...))
+	}
+}
+
+var _ naming.TTLNaming = (*Naming)(nil)
+
+// NewNaming address为注册中心的http地址
+func NewNaming(address string, opts ...ClientOptionsFunc) *Naming {
+	options := &ClientOptions{
+		TTL:      DefaultTTL,
+		WaitTime: DefaultWaitTime,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.TTL < time.Second {
+		options.TTL = time.Second
+	}
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: options.WaitTime + 5*time.Second}
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &Naming{
+		address:    strings.TrimRight(address, "/"),
+		options:    options,
+		watches:    make(map[string]*watcher),
+		keepalives: make(map[string]context.CancelFunc),
+	}
+}
+
+// Register 以ClientOptions.TTL注册服务并定期续约，注册中心重启丢失实例时重新注册
+func (n *Naming) Register(service gim.ServiceRegistration) error {
+	ins := newInstance(service, n.options.TTL)
+	if err := n.do(context.Background(), http.MethodPut, "/v1/services", nil, ins, nil); err != nil {
+		return err
+	}
+	n.keepalive(ins)
+	return nil
+}
+
+// RegisterWithTTL 注册服务，由调用者通过Keepalive续约
+func (n *Naming) RegisterWithTTL(service gim.ServiceRegistration, ttl time.Duration) error {
+	n.stopKeepalive(service.ServiceID())
+	return n.do(context.Background(), http.MethodPut, "/v1/services", nil, newInstance(service, ttl), nil)
+}
+
+// Keepalive 续约，实例已经过期或不存在时返回naming.ErrNotFound
+func (n *Naming) Keepalive(serviceID string) error {
+	err := n.do(context.Background(), http.MethodPut, "/v1/services/"+url.PathEscape(serviceID)+"/heartbeat", nil, nil, nil)
+	if errno.Code(err) == pkt.Status_SessionNotFound {
+		return naming.ErrNotFound
+	}
+	return err
+}
+
+// newInstance ttl不足1秒时按1秒注册
+func newInstance(service gim.ServiceRegistration, ttl time.Duration) *Instance {
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return &Instance{
+		ID:        service.ServiceID(),
+		Name:      service.ServiceName(),
+		Address:   service.PublicAddress(),
+		Port:      service.PublicPort(),
+		Protocol:  service.GetProtocol(),
+		Namespace: service.GetNamespace(),
+		Tags:      service.GetTags(),
+		Meta:      service.GetMeta(),
+		TTL:       int64(ttl / time.Second),
+	}
+}
+
+func (n *Naming) Deregister(serviceID string) error {
+	n.stopKeepalive(serviceID)
+	return n.do(context.Background(), http.MethodDelete, "/v1/services/"+url.PathEscape(serviceID), nil, nil, nil)
+}
+
+func (n *Naming) Find(serviceName string, tags ...string) ([]gim.ServiceRegistration, error) {
+	res, err := n.find(context.Background(), serviceName, tags, 0)
+	if err != nil {
+		return nil, err
+	}
+	return toServices(res.Services), nil
+}
+
+// Subscribe 长轮询监听服务变化，实例列表变化时回调全部实例，
+// 同一个服务的多个订阅者共享一个长轮询
+func (n *Naming) Subscribe(serviceName string, callback func(services []gim.ServiceRegistration)) error {
+	if callback == nil {
+		return errors.New("callback is nil")
+	}
+	n.Lock()
+	defer n.Unlock()
+	if w, ok := n.watches[serviceName]; ok {
+		w.add(callback)
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &watcher{cancel: cancel, callbacks: []func(services []gim.ServiceRegistration){callback}}
+	n.watches[serviceName] = w
+	gox.Go(func() {
+		n.watch(ctx, serviceName, w)
+	})
+	return nil
+}
+
+// Unsubscribe 取消服务的全部订阅
+func (n *Naming) Unsubscribe(serviceName string) error {
+	n.Lock()
+	defer n.Unlock()
+	if w, ok := n.watches[serviceName]; ok {
+		w.cancel()
+		delete(n.watches, serviceName)
+	}
+	return nil
+}
+
+// Close 停止所有订阅和续约，不会注销服务
+func (n *Naming) Close() error {
+	n.Lock()
+	defer n.Unlock()
+	for name, w := range n.watches {
+		w.cancel()
+		delete(n.watches, name)
+	}
+	for id, cancel := range n.keepalives {
+		cancel()
+		delete(n.keepalives, id)
+	}
+	return nil
+}
+
+func (n *Naming) watch(ctx context.Context, serviceName string, w *watcher) {
+	var (
+		index uint64
+		last  []*Instance
+		first = true
+		delay = retryDelay
+	)
+	for {
+		res, err := n.find(ctx, serviceName, nil, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Warn(fmt.Sprintf("watch %s err:%s", serviceName, err.Error()))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay *= 2
+			if delay > retryMaxDelay {
+				delay = retryMaxDelay
+			}
+			continue
+		}
+		delay = retryDelay
+		// 注册中心重启后版本可能变小
+		if res.Index < index {
+			res.Index = 0
+		}
+		index = res.Index
+		if !first && reflect.DeepEqual(last, res.Services) {
+			continue
+		}
+		first = false
+		last = res.Services
+		w.notify(toServices(res.Services))
+	}
+}
+
+func (n *Naming) find(ctx context.Context, serviceName string, tags []string, index uint64) (*FindResult, error) {
+	query := url.Values{}
+	for _, tag := range tags {
+		query.Add("tag", tag)
+	}
+	if n.options.Namespace != "" {
+		query.Set("ns", n.options.Namespace)
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", n.options.WaitTime.String())
+	}
+	var res FindResult
+	if err := n.do(ctx, http.MethodGet, "/v1/services/"+url.PathEscape(serviceName), query, nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (n *Naming) keepalive(ins *Instance) {
+	n.Lock()
+	defer n.Unlock()
+	if cancel, ok := n.keepalives[ins.ID]; ok {
+		cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n.keepalives[ins.ID] = cancel
+	gox.Go(func() {
+		ticker := time.NewTicker(n.options.TTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := n.do(ctx, http.MethodPut, "/v1/services/"+url.PathEscape(ins.ID)+"/heartbeat", nil, nil, nil)
+			if errno.Code(err) == pkt.Status_SessionNotFound {
+				err = n.do(ctx, http.MethodPut, "/v1/services", nil, ins, nil)
+			}
+			if err != nil && ctx.Err() == nil {
+				log.Warn(fmt.Sprintf("keepalive %s err:%s", ins.ID, err.Error()))
+			}
+		}
+	})
+}
+
+func (n *Naming) stopKeepalive(serviceID string) {
+	n.Lock()
+	defer n.Unlock()
+	if cancel, ok := n.keepalives[serviceID]; ok {
+		cancel()
+		delete(n.keepalives, serviceID)
+	}
+}
+
+// do 发送请求，错误响应转换为errno.Status
+func (n *Naming) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(buf)
+	}
+	u := n.address + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := n.options.Client.Do(req)
+	if err != nil {
+		return gim.TransportError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e errno.Err
+		if err = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&e); err != nil || e.Code == 0 {
+			return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return errno.NewStatus(pkt.Status(e.Code), e.Msg)
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func toServices(list []*Instance) []gim.ServiceRegistration {
+	res := make([]gim.ServiceRegistration, 0, len(list))
+	for _, ins := range list {
+		res = append(res, &naming.DefaultService{
+			Id:        ins.ID,
+			Name:      ins.Name,
+			Address:   ins.Address,
+			Port:      ins.Port,
+			Protocol:  ins.Protocol,
+			Namespace: ins.Namespace,
+			Tags:      ins.Tags,
+			Meta:      ins.Meta,
+		})
+	}
+	return res
+}
@@ -0,0 +1,247 @@
+package registry
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+)
+
+func testService(id, name string) *naming.DefaultService {
+	return &naming.DefaultService{Id: id, Name: name, Address: "127.0.0.1", Port: 8000, Protocol: "tcp"}
+}
+
+// newTestRegistry 用httptest运行注册中心的http接口，不启动后台的清理和快照
+func newTestRegistry(t *testing.T, opts ...ServerOptionsFunc) (*Server, *Naming) {
+	t.Helper()
+	s := NewServer("", opts...)
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+	n := NewNaming(hs.URL, WithWaitTime(time.Second))
+	t.Cleanup(func() { _ = n.Close() })
+	return s, n
+}
+
+// expireAll 让所有实例过期并清理
+func expireAll(s *Server) {
+	s.Lock()
+	for _, e := range s.entries {
+		e.expireAt = time.Now().Add(-time.Second)
+	}
+	s.Unlock()
+	s.sweep()
+}
+
+func TestNaming_RegisterWithTTL(t *testing.T) {
+	s, n := newTestRegistry(t)
+	if err := n.RegisterWithTTL(testService("chat-1", "chat"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s.Lock()
+	ttl := s.entries["chat-1"].TTL
+	s.Unlock()
+	if ttl != 5 {
+		t.Fatalf("registered with ttl %d, want 5", ttl)
+	}
+	// 由调用者续约，客户端不启动续约
+	n.Lock()
+	keepalives := len(n.keepalives)
+	n.Unlock()
+	if keepalives != 0 {
+		t.Fatalf("%d keepalive loops are running", keepalives)
+	}
+	if err := n.Keepalive("chat-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	expireAll(s)
+	if err := n.Keepalive("chat-1"); err != naming.ErrNotFound {
+		t.Fatalf("keepalive an expired service got %v", err)
+	}
+	services, err := n.Find("chat")
+	if err != nil || len(services) != 0 {
+		t.Fatalf("find got %v %v after expiry", services, err)
+	}
+}
+
+func TestServer_TTLExpiry(t *testing.T) {
+	s, n := newTestRegistry(t)
+	if err := s.Register(&Instance{ID: "chat-1", Name: "chat", TTL: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(&Instance{ID: "chat-2", Name: "chat", TTL: 1}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(600 * time.Millisecond)
+	// 续约的实例重新计算过期时间
+	if err := n.Keepalive("chat-2"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(600 * time.Millisecond)
+	s.sweep()
+	services, _ := n.Find("chat")
+	if len(services) != 1 || services[0].ServiceID() != "chat-2" {
+		t.Fatalf("got %v after chat-1 expired, want chat-2", services)
+	}
+}
+
+func TestServer_SnapshotReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	s, n := newTestRegistry(t, WithSnapshot(path, time.Hour))
+	for _, id := range []string{"chat-1", "chat-2"} {
+		if err := n.RegisterWithTTL(testService(id, "chat"), 10*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = n.Deregister("chat-2")
+	s.Lock()
+	index := s.index
+	s.Unlock()
+	// Shutdown时保存快照
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后从快照恢复实例和版本
+	restarted, rn := newTestRegistry(t, WithSnapshot(path, time.Hour))
+	if err := restarted.load(); err != nil {
+		t.Fatal(err)
+	}
+	services, err := rn.Find("chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || services[0].ServiceID() != "chat-1" || services[0].PublicPort() != 8000 {
+		t.Fatalf("got %v from the snapshot, want chat-1", services)
+	}
+	restarted.Lock()
+	defer restarted.Unlock()
+	if restarted.index < index {
+		t.Fatalf("index went back from %d to %d", index, restarted.index)
+	}
+	if ttl := restarted.entries["chat-1"].TTL; ttl != 10 {
+		t.Fatalf("ttl is %d after reload, want 10", ttl)
+	}
+}
+
+func TestServer_SnapshotCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewServer("", WithSnapshot(path, time.Hour)).load(); err == nil {
+		t.Fatal("loaded a corrupted snapshot")
+	}
+}
+
+// TestServer_LongPoll 查询的版本等于当前版本时等待变化
+func TestServer_LongPoll(t *testing.T) {
+	s, n := newTestRegistry(t)
+	_ = s.Register(&Instance{ID: "chat-1", Name: "chat"})
+	res, err := n.find(context.Background(), "chat", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	done := make(chan *FindResult, 1)
+	go func() {
+		res, err := n.find(context.Background(), "chat", nil, res.Index)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("long poll returned before any change")
+	default:
+	}
+	_ = s.Register(&Instance{ID: "chat-2", Name: "chat"})
+	got := <-done
+	if got == nil || len(got.Services) != 2 || got.Index <= res.Index {
+		t.Fatalf("unexpected result after the change: %+v", got)
+	}
+	if time.Since(start) >= time.Second {
+		t.Fatal("long poll waited until the timeout")
+	}
+
+	// 没有变化时等待wait后返回当前版本
+	start = time.Now()
+	again, err := n.find(context.Background(), "chat", nil, got.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Index != got.Index || time.Since(start) < 900*time.Millisecond {
+		t.Fatalf("long poll returned index %d after %s", again.Index, time.Since(start))
+	}
+}
+
+func TestNaming_Subscribe(t *testing.T) {
+	s, n := newTestRegistry(t)
+	_ = s.Register(&Instance{ID: "chat-1", Name: "chat"})
+	calls := make(chan []string, 10)
+	err := n.Subscribe("chat", func(services []gim.ServiceRegistration) {
+		ids := make([]string, 0, len(services))
+		for _, service := range services {
+			ids = append(ids, service.ServiceID())
+		}
+		calls <- ids
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 第二个订阅者共享同一个长轮询
+	second := make(chan int, 10)
+	if err = n.Subscribe("chat", func(services []gim.ServiceRegistration) {
+		second <- len(services)
+	}); err != nil {
+		t.Fatalf("second subscriber: %v", err)
+	}
+	next := func() []string {
+		select {
+		case ids := <-calls:
+			return ids
+		case <-time.After(3 * time.Second):
+			t.Fatal("no callback")
+			return nil
+		}
+	}
+	if ids := next(); len(ids) != 1 {
+		t.Fatalf("first callback got %v", ids)
+	}
+	// 其它服务的变化不回调
+	_ = s.Register(&Instance{ID: "login-1", Name: "login"})
+	_ = s.Register(&Instance{ID: "chat-2", Name: "chat"})
+	if ids := next(); len(ids) != 2 || ids[1] != "chat-2" {
+		t.Fatalf("callback got %v, want chat-1 and chat-2", ids)
+	}
+	// 第二个订阅者先收到订阅时的实例
+	for _, want := range []int{1, 2} {
+		select {
+		case c := <-second:
+			if c != want {
+				t.Fatalf("second subscriber got %d services, want %d", c, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("second subscriber not called, want %d services", want)
+		}
+	}
+	_ = s.Deregister("chat-1")
+	if ids := next(); len(ids) != 1 || ids[0] != "chat-2" {
+		t.Fatalf("callback got %v, want chat-2", ids)
+	}
+	_ = n.Unsubscribe("chat")
+	_ = s.Register(&Instance{ID: "chat-3", Name: "chat"})
+	select {
+	case ids := <-calls:
+		t.Fatalf("callback after unsubscribe: %v", ids)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,449 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/pkg/gox"
+	"github.com/kkakoz/gim/pkg/logger"
+	"github.com/kkakoz/gim/proto/pkt"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultTTL              = 30 * time.Second
+	DefaultMaxWait          = time.Minute
+	DefaultSnapshotInterval = 5 * time.Second
+
+	// HeaderIndex 响应头中的数据版本，用于长轮询
+	HeaderIndex = "X-Registry-Index"
+
+	sweepInterval = time.Second
+)
+
+var log = logger.WithFields(zap.String("module", "registry"))
+
+// Instance 注册的服务实例，TTL为秒数，为0时使用服务端默认值
+type Instance struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Address   string            `json:"address"`
+	Port      int               `json:"port"`
+	Protocol  string            `json:"protocol"`
+	Namespace string            `json:"namespace,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	TTL       int64             `json:"ttl,omitempty"`
+}
+
+// FindResult 查询结果，Index为数据版本
+type FindResult struct {
+	Index    uint64      `json:"index"`
+	Services []*Instance `json:"services"`
+}
+
+type ServerOptions struct {
+	// 实例没有指定TTL时使用
+	TTL time.Duration
+	// 长轮询最长等待时间
+	MaxWait time.Duration
+	// 快照文件，为空时不保存
+	SnapshotPath     string
+	SnapshotInterval time.Duration
+}
+
+type ServerOptionsFunc func(options *ServerOptions)
+
+func WithDefaultTTL(ttl time.Duration) ServerOptionsFunc {
+	return func(options *ServerOptions) {
+		options.TTL = ttl
+	}
+}
+
+func WithMaxWait(wait time.Duration) ServerOptionsFunc {
+	return func(options *ServerOptions) {
+		options.MaxWait = wait
+	}
+}
+
+func WithSnapshot(path string, interval time.Duration) ServerOptionsFunc {
+	return func(options *ServerOptions) {
+		options.SnapshotPath = path
+		options.SnapshotInterval = interval
+	}
+}
+
+type entry struct {
+	*Instance
+	expireAt time.Time
+}
+
+// Server 注册中心服务，提供http/json接口
+//
+//	PUT    /v1/services                 注册或更新实例
+//	PUT    /v1/services/{id}/heartbeat  续约
+//	DELETE /v1/services/{id}            注销
+//	GET    /v1/services/{name}          查询，支持tag、ns、index和wait参数
+type Server struct {
+	sync.Mutex
+	listen  string
+	options *ServerOptions
+	entries map[string]*entry
+	index   uint64
+	changed chan struct{}
+	dirty   bool
+
+	httpSrv *http.Server
+	quit    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+func NewServer(listen string, opts ...ServerOptionsFunc) *Server {
+	options := &ServerOptions{
+		TTL:              DefaultTTL,
+		MaxWait:          DefaultMaxWait,
+		SnapshotInterval: DefaultSnapshotInterval,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	s := &Server{
+		listen:  listen,
+		options: options,
+		entries: make(map[string]*entry),
+		index:   1,
+		changed: make(chan struct{}),
+		quit:    make(chan struct{}),
+	}
+	s.httpSrv = &http.Server{Addr: listen, Handler: s}
+	return s
+}
+
+// Start 加载快照并开始服务，阻塞到Shutdown
+func (s *Server) Start() error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.loop(sweepInterval, s.sweep)
+	if s.options.SnapshotPath != "" {
+		s.loop(s.options.SnapshotInterval, func() {
+			if err := s.save(false); err != nil {
+				log.Warn("save snapshot err:" + err.Error())
+			}
+		})
+	}
+	log.Info("started", zap.String("listen", s.listen))
+	err := s.httpSrv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown 停止服务并保存快照
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.once.Do(func() {
+		close(s.quit)
+	})
+	err := s.httpSrv.Shutdown(ctx)
+	s.wg.Wait()
+	if s.options.SnapshotPath != "" {
+		if serr := s.save(true); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+func (s *Server) loop(interval time.Duration, fn func()) {
+	s.wg.Add(1)
+	gox.Go(func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.quit:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/services"), "/")
+	parts := strings.Split(path, "/")
+	var err error
+	switch {
+	case r.Method == http.MethodPut && path == "":
+		err = s.handleRegister(w, r)
+	case r.Method == http.MethodPut && len(parts) == 2 && parts[1] == "heartbeat":
+		err = s.Heartbeat(parts[0])
+	case r.Method == http.MethodDelete && len(parts) == 1 && path != "":
+		err = s.Deregister(parts[0])
+	case r.Method == http.MethodGet && len(parts) == 1 && path != "":
+		err = s.handleFind(w, r, parts[0])
+	default:
+		err = errno.NewErr(http.StatusNotFound, int(pkt.Status_InvalidCommand), "unknown api "+r.Method+" "+r.URL.Path)
+	}
+	if err != nil {
+		writeErr(w, err)
+	}
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) error {
+	var ins Instance
+	if err := json.NewDecoder(r.Body).Decode(&ins); err != nil {
+		return errno.WrapStatus(pkt.Status_InvalidPacketBody, err)
+	}
+	if err := s.Register(&ins); err != nil {
+		return err
+	}
+	return writeJSON(w, &ins)
+}
+
+func (s *Server) handleFind(w http.ResponseWriter, r *http.Request, name string) error {
+	query := r.URL.Query()
+	var (
+		index uint64
+		wait  time.Duration
+		err   error
+	)
+	if v := query.Get("index"); v != "" {
+		if index, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return errno.Statusf(pkt.Status_InvalidPacketBody, "invalid index %s", v)
+		}
+	}
+	if v := query.Get("wait"); v != "" {
+		if wait, err = time.ParseDuration(v); err != nil {
+			return errno.Statusf(pkt.Status_InvalidPacketBody, "invalid wait %s", v)
+		}
+	}
+	res := s.Watch(r.Context(), name, query.Get("ns"), query["tag"], index, wait)
+	w.Header().Set(HeaderIndex, strconv.FormatUint(res.Index, 10))
+	return writeJSON(w, res)
+}
+
+// Register 注册或更新实例，同时续约
+func (s *Server) Register(ins *Instance) error {
+	if ins.ID == "" || ins.Name == "" {
+		return errno.NewStatus(pkt.Status_InvalidPacketBody, "id or name is empty")
+	}
+	if ins.TTL <= 0 {
+		ins.TTL = int64(s.options.TTL / time.Second)
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.entries[ins.ID] = &entry{Instance: ins, expireAt: time.Now().Add(time.Duration(ins.TTL) * time.Second)}
+	s.bump()
+	return nil
+}
+
+// Heartbeat 续约，实例不存在时返回SessionNotFound，客户端需要重新注册
+func (s *Server) Heartbeat(id string) error {
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return errno.Statusf(pkt.Status_SessionNotFound, "service %s not found", id)
+	}
+	e.expireAt = time.Now().Add(time.Duration(e.TTL) * time.Second)
+	return nil
+}
+
+func (s *Server) Deregister(id string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.entries[id]; !ok {
+		return errno.Statusf(pkt.Status_SessionNotFound, "service %s not found", id)
+	}
+	delete(s.entries, id)
+	s.bump()
+	return nil
+}
+
+// Watch 查询服务实例，index等于当前版本时等待变化，最多等待wait
+func (s *Server) Watch(ctx context.Context, name, namespace string, tags []string, index uint64, wait time.Duration) *FindResult {
+	if wait <= 0 || wait > s.options.MaxWait {
+		wait = s.options.MaxWait
+	}
+	s.Lock()
+	if index > 0 && index == s.index {
+		changed := s.changed
+		s.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+		case <-s.quit:
+		}
+		timer.Stop()
+		s.Lock()
+	}
+	defer s.Unlock()
+	return &FindResult{Index: s.index, Services: s.find(name, namespace, tags)}
+}
+
+func (s *Server) find(name, namespace string, tags []string) []*Instance {
+	res := make([]*Instance, 0)
+	for _, e := range s.entries {
+		if e.Name != name || (namespace != "" && e.Namespace != namespace) || !hasTags(e.Tags, tags) {
+			continue
+		}
+		res = append(res, e.Instance)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// sweep 移除过期的实例
+func (s *Server) sweep() {
+	now := time.Now()
+	s.Lock()
+	defer s.Unlock()
+	expired := 0
+	for id, e := range s.entries {
+		if now.After(e.expireAt) {
+			log.Info(fmt.Sprintf("service %s:%s expired", e.Name, id))
+			delete(s.entries, id)
+			expired++
+		}
+	}
+	if expired > 0 {
+		s.bump()
+	}
+}
+
+// bump 在锁内调用，更新版本并唤醒等待中的查询
+func (s *Server) bump() {
+	s.index++
+	s.dirty = true
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+type snapshot struct {
+	Index    uint64      `json:"index"`
+	Services []*Instance `json:"services"`
+}
+
+// load 从快照恢复，恢复的实例重新计算过期时间，给它们留出续约的时间
+func (s *Server) load() error {
+	if s.options.SnapshotPath == "" {
+		return nil
+	}
+	buf, err := os.ReadFile(s.options.SnapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err = json.Unmarshal(buf, &snap); err != nil {
+		return fmt.Errorf("decode snapshot %s err:%w", s.options.SnapshotPath, err)
+	}
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for _, ins := range snap.Services {
+		s.entries[ins.ID] = &entry{Instance: ins, expireAt: now.Add(time.Duration(ins.TTL) * time.Second)}
+	}
+	if snap.Index > s.index {
+		s.index = snap.Index
+	}
+	log.Info(fmt.Sprintf("load %d services from snapshot", len(snap.Services)))
+	return nil
+}
+
+// save 把当前的实例写入快照文件，先写临时文件再重命名
+func (s *Server) save(force bool) error {
+	s.Lock()
+	if !s.dirty && !force {
+		s.Unlock()
+		return nil
+	}
+	snap := snapshot{Index: s.index, Services: make([]*Instance, 0, len(s.entries))}
+	for _, e := range s.entries {
+		snap.Services = append(snap.Services, e.Instance)
+	}
+	s.dirty = false
+	s.Unlock()
+
+	sort.Slice(snap.Services, func(i, j int) bool {
+		return snap.Services[i].ID < snap.Services[j].ID
+	})
+	buf, err := json.MarshalIndent(&snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := s.options.SnapshotPath
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(buf); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		s.Lock()
+		s.dirty = true
+		s.Unlock()
+	}
+	return err
+}
+
+func hasTags(have, want []string) bool {
+	for _, tag := range want {
+		found := false
+		for _, t := range have {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
+
+func writeErr(w http.ResponseWriter, err error) {
+	var e *errno.Err
+	if !errors.As(err, &e) {
+		e = errno.FromError(err).Err()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.HttpCode)
+	_ = json.NewEncoder(w).Encode(e)
+}
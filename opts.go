@@ -53,7 +53,8 @@ func WithClientHeartbeat(duration time.Duration) ClientOptionFunc {
 }
 
 type ChannelOptions struct {
-	ctx context.Context
+	ctx  context.Context
+	meta *ChannelMeta
 }
 
 type channelOptionFunc func(opt *ChannelOptions)
@@ -64,6 +65,14 @@ func WithChannelCtx(ctx context.Context) channelOptionFunc {
 	}
 }
 
+func WithChannelMeta(meta *ChannelMeta) channelOptionFunc {
+	return func(opt *ChannelOptions) {
+		if meta != nil {
+			opt.meta = meta
+		}
+	}
+}
+
 func newChannelOptions() *ChannelOptions {
-	return &ChannelOptions{ctx: context.Background()}
+	return &ChannelOptions{ctx: context.Background(), meta: &ChannelMeta{}}
 }
@@ -29,6 +29,10 @@ func InitConfigFile(path string) {
 	conf = viper.GetViper()
 }
 
+// Conf 未初始化时返回viper的默认实例
 func Conf() *viper.Viper {
+	if conf == nil {
+		return viper.GetViper()
+	}
 	return conf
 }
@@ -1,12 +1,25 @@
 package endian
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
 )
 
 var Default = binary.BigEndian
 
+// DefaultMaxBytes ReadBytes允许读取的最大长度
+const DefaultMaxBytes = 16 << 20
+
+// 长度超过此值时按块读取，避免按照恶意的长度头一次性分配内存
+const chunkSize = 64 << 10
+
+var (
+	ErrTooLarge      = errors.New("endian: bytes length exceeds limit")
+	ErrInvalidLength = errors.New("endian: invalid length")
+)
+
 // ReadUint8 从 reader 中读取一个 uint8
 func ReadUint8(r io.Reader) (uint8, error) {
 	var bytes = make([]byte, 1)
@@ -54,26 +67,47 @@ func ReadString(r io.Reader) (string, error) {
 
 // ReadBytes 从 reader 中读取一个 []byte, reader中前4byte 必须是[]byte 的长度
 func ReadBytes(r io.Reader) ([]byte, error) {
+	return ReadBytesLimit(r, DefaultMaxBytes)
+}
+
+// ReadBytesLimit 同ReadBytes，长度超过max时返回ErrTooLarge
+func ReadBytesLimit(r io.Reader, max uint32) ([]byte, error) {
 	bufLen, err := ReadUint32(r)
 	if err != nil {
 		return nil, err
 	}
-	buf := make([]byte, bufLen)
-	_, err = io.ReadFull(r, buf)
-	if err != nil {
-		return nil, err
+	if bufLen > max {
+		return nil, ErrTooLarge
 	}
-	return buf, nil
+	return readFull(r, int(bufLen))
 }
 
 //ReadFixedBytes 读取固定长度的字节
 func ReadFixedBytes(len int, r io.Reader) ([]byte, error) {
-	buf := make([]byte, len)
-	_, err := io.ReadFull(r, buf)
+	if len < 0 {
+		return nil, ErrInvalidLength
+	}
+	return readFull(r, len)
+}
+
+// readFull 读取n个字节，较大的长度按块读取，数据不足时不会预先分配n个字节
+func readFull(r io.Reader, n int) ([]byte, error) {
+	if n <= chunkSize {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, chunkSize))
+	read, err := buf.ReadFrom(io.LimitReader(r, int64(n)))
 	if err != nil {
 		return nil, err
 	}
-	return buf, nil
+	if read != int64(n) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), nil
 }
 
 // WriteUint8 写一个 uint8到 writer 中
@@ -153,12 +187,7 @@ func ReadShortBytes(r io.Reader) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	buf := make([]byte, bufLen)
-	_, err = io.ReadFull(r, buf)
-	if err != nil {
-		return nil, err
-	}
-	return buf, nil
+	return readFull(r, int(bufLen))
 }
 
 func ReadShortString(r io.Reader) (string, error) {
@@ -0,0 +1,44 @@
+package endian
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzReadBytes(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0, 1, 0, 0, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		buf, err := ReadBytesLimit(bytes.NewReader(data), 1<<20)
+		if err != nil {
+			return
+		}
+		if len(buf) > len(data)-4 {
+			t.Fatalf("read %d bytes from %d bytes input", len(buf), len(data))
+		}
+		// 重新编码后应当得到相同的结果
+		w := new(bytes.Buffer)
+		if err := WriteBytes(w, buf); err != nil {
+			t.Fatal(err)
+		}
+		again, err := ReadBytes(w)
+		if err != nil || !bytes.Equal(buf, again) {
+			t.Fatalf("round trip mismatch: %v", err)
+		}
+	})
+}
+
+func FuzzReadShortBytes(f *testing.F) {
+	f.Add([]byte{0, 3, 'a', 'b', 'c'})
+	f.Add([]byte{0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		buf, err := ReadShortBytes(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if len(buf) > len(data)-2 {
+			t.Fatalf("read %d bytes from %d bytes input", len(buf), len(data))
+		}
+	})
+}
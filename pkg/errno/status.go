@@ -0,0 +1,162 @@
+package errno
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+// 错误响应中携带错误描述和详情的meta key，每条详情是一条meta
+const (
+	MetaErrorMsg     = "error.msg"
+	MetaErrorDetails = "error.details"
+)
+
+var (
+	ErrConnClosed    = NewStatus(pkt.Status_ConnectionClosed, "remote side close the channel")
+	ErrTimeout       = NewStatus(pkt.Status_Timeout, "i/o timeout")
+	ErrFrameTooLarge = NewStatus(pkt.Status_FrameTooLarge, "frame too large")
+)
+
+// Status 协议层错误，携带pkt.Status、描述和详情
+type Status struct {
+	Code    pkt.Status `json:"code"`
+	Msg     string     `json:"msg"`
+	Details []string   `json:"details,omitempty"`
+	cause   error
+}
+
+func (s *Status) Error() string {
+	return s.Msg
+}
+
+func (s *Status) Unwrap() error {
+	return s.cause
+}
+
+// Is 同一个Code的Status视为同一种错误
+func (s *Status) Is(target error) bool {
+	t, ok := target.(*Status)
+	return ok && t.Code == s.Code
+}
+
+// HttpCode 管理接口使用的http状态码
+func (s *Status) HttpCode() int {
+	switch s.Code {
+	case pkt.Status_Success:
+		return http.StatusOK
+	case pkt.Status_InvalidPacketBody, pkt.Status_InvalidCommand:
+		return http.StatusBadRequest
+	case pkt.Status_FrameTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case pkt.Status_Unauthorized:
+		return http.StatusUnauthorized
+	case pkt.Status_SessionNotFound:
+		return http.StatusNotFound
+	case pkt.Status_NoDestination, pkt.Status_ConnectionClosed:
+		return http.StatusServiceUnavailable
+	case pkt.Status_Timeout:
+		return http.StatusGatewayTimeout
+	case pkt.Status_NotImplemented:
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Err 转换为管理接口使用的Err
+func (s *Status) Err() *Err {
+	return &Err{
+		HttpCode: s.HttpCode(),
+		Code:     int(s.Code),
+		Msg:      s.Msg,
+	}
+}
+
+func NewStatus(code pkt.Status, msg string, details ...string) error {
+	return &Status{
+		Code:    code,
+		Msg:     msg,
+		Details: details,
+	}
+}
+
+func Statusf(code pkt.Status, format string, args ...interface{}) error {
+	return &Status{
+		Code: code,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+}
+
+// WrapStatus 使用code包装一个底层错误
+func WrapStatus(code pkt.Status, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Status{
+		Code:  code,
+		Msg:   err.Error(),
+		cause: err,
+	}
+}
+
+// FromError 把任意错误转换为Status，nil返回nil，未分类的错误为SystemException
+func FromError(err error) *Status {
+	if err == nil {
+		return nil
+	}
+	var s *Status
+	if errors.As(err, &s) {
+		return s
+	}
+	var e *Err
+	if errors.As(err, &e) {
+		return &Status{Code: httpToStatus(e.HttpCode), Msg: e.Msg, cause: err}
+	}
+	return &Status{Code: pkt.Status_SystemException, Msg: err.Error(), cause: err}
+}
+
+// Code 返回错误对应的pkt.Status
+func Code(err error) pkt.Status {
+	if err == nil {
+		return pkt.Status_Success
+	}
+	return FromError(err).Code
+}
+
+// FromHeader 把响应头还原为Status，成功时返回nil
+func FromHeader(header *pkt.Header) error {
+	if header == nil || header.GetStatus() == pkt.Status_Success {
+		return nil
+	}
+	s := &Status{Code: header.GetStatus(), Details: header.MetaValues(MetaErrorDetails)}
+	if msgs := header.MetaValues(MetaErrorMsg); len(msgs) > 0 {
+		s.Msg = msgs[0]
+	} else {
+		s.Msg = header.GetStatus().String()
+	}
+	return s
+}
+
+func httpToStatus(code int) pkt.Status {
+	switch {
+	case code < 300:
+		return pkt.Status_Success
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return pkt.Status_Unauthorized
+	case code == http.StatusNotFound:
+		return pkt.Status_NoDestination
+	case code == http.StatusRequestEntityTooLarge:
+		return pkt.Status_FrameTooLarge
+	case code == http.StatusNotImplemented:
+		return pkt.Status_NotImplemented
+	case code == http.StatusGatewayTimeout, code == http.StatusRequestTimeout:
+		return pkt.Status_Timeout
+	case code < 500:
+		return pkt.Status_InvalidPacketBody
+	default:
+		return pkt.Status_SystemException
+	}
+}
@@ -0,0 +1,73 @@
+package errno
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/kkakoz/gim/proto/pkt"
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestStatusHttpCode(t *testing.T) {
+	cases := map[pkt.Status]int{
+		pkt.Status_Success:            http.StatusOK,
+		pkt.Status_InvalidCommand:     http.StatusBadRequest,
+		pkt.Status_FrameTooLarge:      http.StatusRequestEntityTooLarge,
+		pkt.Status_Unauthorized:       http.StatusUnauthorized,
+		pkt.Status_SessionNotFound:    http.StatusNotFound,
+		pkt.Status_NoDestination:      http.StatusServiceUnavailable,
+		pkt.Status_Timeout:            http.StatusGatewayTimeout,
+		pkt.Status_NotImplemented:     http.StatusNotImplemented,
+		pkt.Status_SystemException:    http.StatusInternalServerError,
+		pkt.Status_UnsupportedVersion: http.StatusInternalServerError,
+	}
+	for code, want := range cases {
+		s := NewStatus(code, "msg").(*Status)
+		if got := s.HttpCode(); got != want {
+			t.Errorf("%s: HttpCode() = %d, want %d", code, got, want)
+		}
+		if e := s.Err(); e.HttpCode != want || e.Code != int(code) || e.Msg != "msg" {
+			t.Errorf("%s: Err() = %+v", code, e)
+		}
+	}
+}
+
+func TestFromError(t *testing.T) {
+	cause := errors.New("boom")
+	wrapped := pkgerrors.Wrap(WrapStatus(pkt.Status_Timeout, cause), "read")
+	if got := Code(wrapped); got != pkt.Status_Timeout {
+		t.Fatalf("Code(wrapped) = %s, want Timeout", got)
+	}
+	if !errors.Is(wrapped, cause) || !errors.Is(wrapped, ErrTimeout) {
+		t.Fatal("wrapped status does not match its cause and ErrTimeout")
+	}
+	if got := Code(NewErr(http.StatusNotFound, 404, "not found")); got != pkt.Status_NoDestination {
+		t.Fatalf("Code(Err 404) = %s, want NoDestination", got)
+	}
+	if got := Code(cause); got != pkt.Status_SystemException {
+		t.Fatalf("Code(plain error) = %s, want SystemException", got)
+	}
+	if Code(nil) != pkt.Status_Success || FromError(nil) != nil {
+		t.Fatal("nil error is not success")
+	}
+}
+
+func TestFromHeader(t *testing.T) {
+	if FromHeader(&pkt.Header{Status: pkt.Status_Success}) != nil || FromHeader(nil) != nil {
+		t.Fatal("successful header is an error")
+	}
+	err := FromHeader(&pkt.Header{Status: pkt.Status_Unauthorized, Meta: []*pkt.Meta{
+		{Key: MetaErrorMsg, Value: "not a friend"},
+		{Key: MetaErrorDetails, Value: "a,b"},
+		{Key: MetaErrorDetails, Value: "c"},
+	}})
+	var s *Status
+	if !errors.As(err, &s) || s.Code != pkt.Status_Unauthorized || s.Msg != "not a friend" || len(s.Details) != 2 || s.Details[0] != "a,b" {
+		t.Fatalf("FromHeader = %+v", err)
+	}
+	// 没有描述时使用Status的名字
+	if err = FromHeader(&pkt.Header{Status: pkt.Status_Timeout}); err.Error() != pkt.Status_Timeout.String() || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("FromHeader without msg = %v", err)
+	}
+}
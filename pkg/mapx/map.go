@@ -34,13 +34,13 @@ func (m *SyncMap[K, V]) Delete(k K) {
 }
 
 func (m *SyncMap[K, V]) Len() int {
-	m.lock.RUnlock()
+	m.lock.RLock()
 	defer m.lock.RUnlock()
 	return len(m.m)
 }
 
 func (m *SyncMap[K, V]) Values() []V {
-	m.lock.Lock()
+	m.lock.RLock()
 	defer m.lock.RUnlock()
 	res := make([]V, 0, len(m.m))
 	for _, v := range m.m {
@@ -0,0 +1,48 @@
+package pkt
+
+import "strings"
+
+// 服务名称
+const (
+	SNLogin = "login"
+	SNChat  = "chat"
+)
+
+// 标准指令，格式为 服务名.动作
+const (
+	// 登录
+	CommandLoginSignIn  = "login.signin"
+	CommandLoginSignOut = "login.signout"
+	CommandLoginKickout = "login.kickout"
+
+	// 聊天
+	CommandChatUserTalk  = "chat.user.talk"
+	CommandChatGroupTalk = "chat.group.talk"
+	CommandChatTalkAck   = "chat.talk.ack"
+
+	// 离线同步
+	CommandOfflineIndex   = "chat.offline.index"
+	CommandOfflineContent = "chat.offline.content"
+
+	// 群管理
+	CommandGroupCreate = "chat.group.create"
+	CommandGroupJoin   = "chat.group.join"
+	CommandGroupQuit   = "chat.group.quit"
+	CommandGroupDetail = "chat.group.detail"
+)
+
+// 网关和逻辑服务之间使用的meta key
+const (
+	// 发起请求的网关
+	MetaDestServer = "dest.server"
+	// 推送的目标channel，每个channel一条meta，channelID中可以包含逗号
+	MetaDestChannels = "dest.channels"
+)
+
+// ServiceName 返回指令所属的服务名
+func ServiceName(command string) string {
+	if i := strings.Index(command, "."); i > 0 {
+		return command[:i]
+	}
+	return command
+}
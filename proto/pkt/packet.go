@@ -0,0 +1,207 @@
+package pkt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/kkakoz/gim/pkg/endian"
+	"google.golang.org/protobuf/proto"
+)
+
+// MagicLogicPkt 逻辑包前缀
+var MagicLogicPkt = [4]byte{0xc3, 0x11, 0xa3, 0x66}
+
+// LogicPkt 客户端、网关和逻辑服务之间传输的消息包
+type LogicPkt struct {
+	Header
+	Body []byte `json:"body,omitempty"`
+}
+
+type HeaderOption func(*Header)
+
+func WithStatus(status Status) HeaderOption {
+	return func(h *Header) {
+		h.Status = status
+	}
+}
+
+func WithSeq(seq uint32) HeaderOption {
+	return func(h *Header) {
+		h.Sequence = seq
+	}
+}
+
+func WithChannel(channelID string) HeaderOption {
+	return func(h *Header) {
+		h.ChannelId = channelID
+	}
+}
+
+func WithDest(dest string) HeaderOption {
+	return func(h *Header) {
+		h.Dest = dest
+	}
+}
+
+// New 创建一个请求包
+func New(command string, options ...HeaderOption) *LogicPkt {
+	p := &LogicPkt{}
+	p.Command = command
+	for _, option := range options {
+		option(&p.Header)
+	}
+	return p
+}
+
+// NewFrom 根据请求头创建一个响应包，meta被复制，修改响应不影响请求
+func NewFrom(header *Header) *LogicPkt {
+	p := &LogicPkt{}
+	p.Command = header.GetCommand()
+	p.ChannelId = header.GetChannelId()
+	p.Sequence = header.GetSequence()
+	p.Dest = header.GetDest()
+	p.Flag = Flag_Response
+	p.Status = header.GetStatus()
+	if meta := header.GetMeta(); len(meta) > 0 {
+		p.Meta = make([]*Meta, 0, len(meta))
+		for _, m := range meta {
+			p.Meta = append(p.Meta, &Meta{Key: m.GetKey(), Value: m.GetValue(), Type: m.GetType()})
+		}
+	}
+	return p
+}
+
+// ReadBody 解析包体
+func (p *LogicPkt) ReadBody(val proto.Message) error {
+	return proto.Unmarshal(p.Body, val)
+}
+
+// WriteBody 写入包体
+func (p *LogicPkt) WriteBody(val proto.Message) *LogicPkt {
+	if val == nil {
+		return p
+	}
+	p.Body, _ = proto.Marshal(val)
+	return p
+}
+
+// StringBody 返回字符串形式的包体
+func (p *LogicPkt) StringBody() string {
+	return string(p.Body)
+}
+
+func (p *LogicPkt) String() string {
+	return fmt.Sprintf("header:%v body:%d bytes", &p.Header, len(p.Body))
+}
+
+// AddMeta 添加meta
+func (p *LogicPkt) AddMeta(m ...*Meta) {
+	p.Meta = append(p.Meta, m...)
+}
+
+// AddStringMeta 添加字符串类型的meta，key存在时覆盖
+func (p *LogicPkt) AddStringMeta(key, value string) {
+	for _, m := range p.Meta {
+		if m.Key == key {
+			m.Value = value
+			m.Type = MetaType_string
+			return
+		}
+	}
+	p.AddMeta(&Meta{Key: key, Value: value, Type: MetaType_string})
+}
+
+// AddMetaValues 一个key添加多个值，每个值是一条meta，值中可以包含任意字符
+func (p *LogicPkt) AddMetaValues(key string, values ...string) {
+	for _, value := range values {
+		p.AddMeta(&Meta{Key: key, Value: value, Type: MetaType_string})
+	}
+}
+
+// MetaValues 按顺序返回key的所有值
+func (h *Header) MetaValues(key string) []string {
+	var values []string
+	for _, m := range h.GetMeta() {
+		if m.GetKey() == key {
+			values = append(values, m.GetValue())
+		}
+	}
+	return values
+}
+
+// GetMeta 读取meta
+func (p *LogicPkt) GetMeta(key string) (string, bool) {
+	for _, m := range p.Meta {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+	return "", false
+}
+
+// DelMeta 删除key的所有meta
+func (p *LogicPkt) DelMeta(key string) {
+	meta := p.Meta[:0]
+	for _, m := range p.Meta {
+		if m.Key != key {
+			meta = append(meta, m)
+		}
+	}
+	p.Meta = meta
+}
+
+// Decode 从reader中读取header和body，不包含magic
+func (p *LogicPkt) Decode(r io.Reader) error {
+	headerBytes, err := endian.ReadBytes(r)
+	if err != nil {
+		return err
+	}
+	if err := proto.Unmarshal(headerBytes, &p.Header); err != nil {
+		return err
+	}
+	p.Body, err = endian.ReadBytes(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Encode 写入header和body，不包含magic
+func (p *LogicPkt) Encode(w io.Writer) error {
+	headerBytes, err := proto.Marshal(&p.Header)
+	if err != nil {
+		return err
+	}
+	if err := endian.WriteBytes(w, headerBytes); err != nil {
+		return err
+	}
+	if err := endian.WriteBytes(w, p.Body); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Marshal 带magic编码
+func Marshal(p *LogicPkt) []byte {
+	buf := new(bytes.Buffer)
+	_, _ = buf.Write(MagicLogicPkt[:])
+	_ = p.Encode(buf)
+	return buf.Bytes()
+}
+
+// Read 读取带magic的逻辑包
+func Read(r io.Reader) (*LogicPkt, error) {
+	magic, err := endian.ReadFixedBytes(len(MagicLogicPkt), r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, MagicLogicPkt[:]) {
+		return nil, fmt.Errorf("magic code %x is incorrect", magic)
+	}
+	p := &LogicPkt{}
+	if err := p.Decode(r); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,68 @@
+package pkt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func FuzzRead(f *testing.F) {
+	p := New(CommandChatUserTalk, WithChannel("channel1"), WithSeq(1), WithDest("user2"))
+	p.AddStringMeta(MetaDestServer, "gateway1")
+	p.WriteBody(&MessageReq{Type: 1, Body: "hello"})
+	f.Add(Marshal(p))
+	f.Add(Marshal(New(CommandLoginSignIn)))
+	f.Add(append(MagicLogicPkt[:], 0xff, 0xff, 0xff, 0xff))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := Read(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		again, err := Read(bytes.NewReader(Marshal(p)))
+		if err != nil {
+			t.Fatalf("decode re-encoded packet: %v", err)
+		}
+		if !proto.Equal(&p.Header, &again.Header) || !bytes.Equal(p.Body, again.Body) {
+			t.Fatalf("round trip mismatch: %v != %v", p, again)
+		}
+	})
+}
+
+func TestNewFrom(t *testing.T) {
+	req := New(CommandChatUserTalk, WithSeq(3), WithChannel("u1"))
+	req.AddStringMeta(MetaDestServer, "gateway-1")
+	req.AddStringMeta("trace", "t1")
+
+	resp := NewFrom(&req.Header)
+	resp.AddStringMeta(MetaDestServer, "gateway-2")
+	resp.DelMeta("trace")
+	resp.AddStringMeta("extra", "x")
+
+	if v, _ := req.GetMeta(MetaDestServer); v != "gateway-1" {
+		t.Fatalf("request meta changed to %s", v)
+	}
+	if _, ok := req.GetMeta("trace"); !ok || len(req.Meta) != 2 {
+		t.Fatalf("request meta = %v", req.Meta)
+	}
+	if resp.Flag != Flag_Response || resp.Sequence != 3 || resp.ChannelId != "u1" {
+		t.Fatalf("unexpected response header %v", &resp.Header)
+	}
+	if got := New("cmd").WriteBody(&Meta{Key: "k"}).String(); !strings.HasSuffix(got, "body:3 bytes") {
+		t.Fatalf("String() = %s", got)
+	}
+}
+
+func TestMetaValues(t *testing.T) {
+	p := New(CommandChatUserTalk)
+	p.AddMetaValues("k", "a,b", "c")
+	p.AddStringMeta("other", "x")
+	if got := p.MetaValues("k"); len(got) != 2 || got[0] != "a,b" || got[1] != "c" {
+		t.Fatalf("MetaValues = %v", got)
+	}
+	p.DelMeta("k")
+	if got := p.MetaValues("k"); len(got) != 0 || len(p.Meta) != 1 {
+		t.Fatalf("meta after DelMeta = %v", p.Meta)
+	}
+}
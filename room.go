@@ -0,0 +1,318 @@
+package gim
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/pkg/gox"
+	"github.com/kkakoz/gim/pkg/logger"
+	"github.com/kkakoz/gim/proto/pkt"
+	"go.uber.org/zap"
+)
+
+// DefaultRoomConcurrency 一次广播最多同时推送的goroutine数
+const DefaultRoomConcurrency = 16
+
+// statsTopRooms ReportStats只输出人数最多的房间
+const statsTopRooms = 10
+
+type RoomOptions struct {
+	Concurrency int
+}
+
+type RoomOptionsFunc func(opts *RoomOptions)
+
+func WithRoomConcurrency(n int) RoomOptionsFunc {
+	return func(opts *RoomOptions) {
+		if n > 0 {
+			opts.Concurrency = n
+		}
+	}
+}
+
+type room struct {
+	members map[string]IChannel
+	joins   int64
+	leaves  int64
+}
+
+// RoomStats 一个房间的人数和进出次数，房间空了之后计数清零
+type RoomStats struct {
+	Room   string
+	Size   int
+	Joins  int64
+	Leaves int64
+}
+
+// RoomManagerStats 所有房间的统计，Rooms按人数从大到小排列
+type RoomManagerStats struct {
+	Members      int // 所有房间的成员数之和，一个连接加入多个房间时重复计算
+	Joins        int64
+	Leaves       int64
+	Broadcasts   int64
+	PushFailures int64
+	Rooms        []RoomStats
+}
+
+// RoomManager 网关上的房间成员管理，连接断开时由DisconnectNotifier通知离开所有房间
+type RoomManager struct {
+	sync.RWMutex
+	channels IChannelMap
+	options  *RoomOptions
+	rooms    map[string]*room
+	joined   map[string]map[string]struct{} // channelId -> rooms
+
+	joins        int64
+	leaves       int64
+	broadcasts   int64
+	pushFailures int64
+}
+
+var _ StateListener = (*RoomManager)(nil)
+
+// NewRoomManager notifier通常就是Server，为nil时需要在StateListener中调用Disconnect清理
+func NewRoomManager(channels IChannelMap, notifier DisconnectNotifier, opts ...RoomOptionsFunc) *RoomManager {
+	options := &RoomOptions{Concurrency: DefaultRoomConcurrency}
+	for _, opt := range opts {
+		opt(options)
+	}
+	m := &RoomManager{
+		channels: channels,
+		options:  options,
+		rooms:    make(map[string]*room),
+		joined:   make(map[string]map[string]struct{}),
+	}
+	if notifier != nil {
+		notifier.OnDisconnect(m.LeaveAll)
+	} else {
+		logger.Warn("room manager has no disconnect notifier, call Disconnect when a channel is closed")
+	}
+	return m
+}
+
+// Join 连接必须在channels中，重复加入不重复计数
+func (m *RoomManager) Join(roomID, channelID string) error {
+	m.Lock()
+	defer m.Unlock()
+	// 在锁内检查，保证已经断开的连接不会留在房间里
+	channel, ok := m.channels.Get(channelID)
+	if !ok {
+		return errno.Statusf(pkt.Status_SessionNotFound, "channel %s not found", channelID)
+	}
+	r, ok := m.rooms[roomID]
+	if !ok {
+		r = &room{members: make(map[string]IChannel)}
+		m.rooms[roomID] = r
+	}
+	if _, ok := r.members[channelID]; ok {
+		return nil
+	}
+	r.members[channelID] = channel
+	r.joins++
+	rooms, ok := m.joined[channelID]
+	if !ok {
+		rooms = make(map[string]struct{})
+		m.joined[channelID] = rooms
+	}
+	rooms[roomID] = struct{}{}
+	atomic.AddInt64(&m.joins, 1)
+	return nil
+}
+
+func (m *RoomManager) Leave(roomID, channelID string) {
+	m.Lock()
+	defer m.Unlock()
+	m.leave(roomID, channelID)
+}
+
+// LeaveAll 离开连接加入的所有房间
+func (m *RoomManager) LeaveAll(channelID string) {
+	m.Lock()
+	defer m.Unlock()
+	for roomID := range m.joined[channelID] {
+		m.leave(roomID, channelID)
+	}
+}
+
+func (m *RoomManager) leave(roomID, channelID string) {
+	r, ok := m.rooms[roomID]
+	if !ok {
+		return
+	}
+	if _, ok := r.members[channelID]; !ok {
+		return
+	}
+	delete(r.members, channelID)
+	r.leaves++
+	if len(r.members) == 0 {
+		delete(m.rooms, roomID)
+	}
+	delete(m.joined[channelID], roomID)
+	if len(m.joined[channelID]) == 0 {
+		delete(m.joined, channelID)
+	}
+	atomic.AddInt64(&m.leaves, 1)
+}
+
+// Disconnect 实现StateListener，没有DisconnectNotifier时在连接断开后调用
+func (m *RoomManager) Disconnect(channelID string) error {
+	m.LeaveAll(channelID)
+	return nil
+}
+
+// Members 房间内的连接id
+func (m *RoomManager) Members(roomID string) []string {
+	m.RLock()
+	defer m.RUnlock()
+	r, ok := m.rooms[roomID]
+	if !ok {
+		return nil
+	}
+	res := make([]string, 0, len(r.members))
+	for id := range r.members {
+		res = append(res, id)
+	}
+	return res
+}
+
+// Rooms 连接加入的房间
+func (m *RoomManager) Rooms(channelID string) []string {
+	m.RLock()
+	defer m.RUnlock()
+	res := make([]string, 0, len(m.joined[channelID]))
+	for roomID := range m.joined[channelID] {
+		res = append(res, roomID)
+	}
+	return res
+}
+
+func (m *RoomManager) Size(roomID string) int {
+	m.RLock()
+	defer m.RUnlock()
+	if r, ok := m.rooms[roomID]; ok {
+		return len(r.members)
+	}
+	return 0
+}
+
+// BroadcastPacket 只编码一次，见Broadcast
+func (m *RoomManager) BroadcastPacket(roomID string, packet *pkt.LogicPkt, excludes ...string) ([]string, error) {
+	return m.Broadcast(roomID, pkt.Marshal(packet), excludes...)
+}
+
+// Broadcast 把同一个payload推送给房间内除excludes外的所有连接，返回推送失败的连接
+func (m *RoomManager) Broadcast(roomID string, payload []byte, excludes ...string) ([]string, error) {
+	// 1. 复制成员，推送时不持有锁
+	m.RLock()
+	r, ok := m.rooms[roomID]
+	if !ok {
+		m.RUnlock()
+		return nil, errno.Statusf(pkt.Status_NoDestination, "room %s not found", roomID)
+	}
+	members := make([]IChannel, 0, len(r.members))
+	for id, ch := range r.members {
+		if !contains(excludes, id) {
+			members = append(members, ch)
+		}
+	}
+	m.RUnlock()
+	atomic.AddInt64(&m.broadcasts, 1)
+
+	// 2. 最多Concurrency个goroutine推送
+	workers := m.options.Concurrency
+	if workers > len(members) {
+		workers = len(members)
+	}
+	var (
+		next   int64 = -1
+		lock   sync.Mutex
+		failed []string
+		wg     sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		gox.Go(func() {
+			defer wg.Done()
+			for {
+				idx := int(atomic.AddInt64(&next, 1))
+				if idx >= len(members) {
+					return
+				}
+				if err := members[idx].Push(payload); err != nil {
+					lock.Lock()
+					failed = append(failed, members[idx].ID())
+					lock.Unlock()
+				}
+			}
+		})
+	}
+	wg.Wait()
+	atomic.AddInt64(&m.pushFailures, int64(len(failed)))
+	return failed, nil
+}
+
+func (m *RoomManager) Stats() RoomManagerStats {
+	m.RLock()
+	stats := RoomManagerStats{
+		Joins:        atomic.LoadInt64(&m.joins),
+		Leaves:       atomic.LoadInt64(&m.leaves),
+		Broadcasts:   atomic.LoadInt64(&m.broadcasts),
+		PushFailures: atomic.LoadInt64(&m.pushFailures),
+		Rooms:        make([]RoomStats, 0, len(m.rooms)),
+	}
+	for id, r := range m.rooms {
+		stats.Members += len(r.members)
+		stats.Rooms = append(stats.Rooms, RoomStats{Room: id, Size: len(r.members), Joins: r.joins, Leaves: r.leaves})
+	}
+	m.RUnlock()
+	sort.Slice(stats.Rooms, func(i, j int) bool {
+		if stats.Rooms[i].Size != stats.Rooms[j].Size {
+			return stats.Rooms[i].Size > stats.Rooms[j].Size
+		}
+		return stats.Rooms[i].Room < stats.Rooms[j].Room
+	})
+	return stats
+}
+
+// ReportStats 每interval把房间统计和这段时间的进出次数写到日志，直到ctx结束，通常用gox.Go运行
+func (m *RoomManager) ReportStats(ctx context.Context, interval time.Duration) {
+	log := logger.WithFields(zap.String("module", "rooms"))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last RoomManagerStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		stats := m.Stats()
+		largest := stats.Rooms
+		if len(largest) > statsTopRooms {
+			largest = largest[:statsTopRooms]
+		}
+		log.Info("room stats",
+			zap.Int("rooms", len(stats.Rooms)),
+			zap.Int("members", stats.Members),
+			zap.Int64("joins", stats.Joins-last.Joins),
+			zap.Int64("leaves", stats.Leaves-last.Leaves),
+			zap.Int64("broadcasts", stats.Broadcasts-last.Broadcasts),
+			zap.Int64("push_failures", stats.PushFailures-last.PushFailures),
+			zap.Any("largest", largest),
+		)
+		last = stats
+	}
+}
+
+func contains(ids []string, id string) bool {
+	for _, s := range ids {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
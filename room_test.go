@@ -0,0 +1,231 @@
+package gim
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+// pushChannel 记录收到的推送，closed时推送失败
+type pushChannel struct {
+	IChannel
+	sync.Mutex
+	id       string
+	closed   bool
+	payloads [][]byte
+}
+
+func (c *pushChannel) ID() string {
+	return c.id
+}
+
+func (c *pushChannel) Push(payload []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return errno.Statusf(pkt.Status_ConnectionClosed, "channel %s has closed", c.id)
+	}
+	c.payloads = append(c.payloads, payload)
+	return nil
+}
+
+// blockingChannel 推送阻塞到release关闭，记录同时推送的最大数peak
+type blockingChannel struct {
+	IChannel
+	id      string
+	release <-chan struct{}
+	active  *int64
+	peak    *int64
+	pushed  *int64
+}
+
+func (c *blockingChannel) ID() string {
+	return c.id
+}
+
+func (c *blockingChannel) Push([]byte) error {
+	n := atomic.AddInt64(c.active, 1)
+	for {
+		old := atomic.LoadInt64(c.peak)
+		if n <= old || atomic.CompareAndSwapInt64(c.peak, old, n) {
+			break
+		}
+	}
+	<-c.release
+	atomic.AddInt64(c.active, -1)
+	atomic.AddInt64(c.pushed, 1)
+	return nil
+}
+
+// notifyChannels 模拟Server，移除连接后调用断开回调
+type notifyChannels struct {
+	IChannelMap
+	DisconnectHooks
+}
+
+func (c *notifyChannels) disconnect(id string) {
+	c.Remove(id)
+	c.Disconnected(id)
+}
+
+func newMembers(channels IChannelMap, n int) []*pushChannel {
+	members := make([]*pushChannel, n)
+	for i := range members {
+		members[i] = &pushChannel{id: "channel-" + strconv.Itoa(i)}
+		channels.Add(members[i])
+	}
+	return members
+}
+
+func TestRoomManager_JoinLeave(t *testing.T) {
+	channels := NewChannels()
+	members := newMembers(channels, 3)
+	rooms := NewRoomManager(channels, nil)
+	for _, ch := range members {
+		if err := rooms.Join("live", ch.ID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = rooms.Join("live", members[0].ID()) // 重复加入不计数
+	_ = rooms.Join("group", members[0].ID())
+	if err := rooms.Join("live", "unknown"); errno.Code(err) != pkt.Status_SessionNotFound {
+		t.Fatalf("join with unknown channel got %v", err)
+	}
+	if rooms.Size("live") != 3 || rooms.Size("group") != 1 || len(rooms.Rooms(members[0].ID())) != 2 {
+		t.Fatalf("live=%d group=%d", rooms.Size("live"), rooms.Size("group"))
+	}
+
+	rooms.Leave("live", members[1].ID())
+	rooms.Leave("live", members[1].ID()) // 重复离开不计数
+	rooms.LeaveAll(members[0].ID())
+	if rooms.Size("live") != 1 || rooms.Size("group") != 0 || len(rooms.Rooms(members[0].ID())) != 0 {
+		t.Fatalf("live=%d group=%d", rooms.Size("live"), rooms.Size("group"))
+	}
+	// 空房间被删除
+	stats := rooms.Stats()
+	if len(stats.Rooms) != 1 || stats.Rooms[0].Room != "live" || stats.Rooms[0].Joins != 3 || stats.Rooms[0].Leaves != 2 {
+		t.Fatalf("stats.Rooms = %+v", stats.Rooms)
+	}
+	if stats.Members != 1 || stats.Joins != 4 || stats.Leaves != 3 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestRoomManager_Broadcast(t *testing.T) {
+	channels := NewChannels()
+	members := newMembers(channels, 10)
+	rooms := NewRoomManager(channels, nil)
+	for _, ch := range members {
+		_ = rooms.Join("live", ch.ID())
+	}
+
+	members[1].closed = true
+	failed, err := rooms.Broadcast("live", []byte("hello"), members[2].ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0] != members[1].ID() {
+		t.Fatalf("failed = %v, want [%s]", failed, members[1].ID())
+	}
+	for i, ch := range members {
+		want := 1
+		if i == 1 || i == 2 {
+			want = 0
+		}
+		if len(ch.payloads) != want {
+			t.Fatalf("%s got %d payloads, want %d", ch.ID(), len(ch.payloads), want)
+		}
+	}
+	if _, err := rooms.Broadcast("nobody", []byte("hello")); errno.Code(err) != pkt.Status_NoDestination {
+		t.Fatalf("broadcast to unknown room got %v", err)
+	}
+	if stats := rooms.Stats(); stats.Broadcasts != 1 || stats.PushFailures != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestRoomManager_BroadcastConcurrency(t *testing.T) {
+	const concurrency = 4
+	var active, peak, pushed int64
+	release := make(chan struct{})
+	channels := NewChannels()
+	rooms := NewRoomManager(channels, nil, WithRoomConcurrency(concurrency))
+	for i := 0; i < 20; i++ {
+		ch := &blockingChannel{id: "channel-" + strconv.Itoa(i), release: release, active: &active, peak: &peak, pushed: &pushed}
+		channels.Add(ch)
+		_ = rooms.Join("live", ch.ID())
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := rooms.Broadcast("live", []byte("hello"))
+		done <- err
+	}()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&active) < concurrency {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d pushes started", atomic.LoadInt64(&active))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// 已经有concurrency个推送阻塞，不会再启动新的推送
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt64(&active); n != concurrency {
+		t.Fatalf("%d pushes are running, want %d", n, concurrency)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if peak != concurrency || pushed != 20 {
+		t.Fatalf("peak=%d pushed=%d, want %d and 20", peak, pushed, concurrency)
+	}
+}
+
+func TestRoomManager_Disconnect(t *testing.T) {
+	channels := &notifyChannels{IChannelMap: NewChannels()}
+	members := newMembers(channels, 3)
+	rooms := NewRoomManager(channels, channels)
+	for _, ch := range members {
+		_ = rooms.Join("live", ch.ID())
+	}
+	_ = rooms.Join("group", members[0].ID())
+
+	// 不需要调用RoomManager.Disconnect，Server断开连接时自动清理
+	channels.disconnect(members[0].ID())
+	if rooms.Size("live") != 2 || rooms.Size("group") != 0 || len(rooms.Rooms(members[0].ID())) != 0 {
+		t.Fatalf("membership is not cleaned up, live=%d group=%d", rooms.Size("live"), rooms.Size("group"))
+	}
+	// 断开之后不能再加入
+	if err := rooms.Join("live", members[0].ID()); errno.Code(err) != pkt.Status_SessionNotFound {
+		t.Fatalf("join after disconnect got %v", err)
+	}
+	if stats := rooms.Stats(); stats.Members != 2 || stats.Leaves != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestRoomManager_ReportStats(t *testing.T) {
+	channels := NewChannels()
+	members := newMembers(channels, 2)
+	rooms := NewRoomManager(channels, nil)
+	_ = rooms.Join("live", members[0].ID())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		rooms.ReportStats(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ReportStats did not return after ctx is done")
+	}
+}
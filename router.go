@@ -0,0 +1,97 @@
+package gim
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/pkg/logger"
+	"github.com/kkakoz/gim/proto/pkt"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// HandlerFunc 处理一个命令，返回错误时由Router转换为错误响应
+type HandlerFunc func(ctx *Context) error
+
+// Context 一次请求的上下文
+type Context struct {
+	Agent
+	Packet *pkt.LogicPkt
+}
+
+// Resp 回复成功响应
+func (c *Context) Resp(body proto.Message) error {
+	resp := pkt.NewFrom(&c.Packet.Header)
+	resp.Status = pkt.Status_Success
+	resp.WriteBody(body)
+	return c.Push(pkt.Marshal(resp))
+}
+
+// Router 按Command把消息包分发给处理函数
+type Router struct {
+	lock     sync.RWMutex
+	handlers map[string]HandlerFunc
+}
+
+var _ MessageListener = (*Router)(nil)
+
+func NewRouter() *Router {
+	return &Router{handlers: make(map[string]HandlerFunc)}
+}
+
+func (r *Router) Handle(command string, handler HandlerFunc) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.handlers[command] = handler
+}
+
+// Serve 调用命令的处理函数，请求出错时回复带Status和错误描述的响应
+func (r *Router) Serve(agent Agent, packet *pkt.LogicPkt) error {
+	r.lock.RLock()
+	handler, ok := r.handlers[packet.Command]
+	r.lock.RUnlock()
+	var err error
+	if ok {
+		err = handler(&Context{Agent: agent, Packet: packet})
+	} else {
+		err = errno.Statusf(pkt.Status_InvalidCommand, "command %s is not supported", packet.Command)
+	}
+	if err == nil {
+		return nil
+	}
+	// 推送和响应不需要回复
+	if packet.Flag != pkt.Flag_Request {
+		return err
+	}
+	if perr := agent.Push(pkt.Marshal(ErrorResp(&packet.Header, err))); perr != nil {
+		return perr
+	}
+	return err
+}
+
+// Receive 实现MessageListener，解析消息包后调用Serve
+func (r *Router) Receive(agent Agent, payload []byte) {
+	log := logger.WithFields(zap.String("module", "router"), zap.String("id", agent.ID()))
+	packet, err := pkt.Read(bytes.NewReader(payload))
+	if err != nil {
+		log.Warn("decode packet err:" + err.Error())
+		return
+	}
+	if err = r.Serve(agent, packet); err != nil {
+		log.Info(fmt.Sprintf("serve %s err:%s", packet.Command, err.Error()))
+	}
+}
+
+// ErrorResp 根据请求头和错误生成错误响应，错误描述和详情放在errno.MetaErrorMsg和errno.MetaErrorDetails中，
+// 接收方用errno.FromHeader还原
+func ErrorResp(req *pkt.Header, err error) *pkt.LogicPkt {
+	resp := pkt.NewFrom(req)
+	s := errno.FromError(err)
+	resp.Status = s.Code
+	resp.AddStringMeta(errno.MetaErrorMsg, s.Msg)
+	resp.DelMeta(errno.MetaErrorDetails)
+	resp.AddMetaValues(errno.MetaErrorDetails, s.Details...)
+	return resp
+}
@@ -0,0 +1,104 @@
+package gim
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+)
+
+func TestRouterErrorResp(t *testing.T) {
+	r := NewRouter()
+	r.Handle(pkt.CommandChatUserTalk, func(ctx *Context) error {
+		return errno.NewStatus(pkt.Status_Unauthorized, "not a friend", "u2 blocked u1", "since, 2024")
+	})
+	r.Handle(pkt.CommandChatTalkAck, func(ctx *Context) error {
+		return ctx.Resp(nil)
+	})
+	agent := &pushChannel{id: "gateway-1"}
+	read := func() *pkt.LogicPkt {
+		t.Helper()
+		if len(agent.payloads) != 1 {
+			t.Fatalf("got %d responses, want 1", len(agent.payloads))
+		}
+		resp, err := pkt.Read(bytes.NewReader(agent.payloads[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		agent.payloads = nil
+		return resp
+	}
+
+	req := pkt.New(pkt.CommandChatUserTalk, pkt.WithSeq(7), pkt.WithChannel("u1"))
+	r.Receive(agent, pkt.Marshal(req))
+	resp := read()
+	if resp.Flag != pkt.Flag_Response || resp.Sequence != 7 {
+		t.Fatalf("unexpected error response %s", resp)
+	}
+	// 接收方还原为同样的Status
+	s := errno.FromError(errno.FromHeader(&resp.Header))
+	if s == nil || s.Code != pkt.Status_Unauthorized || s.Msg != "not a friend" || !reflect.DeepEqual(s.Details, []string{"u2 blocked u1", "since, 2024"}) {
+		t.Fatalf("FromHeader = %+v", s)
+	}
+
+	r.Receive(agent, pkt.Marshal(pkt.New("unknown.command")))
+	if resp = read(); resp.Status != pkt.Status_InvalidCommand {
+		t.Fatalf("unknown command got status %s", resp.Status)
+	}
+
+	r.Receive(agent, pkt.Marshal(pkt.New(pkt.CommandChatTalkAck)))
+	if resp = read(); resp.Status != pkt.Status_Success {
+		t.Fatalf("ack got status %s", resp.Status)
+	}
+
+	// 推送出错时不回复
+	push := pkt.New(pkt.CommandChatUserTalk)
+	push.Flag = pkt.Flag_Push
+	if err := r.Serve(agent, push); errno.Code(err) != pkt.Status_Unauthorized || len(agent.payloads) != 0 {
+		t.Fatalf("push got %v and %d responses", err, len(agent.payloads))
+	}
+}
+
+// frameClient 按顺序返回frames，读完后返回ConnectionClosed
+type frameClient struct {
+	Client
+	frames []Frame
+}
+
+func (c *frameClient) Read() (Frame, error) {
+	if len(c.frames) == 0 {
+		return nil, errno.ErrConnClosed
+	}
+	frame := c.frames[0]
+	c.frames = c.frames[1:]
+	return frame, nil
+}
+
+func TestReadPacket(t *testing.T) {
+	req := pkt.New(pkt.CommandChatUserTalk, pkt.WithSeq(1))
+	push := pkt.New(pkt.CommandChatUserTalk)
+	push.Flag = pkt.Flag_Push
+	cli := &frameClient{frames: []Frame{
+		&testFrame{code: OpPong},
+		&testFrame{code: OpBinary, payload: pkt.Marshal(ErrorResp(&req.Header, errno.ErrTimeout))},
+		&testFrame{code: OpBinary, payload: pkt.Marshal(push)},
+		&testFrame{code: OpBinary, payload: []byte("hello")},
+	}}
+
+	resp, err := ReadPacket(cli)
+	if resp == nil || resp.Sequence != 1 || !errors.Is(err, errno.ErrTimeout) || err.Error() != errno.ErrTimeout.Error() {
+		t.Fatalf("ReadPacket got %v, %v", resp, err)
+	}
+	if got, err := ReadPacket(cli); err != nil || got.Flag != pkt.Flag_Push {
+		t.Fatalf("ReadPacket got %v, %v, want the push", got, err)
+	}
+	if _, err := ReadPacket(cli); errno.Code(err) != pkt.Status_InvalidPacketBody {
+		t.Fatalf("ReadPacket with an invalid payload got %v", err)
+	}
+	if _, err := ReadPacket(cli); !errors.Is(err, errno.ErrConnClosed) {
+		t.Fatalf("ReadPacket after close got %v", err)
+	}
+}
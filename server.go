@@ -3,11 +3,13 @@ package gim
 import (
 	"context"
 	"net"
+	"sync"
 	"time"
 )
 
 type Server interface {
 	ServiceRegistration
+	DisconnectNotifier
 	SetAcceptor(Acceptor)
 	SetMessageListener(MessageListener)
 	SetStateListener(StateListener)
@@ -19,16 +21,68 @@ type Server interface {
 	Shutdown(context.Context) error
 }
 
+// ReadyNotifier 开始监听后关闭Ready返回的channel，容器据此判断Start已经运行
+type ReadyNotifier interface {
+	Ready() <-chan struct{}
+}
+
 // Acceptor 握手相关操作
 type Acceptor interface {
 	Accept(Conn, time.Duration) (string, error)
 }
 
+// MetaAcceptor 握手时同时返回连接属性的Acceptor，Server优先使用
+type MetaAcceptor interface {
+	Acceptor
+	AcceptWithMeta(Conn, time.Duration) (string, *ChannelMeta, error)
+}
+
+// AcceptWithMeta 不是MetaAcceptor时只有Account属性
+func AcceptWithMeta(acceptor Acceptor, conn Conn, timeout time.Duration) (string, *ChannelMeta, error) {
+	if ma, ok := acceptor.(MetaAcceptor); ok {
+		return ma.AcceptWithMeta(conn, timeout)
+	}
+	id, err := acceptor.Accept(conn, timeout)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, &ChannelMeta{Account: id}, nil
+}
+
 // StateListener 上报断开连接
 type StateListener interface {
 	Disconnect(string) error
 }
 
+// DisconnectNotifier 连接断开时先调用注册的回调，再调用StateListener，Server都需要实现
+type DisconnectNotifier interface {
+	OnDisconnect(fn func(channelID string))
+}
+
+// DisconnectHooks Server内嵌后实现DisconnectNotifier，零值可用
+type DisconnectHooks struct {
+	lock  sync.RWMutex
+	hooks []func(channelID string)
+}
+
+var _ DisconnectNotifier = (*DisconnectHooks)(nil)
+
+func (h *DisconnectHooks) OnDisconnect(fn func(channelID string)) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.hooks = append(h.hooks, fn)
+}
+
+// Disconnected Server在连接断开、从IChannelMap中移除之后调用
+func (h *DisconnectHooks) Disconnected(channelID string) {
+	h.lock.RLock()
+	hooks := h.hooks
+	h.lock.RUnlock()
+	for _, fn := range hooks {
+		fn(channelID)
+	}
+}
+
 // MessageListener 消息监听器
 type MessageListener interface {
 	Receive(Agent, []byte)
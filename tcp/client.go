@@ -2,12 +2,12 @@ package tcp
 
 import (
 	"fmt"
-	"github.com/gobwas/ws"
-	"github.com/gobwas/ws/wsutil"
 	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/pkg/gox"
 	"github.com/kkakoz/gim/pkg/logger"
-	"github.com/pkg/errors"
-	"net/url"
+	"github.com/kkakoz/gim/proto/pkt"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -24,10 +24,11 @@ type client struct {
 	conn gim.Conn
 	gim.Dialer
 	options *gim.ClientOptions
+	meta    map[string]string
 }
 
 func (c *client) GetMeta() map[string]string {
-	return map[string]string{}
+	return c.meta
 }
 
 func (c *client) ServiceID() string {
@@ -39,7 +40,7 @@ func (c *client) ServiceName() string {
 }
 
 func (c *client) Connect(addr string) error {
-	_, err := url.Parse(addr)
+	_, _, err := net.SplitHostPort(addr)
 	if err != nil {
 		return err
 	}
@@ -62,12 +63,12 @@ func (c *client) Connect(addr string) error {
 	c.conn = NewConn(conn)
 
 	if c.options.Heartbeat > 0 {
-		go func() {
+		gox.Go(func() {
 			err := c.heartbeatLoop(c.conn)
 			if err != nil {
 				logger.Error("heartbealoop stopped: " + err.Error())
 			}
-		}()
+		})
 	}
 	return nil
 }
@@ -77,32 +78,50 @@ func (c *client) SetDialer(dialer gim.Dialer) {
 }
 
 func (c *client) Send(bytes []byte) error {
-	return c.conn.WriteFrame(gim.OpBinary, bytes)
+	if c.conn == nil {
+		return errno.NewStatus(pkt.Status_ConnectionClosed, "connection is nil")
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.options.WriteWait > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+	}
+	return gim.TransportError(c.conn.WriteFrame(gim.OpBinary, bytes))
 }
 
 func (c *client) Read() (gim.Frame, error) {
 	if c.conn == nil {
-		return nil, errors.New("connection is nil")
+		return nil, errno.NewStatus(pkt.Status_ConnectionClosed, "connection is nil")
 	}
 	if c.options.ReadWait > 0 {
 		_ = c.conn.SetReadDeadline(time.Now().Add(c.options.ReadWait))
 	}
 	frame, err := c.conn.ReadFrame()
 	if err != nil {
-		return nil, err
+		return nil, gim.TransportError(err)
 	}
 	if frame.GetOpCode() == gim.OpClose {
-		return nil, errors.New("remote side close the channel")
+		return nil, errno.NewStatus(pkt.Status_ConnectionClosed, "remote side close the channel", string(frame.GetPayload()))
 	}
 	return frame, nil
 }
 
+// ReadPacket 见gim.ReadPacket
+func (c *client) ReadPacket() (*pkt.LogicPkt, error) {
+	return gim.ReadPacket(c)
+}
+
 func (c *client) Close() {
-	_ = c.conn.Close()
+	c.once.Do(func() {
+		if c.conn != nil {
+			_ = c.conn.Close()
+		}
+	})
 }
 
 func (c *client) heartbeatLoop(conn gim.Conn) error {
-	ticker := time.NewTicker(time.Second * 30)
+	ticker := time.NewTicker(c.options.Heartbeat)
+	defer ticker.Stop()
 	for range ticker.C {
 		if err := c.ping(conn); err != nil {
 			return err
@@ -118,8 +137,8 @@ func (c *client) ping(conn gim.Conn) error {
 	if err != nil {
 		return err
 	}
-	logger.Info(fmt.Sprintf("%s send ping to server", c.id))
-	return wsutil.WriteClientMessage(conn, ws.OpPing, nil)
+	logger.Debug(fmt.Sprintf("%s send ping to server", c.id))
+	return conn.WriteFrame(gim.OpPing, nil)
 }
 
 func NewClient(id string, name string, options ...gim.ClientOptionFunc) *client {
@@ -127,5 +146,14 @@ func NewClient(id string, name string, options ...gim.ClientOptionFunc) *client
 	for _, opt := range options {
 		opt(clientOpts)
 	}
-	return &client{id: id, name: name, options: clientOpts}
+	return &client{id: id, name: name, options: clientOpts, meta: map[string]string{}}
+}
+
+// NewClientWithProps 创建携带服务meta的客户端，用于服务之间的连接
+func NewClientWithProps(id string, name string, meta map[string]string, options ...gim.ClientOptionFunc) *client {
+	cli := NewClient(id, name, options...)
+	if meta != nil {
+		cli.meta = meta
+	}
+	return cli
 }
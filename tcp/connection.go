@@ -1,29 +1,45 @@
 package tcp
 
 import (
+	"errors"
 	"github.com/kkakoz/gim"
 	"github.com/kkakoz/gim/pkg/endian"
+	"github.com/kkakoz/gim/pkg/errno"
 	"io"
 	"net"
 )
 
+// DefaultMaxFrameSize 单个帧payload的最大长度
+const DefaultMaxFrameSize = 4 << 20
+
 type TcpConn struct {
 	net.Conn
+	// 超过此长度的帧返回errno.ErrFrameTooLarge
+	MaxFrameSize uint32
 }
 
 func NewConn(conn net.Conn) *TcpConn {
 	return &TcpConn{
-		Conn: conn,
+		Conn:         conn,
+		MaxFrameSize: DefaultMaxFrameSize,
 	}
 }
 
 func (c *TcpConn) ReadFrame() (gim.Frame, error) {
-	opcode, err := endian.ReadUint8(c.Conn)
+	return ReadFrame(c.Conn, c.MaxFrameSize)
+}
+
+// ReadFrame read a frame from r, the payload length must not exceed max
+func ReadFrame(r io.Reader, max uint32) (gim.Frame, error) {
+	opcode, err := endian.ReadUint8(r)
 	if err != nil {
 		return nil, err
 	}
-	data, err := endian.ReadBytes(c.Conn)
+	data, err := endian.ReadBytesLimit(r, max)
 	if err != nil {
+		if errors.Is(err, endian.ErrTooLarge) {
+			return nil, errno.ErrFrameTooLarge
+		}
 		return nil, err
 	}
 	return &Frame{OpCode: gim.OpCode(opcode), Payload: data}, nil
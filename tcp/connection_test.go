@@ -0,0 +1,32 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/pkg/errno"
+)
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add([]byte{byte(gim.OpBinary), 0, 0, 0, 2, 'h', 'i'})
+	f.Add([]byte{byte(gim.OpClose), 0, 0, 0, 0})
+	f.Add([]byte{byte(gim.OpPing), 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := ReadFrame(bytes.NewReader(data), 1<<16)
+		if err != nil {
+			if len(data) >= 5 && data[1] != 0 && !errors.Is(err, errno.ErrFrameTooLarge) {
+				t.Fatalf("oversize frame not classified: %v", err)
+			}
+			return
+		}
+		w := new(bytes.Buffer)
+		if err := WriteFrame(w, frame.GetOpCode(), frame.GetPayload()); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(w.Bytes(), data[:w.Len()]) {
+			t.Fatalf("round trip mismatch")
+		}
+	})
+}
@@ -4,9 +4,10 @@ import (
 	"context"
 	"fmt"
 	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/pkg/errno"
 	"github.com/kkakoz/gim/pkg/gox"
 	"github.com/kkakoz/gim/pkg/logger"
-	"github.com/pkg/errors"
+	"github.com/kkakoz/gim/proto/pkt"
 	"go.uber.org/zap"
 	"net"
 	"sync"
@@ -20,15 +21,29 @@ type Server struct {
 	gim.Acceptor
 	gim.MessageListener
 	gim.StateListener
-	conn    gim.Conn
-	once    sync.Once
-	options *gim.ServerOptions
+	gim.DisconnectHooks
+	once     sync.Once
+	options  *gim.ServerOptions
+	lock     sync.Mutex // 保护listener，Start和Shutdown在不同的goroutine中调用
+	listener net.Listener
+	quit     *gim.Event
+	ready    *gim.Event
 }
 
 func (s *Server) SetAcceptor(acceptor gim.Acceptor) {
 	s.Acceptor = acceptor
 }
 
+// Ready 监听成功后关闭
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready.Done()
+}
+
+// GetAcceptor 没有设置时返回nil
+func (s *Server) GetAcceptor() gim.Acceptor {
+	return s.Acceptor
+}
+
 func (s *Server) SetMessageListener(listener gim.MessageListener) {
 	s.MessageListener = listener
 }
@@ -47,35 +62,54 @@ func (s *Server) SetChannelMap(channelMap gim.IChannelMap) {
 
 func (s *Server) Start() error {
 	log := logger.WithFields(zap.String("module", "tcp.server"), zap.String("listen", s.listen), zap.String("id", s.ServiceID()))
+	if s.Acceptor == nil {
+		s.Acceptor = new(gim.DefaultAcceptor)
+	}
 	listen, err := net.Listen("tcp", s.listen)
 	if err != nil {
 		return err
 	}
+	// Shutdown先于监听完成时直接退出
+	s.lock.Lock()
+	if s.quit.HasFired() {
+		s.lock.Unlock()
+		_ = listen.Close()
+		return nil
+	}
+	s.listener = listen
+	s.lock.Unlock()
+	s.ready.Fire()
+	log.Info("started\n")
 
 	for {
-		log.Info("started\n")
-		conn, err := listen.Accept()
+		rawconn, err := listen.Accept()
 		if err != nil {
+			if s.quit.HasFired() {
+				return nil
+			}
 			log.Error("accept conn err:" + err.Error())
+			continue
 		}
 		gox.Go(func() {
-			s.conn = NewConn(conn)
-			id, err := s.Accept(s.conn, s.options.LoginWait)
+			conn := NewConn(rawconn)
+			id, meta, err := gim.AcceptWithMeta(s.Acceptor, conn, s.options.LoginWait)
 			if err != nil {
 				logger.Error("acceptor err:" + err.Error())
 			}
 
 			if err != nil {
-				_ = s.conn.WriteFrame(gim.OpClose, []byte(err.Error()))
+				_ = conn.WriteFrame(gim.OpClose, []byte(err.Error()))
 				conn.Close()
+				return
 			}
 			if _, ok := s.Get(id); ok {
 				log.Warn(fmt.Sprintf("channel %s existed", id))
-				_ = s.conn.WriteFrame(gim.OpClose, []byte("channelId is repeated"))
+				_ = conn.WriteFrame(gim.OpClose, []byte("channelId is repeated"))
 				conn.Close()
+				return
 			}
 			// step 4
-			channel := gim.NewChannel(id, s.conn)
+			channel := gim.NewChannel(id, conn, gim.WithChannelMeta(meta))
 			channel.SetWriteWait(s.options.WriteWait)
 			channel.SetReadWait(s.options.ReadWait)
 			s.Add(channel)
@@ -86,6 +120,7 @@ func (s *Server) Start() error {
 			}
 			// step 6
 			s.Remove(channel.ID())
+			s.Disconnected(channel.ID())
 			err = s.Disconnect(channel.ID())
 			if err != nil {
 				log.Warn(err.Error())
@@ -102,11 +137,20 @@ func (s *Server) Push(id string, data []byte) error {
 	if ok {
 		return channel.Push(data)
 	}
-	return errors.New("channel not found")
+	return errno.NewStatus(pkt.Status_SessionNotFound, "channel not found")
 }
 
+// Shutdown 停止接收新连接，关闭已有连接，ctx超时前未全部关闭时返回ctx.Err()
 func (s *Server) Shutdown(ctx context.Context) error {
-	return nil
+	s.once.Do(func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.quit.Fire()
+		if s.listener != nil {
+			_ = s.listener.Close()
+		}
+	})
+	return gim.DrainChannels(ctx, s.IChannelMap)
 }
 
 func NewServer(listen string, service gim.ServiceRegistration, optsfunc ...gim.ServerOptionsFunc) gim.Server {
@@ -117,6 +161,9 @@ func NewServer(listen string, service gim.ServiceRegistration, optsfunc ...gim.S
 	return &Server{
 		listen:              listen,
 		ServiceRegistration: service,
+		IChannelMap:         gim.NewIndexedChannelMap(), // 连接管理器
 		options:             serverOption,
+		quit:                gim.NewEvent(),
+		ready:               gim.NewEvent(),
 	}
 }
@@ -0,0 +1,135 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/naming"
+)
+
+type nopListener struct{}
+
+func (nopListener) Receive(gim.Agent, []byte) {}
+
+func (nopListener) Disconnect(string) error { return nil }
+
+func newTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	// 先占用一个空闲端口
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	srv := NewServer(addr, &naming.DefaultService{Id: "gateway-1", Name: "gateway"}).(*Server)
+	srv.SetMessageListener(nopListener{})
+	srv.SetStateListener(nopListener{})
+	return srv, addr
+}
+
+// login 连接服务并用旧版本握手登录，等待连接加入IChannelMap
+func login(t *testing.T, srv *Server, addr, id string) net.Conn {
+	t.Helper()
+	var conn net.Conn
+	deadline := time.Now().Add(time.Second)
+	for {
+		var err error
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 旧版本握手，payload就是userId
+	if err := WriteFrame(conn, gim.OpText, []byte(id)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if _, ok := srv.Get(id); ok {
+			return conn
+		}
+		if i > 100 {
+			t.Fatal("channel was not added")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_ShutdownBeforeStart(t *testing.T) {
+	srv, _ := newTestServer(t)
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Start()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start keeps serving after Shutdown")
+	}
+}
+
+func TestServer_ShutdownDrain(t *testing.T) {
+	srv, addr := newTestServer(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Start()
+	}()
+
+	conn := login(t, srv, addr, "u1")
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if gim.ChannelCount(srv) != 0 {
+		t.Fatalf("%d channels left after Shutdown", gim.ChannelCount(srv))
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// 连接已经被服务端关闭
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := NewConn(conn).ReadFrame(); err == nil {
+		t.Fatal("connection is still open after Shutdown")
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("server still accepts connections after Shutdown")
+	}
+}
+
+func TestServer_DisconnectLeavesRooms(t *testing.T) {
+	srv, addr := newTestServer(t)
+	rooms := gim.NewRoomManager(srv, srv)
+	go func() {
+		_ = srv.Start()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+
+	conn := login(t, srv, addr, "u1")
+	if err := rooms.Join("live", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	for i := 0; rooms.Size("live") != 0; i++ {
+		if i > 100 {
+			t.Fatal("u1 is still in the room after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -5,9 +5,10 @@ import (
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/pkg/errno"
 	"github.com/kkakoz/gim/pkg/gox"
 	"github.com/kkakoz/gim/pkg/logger"
-	"github.com/pkg/errors"
+	"github.com/kkakoz/gim/proto/pkt"
 	"net"
 	"net/url"
 	"sync"
@@ -25,7 +26,8 @@ type client struct {
 
 	conn net.Conn
 	gim.Dialer
-	options *gim.ClientOptions
+	options      *gim.ClientOptions
+	maxFrameSize int64
 }
 
 func (c *client) ServiceID() string {
@@ -80,7 +82,7 @@ func (c *client) SetDialer(dialer gim.Dialer) {
 
 func (c *client) Send(payload []byte) error {
 	if atomic.LoadInt32(&c.state) == 0 {
-		return fmt.Errorf("connection is nil")
+		return errno.NewStatus(pkt.Status_ConnectionClosed, "connection is nil")
 	}
 	c.Lock()
 	defer c.Unlock()
@@ -89,26 +91,29 @@ func (c *client) Send(payload []byte) error {
 		return err
 	}
 	// 客户端消息需要使用MASK
-	return wsutil.WriteClientMessage(c.conn, ws.OpBinary, payload)
+	return gim.TransportError(wsutil.WriteClientMessage(c.conn, ws.OpBinary, payload))
 }
 
 func (c *client) Read() (gim.Frame, error) {
 	if c.conn == nil {
-		return nil, errors.New("connection is nil")
+		return nil, errno.NewStatus(pkt.Status_ConnectionClosed, "connection is nil")
 	}
 	if c.options.ReadWait > 0 {
 		_ = c.conn.SetReadDeadline(time.Now().Add(c.options.ReadWait))
 	}
-	frame, err := ws.ReadFrame(c.conn)
+	frame, err := ReadFrame(c.conn, c.maxFrameSize)
 	if err != nil {
-		return nil, err
+		return nil, gim.TransportError(err)
 	}
-	if frame.Header.OpCode == ws.OpClose {
-		return nil, errors.New("remote side close the channel")
+	if frame.GetOpCode() == gim.OpClose {
+		return nil, errno.NewStatus(pkt.Status_ConnectionClosed, "remote side close the channel", string(frame.GetPayload()))
 	}
-	return &Frame{
-		raw: frame,
-	}, nil
+	return frame, nil
+}
+
+// ReadPacket 见gim.ReadPacket
+func (c *client) ReadPacket() (*pkt.LogicPkt, error) {
+	return gim.ReadPacket(c)
 }
 
 func (c *client) Close() {
@@ -141,5 +146,5 @@ func NewClient(id string, name string, options ...gim.ClientOptionFunc) *client
 	for _, opt := range options {
 		opt(clientOpts)
 	}
-	return &client{id: id, name: name, options: clientOpts}
+	return &client{id: id, name: name, options: clientOpts, maxFrameSize: DefaultMaxFrameSize}
 }
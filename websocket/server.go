@@ -2,13 +2,16 @@ package websocket
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gobwas/ws"
 	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/pkg/errno"
 	"github.com/kkakoz/gim/pkg/gox"
 	"github.com/kkakoz/gim/pkg/logger"
-	"github.com/pkg/errors"
+	"github.com/kkakoz/gim/proto/pkt"
 	"go.uber.org/zap"
+	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -22,14 +25,24 @@ type Server struct {
 	gim.Acceptor
 	gim.MessageListener
 	gim.StateListener
+	gim.DisconnectHooks
 	once    sync.Once
 	options *gim.ServerOptions
+	lock    sync.Mutex // 保护httpSrv和closed，Start和Shutdown在不同的goroutine中调用
+	httpSrv *http.Server
+	closed  bool
+	ready   *gim.Event
 }
 
 func (s *Server) SetAcceptor(acceptor gim.Acceptor) {
 	s.Acceptor = acceptor
 }
 
+// Ready 监听成功后关闭
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready.Done()
+}
+
 func (s *Server) SetMessageListener(listener gim.MessageListener) {
 	s.MessageListener = listener
 }
@@ -49,13 +62,27 @@ func (s *Server) SetChannelMap(channelMap gim.IChannelMap) {
 func (s *Server) Push(id string, data []byte) error {
 	ch, ok := s.IChannelMap.Get(id)
 	if !ok {
-		return errors.New("channel no found")
+		return errno.NewStatus(pkt.Status_SessionNotFound, "channel no found")
 	}
 	return ch.Push(data)
 }
 
+// Shutdown 停止接收新连接，关闭已有连接，ctx超时前未全部关闭时返回ctx.Err()
 func (s *Server) Shutdown(ctx context.Context) error {
-	return nil
+	var err error
+	s.once.Do(func() {
+		s.lock.Lock()
+		s.closed = true
+		httpSrv := s.httpSrv
+		s.lock.Unlock()
+		if httpSrv != nil {
+			err = httpSrv.Shutdown(ctx)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return gim.DrainChannels(ctx, s.IChannelMap)
 }
 
 func NewServer(listen string, service gim.ServiceRegistration, optsfunc ...gim.ServerOptionsFunc) gim.Server {
@@ -66,7 +93,9 @@ func NewServer(listen string, service gim.ServiceRegistration, optsfunc ...gim.S
 	return &Server{
 		listen:              listen,
 		ServiceRegistration: service,
+		IChannelMap:         gim.NewIndexedChannelMap(), // 连接管理器
 		options:             serverOption,
+		ready:               gim.NewEvent(),
 	}
 }
 
@@ -80,11 +109,6 @@ func (s *Server) Start() error {
 	if s.StateListener == nil {
 		return fmt.Errorf("StateListener is nil")
 	}
-	// 连接管理器
-	if s.IChannelMap == nil {
-		s.IChannelMap = gim.NewChannels()
-	}
-
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// step 1
 		rawconn, _, _, err := ws.UpgradeHTTP(r, w)
@@ -100,7 +124,7 @@ func (s *Server) Start() error {
 		conn := NewConn(rawconn)
 
 		// step 3
-		id, err := s.Accept(conn, s.options.LoginWait)
+		id, meta, err := gim.AcceptWithMeta(s.Acceptor, conn, s.options.LoginWait)
 		if err != nil {
 			_ = conn.WriteFrame(gim.OpClose, []byte(err.Error()))
 			conn.Close()
@@ -113,7 +137,7 @@ func (s *Server) Start() error {
 			return
 		}
 		// step 4
-		channel := gim.NewChannel(id, conn)
+		channel := gim.NewChannel(id, conn, gim.WithChannelMeta(meta))
 		channel.SetWriteWait(s.options.WriteWait)
 		channel.SetReadWait(s.options.ReadWait)
 		s.Add(channel)
@@ -125,6 +149,7 @@ func (s *Server) Start() error {
 			}
 			// step 6
 			s.Remove(channel.ID())
+			s.Disconnected(channel.ID())
 			err = s.Disconnect(channel.ID())
 			if err != nil {
 				log.Warn(err.Error())
@@ -136,6 +161,25 @@ func (s *Server) Start() error {
 	mux.HandleFunc("/test", func(writer http.ResponseWriter, request *http.Request) {
 		writer.Write([]byte("test handler"))
 	})
+	listener, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	// Shutdown先于监听完成时直接退出
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		_ = listener.Close()
+		return nil
+	}
+	httpSrv := &http.Server{Addr: s.listen, Handler: mux}
+	s.httpSrv = httpSrv
+	s.lock.Unlock()
+	s.ready.Fire()
 	log.Info("started\n")
-	return http.ListenAndServe(s.listen, mux)
+	err = httpSrv.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
 }
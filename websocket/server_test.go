@@ -0,0 +1,33 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kkakoz/gim/naming"
+)
+
+type nopListener struct{}
+
+func (nopListener) Disconnect(string) error { return nil }
+
+func TestServer_ShutdownBeforeStart(t *testing.T) {
+	srv := NewServer("127.0.0.1:0", &naming.DefaultService{Id: "gateway-1", Name: "gateway"})
+	srv.SetStateListener(nopListener{})
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Start()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start keeps serving after Shutdown")
+	}
+}
@@ -1,27 +1,34 @@
 package websocket
 
 import (
+	"errors"
 	"github.com/gobwas/ws"
 	"github.com/kkakoz/gim"
+	"github.com/kkakoz/gim/pkg/errno"
+	"github.com/kkakoz/gim/proto/pkt"
+	"io"
 	"net"
+	"sync"
 )
 
+// DefaultMaxFrameSize 单个帧payload的最大长度
+const DefaultMaxFrameSize = 4 << 20
+
 type WsConn struct {
 	net.Conn
+	// 超过此长度的帧返回errno.ErrFrameTooLarge
+	MaxFrameSize int64
 }
 
 func NewConn(conn net.Conn) *WsConn {
 	return &WsConn{
-		Conn: conn,
+		Conn:         conn,
+		MaxFrameSize: DefaultMaxFrameSize,
 	}
 }
 
 func (c *WsConn) ReadFrame() (gim.Frame, error) {
-	f, err := ws.ReadFrame(c.Conn)
-	if err != nil {
-		return nil, err
-	}
-	return &Frame{raw: f}, nil
+	return ReadFrame(c.Conn, c.MaxFrameSize)
 }
 
 func (c *WsConn) WriteFrame(code gim.OpCode, payload []byte) error {
@@ -33,26 +40,58 @@ func (c *WsConn) Flush() error {
 	return nil
 }
 
+// ReadFrame read a frame from r, the payload length must not exceed max
+func ReadFrame(r io.Reader, max int64) (gim.Frame, error) {
+	header, err := ws.ReadHeader(r)
+	if err != nil {
+		// 帧头不合法
+		var pe ws.ProtocolError
+		if errors.As(err, &pe) {
+			return nil, errno.WrapStatus(pkt.Status_InvalidPacketBody, err)
+		}
+		return nil, err
+	}
+	if header.Length < 0 || header.Length > max {
+		return nil, errno.ErrFrameTooLarge
+	}
+	payload := make([]byte, header.Length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return &Frame{raw: ws.Frame{Header: header, Payload: payload}}, nil
+}
+
+// Frame payload在第一次读取时解除掩码，可以重复和并发调用
 type Frame struct {
+	sync.Mutex
 	raw ws.Frame
 }
 
 func (f *Frame) SetOpCode(code gim.OpCode) {
+	f.Lock()
+	defer f.Unlock()
 	f.raw.Header.OpCode = ws.OpCode(code)
 }
 
 func (f *Frame) GetOpCode() gim.OpCode {
+	f.Lock()
+	defer f.Unlock()
 	return gim.OpCode(f.raw.Header.OpCode)
 }
 
 func (f *Frame) SetPayload(payload []byte) {
+	f.Lock()
+	defer f.Unlock()
 	f.raw.Payload = payload
+	f.raw.Header.Masked = false
 }
 
 func (f *Frame) GetPayload() []byte {
+	f.Lock()
+	defer f.Unlock()
 	if f.raw.Header.Masked {
 		ws.Cipher(f.raw.Payload, f.raw.Header.Mask, 0)
+		f.raw.Header.Masked = false
 	}
-	f.raw.Header.Masked = false
 	return f.raw.Payload
 }
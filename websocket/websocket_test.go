@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/gobwas/ws"
+)
+
+func maskedFrame(payload []byte) []byte {
+	w := new(bytes.Buffer)
+	f := ws.MaskFrame(ws.NewBinaryFrame(payload))
+	_ = ws.WriteFrame(w, f)
+	return w.Bytes()
+}
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add(maskedFrame([]byte("hello")))
+	f.Add(maskedFrame(nil))
+	f.Add([]byte{0x82, 0x7f, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := ReadFrame(bytes.NewReader(data), 1<<16)
+		if err != nil {
+			return
+		}
+		// 多次、并发读取payload应当得到相同的结果
+		first := append([]byte(nil), frame.GetPayload()...)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !bytes.Equal(first, frame.GetPayload()) {
+					t.Error("payload changed on repeated read")
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}